@@ -37,16 +37,14 @@ type Environment struct {
 	HydraConfig *clientcredentials.Config
 	AapConfig   *clientcredentials.Config
 
+	VerdictCache *VerdictCache
+
 	Driver          neo4j.Driver
 	BannedUsernames map[string]bool
 	IssuerSignKey   *rsa.PrivateKey
 	IssuerVerifyKey *rsa.PublicKey
 	Nats            *nats.Conn
-	TemplateMap     *map[idp.ChallengeType]EmailTemplate
-}
-
-type EmailTemplate struct {
-	Sender  idp.SMTPSender
-	File    string
-	Subject string
+	JetStream       nats.JetStreamContext // Events are published here by jobs.RelayEvents
+	Mailer          idp.Mailer
+	EmailTemplates  map[string]*idp.LocalizedEmailTemplate // By idp.EMAIL_TEMPLATE_*
 }
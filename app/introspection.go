@@ -0,0 +1,88 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	hydra "github.com/charmixer/hydra/client"
+)
+
+const DEFAULT_INTROSPECTION_CACHE_TTL = 30 * time.Second
+const MAX_INTROSPECTION_CACHE_ENTRIES = 10000
+
+type introspectionCacheEntry struct {
+	response  hydra.IntrospectResponse
+	expiresAt time.Time
+}
+
+// IntrospectionCache holds token introspection results for a short while, so a client calling several endpoints does not cost an introspection each.
+// NOTE: A revoked token is accepted until its cache entry expires, keep the ttl short.
+type IntrospectionCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]introspectionCacheEntry // Keyed by token hash, the tokens themselves are never kept in memory longer than the request.
+}
+
+func NewIntrospectionCache(ttl time.Duration) *IntrospectionCache {
+	if ttl <= 0 {
+		ttl = DEFAULT_INTROSPECTION_CACHE_TTL
+	}
+	return &IntrospectionCache{
+		ttl:     ttl,
+		entries: make(map[string]introspectionCacheEntry),
+	}
+}
+
+func (ic *IntrospectionCache) Get(token string) (response hydra.IntrospectResponse, found bool) {
+	key := hashToken(token)
+
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+
+	entry, found := ic.entries[key]
+	if !found {
+		return hydra.IntrospectResponse{}, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		delete(ic.entries, key)
+		return hydra.IntrospectResponse{}, false
+	}
+
+	return entry.response, true
+}
+
+func (ic *IntrospectionCache) Set(token string, response hydra.IntrospectResponse) {
+	now := time.Now()
+
+	// Never cache an active token beyond its own expiry
+	expiresAt := now.Add(ic.ttl)
+	if response.Active && response.Exp > 0 && time.Unix(response.Exp, 0).Before(expiresAt) {
+		expiresAt = time.Unix(response.Exp, 0)
+	}
+
+	ic.lock.Lock()
+	defer ic.lock.Unlock()
+
+	if len(ic.entries) >= MAX_INTROSPECTION_CACHE_ENTRIES {
+		for key, entry := range ic.entries {
+			if now.After(entry.expiresAt) {
+				delete(ic.entries, key)
+			}
+		}
+
+		// Still full of live entries, start over rather than grow without bounds.
+		if len(ic.entries) >= MAX_INTROSPECTION_CACHE_ENTRIES {
+			ic.entries = make(map[string]introspectionCacheEntry)
+		}
+	}
+
+	ic.entries[hashToken(token)] = introspectionCacheEntry{response: response, expiresAt: expiresAt}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,21 +1,25 @@
 package app
 
 import (
+	"context"
 	oidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 	"github.com/opensentry/idp/utils"
 
-	bulky "github.com/charmixer/bulky/client"
-	aap "github.com/opensentry/aap/client"
+	hydra "github.com/charmixer/hydra/client"
 )
 
 func AccessToken(env *Environment, c *gin.Context) *oauth2.Token {
@@ -66,12 +70,25 @@ func FetchRequiredScopes(env *Environment, c *gin.Context) (requiredScopes []str
 	return nil
 }
 
-type AuthorizationConfig struct {
-	LogKey             string
-	AccessTokenKey     string
+type AuthenticationConfig struct {
+	LogKey         string
+	AccessTokenKey string
+
+	// Verifies JWT access tokens locally against the cached JWKS of hydra. Opaque access tokens are not affected.
+	JwtVerifier *oidc.IDTokenVerifier
+
+	// Introspects all access tokens with hydra to make sure they are active (not revoked). Disabled if the url is empty.
 	HydraConfig        *clientcredentials.Config
 	HydraIntrospectUrl string
-	AapConfig          *clientcredentials.Config
+	IntrospectionCache *IntrospectionCache
+}
+
+type AuthorizationConfig struct {
+	LogKey         string
+	AccessTokenKey string
+	HydraConfig    *clientcredentials.Config
+	AapConfig      *clientcredentials.Config
+	VerdictCache   *VerdictCache // Optional, nil judges every request with AAP
 }
 
 func ProcessMethodOverride(r *gin.Engine) gin.HandlerFunc {
@@ -126,6 +143,20 @@ func ProcessMethodOverride(r *gin.Engine) gin.HandlerFunc {
 	}
 }
 
+// RequestMetrics counts and times requests per route. Requests that match no route are labelled with an empty route, so probing for urls does not grow the number of series.
+func RequestMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		status := strconv.Itoa(c.Writer.Status())
+		metrics.HttpRequestsTotal.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.HttpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
 func RequestLogger(logKey string, requestIdKey string, log *logrus.Logger, appFields logrus.Fields) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 
@@ -138,6 +169,9 @@ func RequestLogger(logKey string, requestIdKey string, log *logrus.Logger, appFi
 		requestLog := log.WithFields(appFields).WithFields(logrus.Fields{
 			"request.id": requestId,
 		})
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+			requestLog = requestLog.WithFields(logrus.Fields{"trace.id": spanContext.TraceID().String()})
+		}
 		c.Set(logKey, requestLog)
 
 		c.Next()
@@ -193,10 +227,10 @@ func RequestLogger(logKey string, requestIdKey string, log *logrus.Logger, appFi
 	return gin.HandlerFunc(fn)
 }
 
-func AuthenticationRequired(logKey string, accessTokenKey string) gin.HandlerFunc {
+func AuthenticationRequired(aconf AuthenticationConfig) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 
-		log := c.MustGet(logKey).(*logrus.Entry)
+		log := c.MustGet(aconf.LogKey).(*logrus.Entry)
 		log = log.WithFields(logrus.Fields{
 			"func": "AuthenticationRequired",
 		})
@@ -217,11 +251,42 @@ func AuthenticationRequired(logKey string, accessTokenKey string) gin.HandlerFun
 			// https://godoc.org/golang.org/x/oauth2#Token.Valid
 			if token.Valid() == true {
 
+				// A JWT can be verified without calling anyone, so bad tokens are rejected before any network call is made.
+				if aconf.JwtVerifier != nil && isJwt(token.AccessToken) {
+					_, err := aconf.JwtVerifier.Verify(c.Request.Context(), token.AccessToken)
+					if err != nil {
+						log.WithFields(logrus.Fields{"error": err.Error()}).Debug("JWT access token verification failed")
+						c.JSON(http.StatusUnauthorized, JsonError{ErrorCode: ERROR_INVALID_ACCESS_TOKEN, Error: "Invalid access token."})
+						c.Abort()
+						return
+					}
+				}
+
 				// See #5 of QTNA
-				log.WithFields(logrus.Fields{"fixme": 1, "qtna": 5}).Debug("Missing check against token-revoked-list to check if token is revoked")
+				if aconf.HydraIntrospectUrl != "" {
+					introspection, err := introspectToken(c.Request.Context(), aconf, token.AccessToken)
+					if err != nil {
+						log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Token introspection failed")
+						c.AbortWithStatus(http.StatusInternalServerError)
+						return
+					}
+
+					if introspection.Active == false {
+						log.Debug("Access token not active. Hint: Expired or revoked")
+						c.JSON(http.StatusUnauthorized, JsonError{ErrorCode: ERROR_INVALID_ACCESS_TOKEN, Error: "Invalid access token."})
+						c.Abort()
+						return
+					}
+
+					c.Set("active", introspection.Active)
+					c.Set("sub", introspection.Sub)
+					c.Set("scope", introspection.Scope)
+					c.Set("client_id", introspection.ClientId)
+					c.Set("exp", introspection.Exp)
+				}
 
 				log.Debug("Authenticated")
-				c.Set(accessTokenKey, token)
+				c.Set(aconf.AccessTokenKey, token)
 				c.Next() // Authentication successful, continue.
 				return
 			}
@@ -239,7 +304,33 @@ func AuthenticationRequired(logKey string, accessTokenKey string) gin.HandlerFun
 	return gin.HandlerFunc(fn)
 }
 
+func introspectToken(ctx context.Context, aconf AuthenticationConfig, token string) (introspection hydra.IntrospectResponse, err error) {
+	if aconf.IntrospectionCache != nil {
+		introspection, found := aconf.IntrospectionCache.Get(token)
+		if found {
+			return introspection, nil
+		}
+	}
+
+	introspection, err = idp.IntrospectHydraToken(ctx, aconf.HydraIntrospectUrl, token)
+	if err != nil {
+		return hydra.IntrospectResponse{}, err
+	}
+
+	if aconf.IntrospectionCache != nil {
+		aconf.IntrospectionCache.Set(token, introspection)
+	}
+	return introspection, nil
+}
+
+// Hydra issues either opaque tokens or JWTs (header.payload.signature) depending on its access token strategy.
+func isJwt(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
 func AuthorizationRequired(aconf AuthorizationConfig, requiredScopes ...string) gin.HandlerFunc {
+	registerMountedScopes(requiredScopes...)
+
 	fn := func(c *gin.Context) {
 
 		publisherId := config.GetString("id") // Resource Server (this)
@@ -257,63 +348,37 @@ func AuthorizationRequired(aconf AuthorizationConfig, requiredScopes ...string)
 		}
 		var token *oauth2.Token = t.(*oauth2.Token)
 
-		var judgeRequests []aap.ReadEntitiesJudgeRequest
-		for _, scope := range requiredScopes {
-			judgeRequests = append(judgeRequests, aap.ReadEntitiesJudgeRequest{
-				AccessToken: token.AccessToken,
-				Publisher:   publisherId,
-				Scope:       scope,
-			})
+		var tokenExpiresAt int64
+		if exp, exists := c.Get("exp"); exists {
+			tokenExpiresAt = exp.(int64)
 		}
 
-		aapClient := aap.NewAapClient(aconf.AapConfig)
-		url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.judge")
-		status, responses, err := aap.ReadEntitiesJudge(aapClient, url, judgeRequests)
+		granted, verdict, err := JudgeScopes(c.Request.Context(), aconf.AapConfig, aconf.VerdictCache, token.AccessToken, tokenExpiresAt, publisherId, nil, requiredScopes...)
 		if err != nil {
-			log.Debug(err.Error())
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		if status == http.StatusForbidden {
-			c.AbortWithStatus(http.StatusForbidden)
-			return
-		}
-
-		if status != http.StatusOK {
-			log.WithFields(logrus.Fields{"status": status}).Debug("Call aap.ReadEntitiesJudge failed")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+			if err == errAapForbidden {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
 
-		var verdict aap.ReadEntitiesJudgeResponse
-		status, restErr := bulky.Unmarshal(0, responses, &verdict)
-		if restErr != nil {
-			log.Debug(restErr)
+			log.Debug(err.Error())
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		if status == http.StatusOK {
+		if granted == true {
 
-			if verdict.Granted == true {
-
-				log.WithFields(logrus.Fields{"id": verdict.Identity, "scopes": verdict.Scope}).Debug("Authorized")
-
-				c.Set("sub", verdict.Identity)
-				c.Set("scope", verdict.Scope)
-				c.Next() // Authentication successful, continue.
-				return
-			}
+			log.WithFields(logrus.Fields{"id": verdict.Identity, "scopes": verdict.Scope}).Debug("Authorized")
 
-			log.WithFields(logrus.Fields{"id": verdict.Identity, "scopes": verdict.Scope}).Debug("Forbidden")
-			c.AbortWithStatusJSON(http.StatusForbidden, JsonError{})
+			c.Set("sub", verdict.Identity)
+			c.Set("scope", verdict.Scope)
+			c.Request = c.Request.WithContext(idp.WithEventActor(c.Request.Context(), verdict.Identity))
+			c.Next() // Authentication successful, continue.
 			return
 		}
 
 		// Deny by default
-		log.WithFields(logrus.Fields{"status": status}).Debug("Unmarshal ReadEntitiesJudgeResponse failed")
-		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(logrus.Fields{"id": verdict.Identity, "scopes": verdict.Scope}).Debug("Forbidden")
+		c.AbortWithStatusJSON(http.StatusForbidden, JsonError{})
 		return
 	}
 	return gin.HandlerFunc(fn)
@@ -332,6 +397,7 @@ func RequestId() gin.HandlerFunc {
 
 		// Expose it for use in the application
 		c.Set("RequestId", requestID)
+		c.Request = c.Request.WithContext(idp.WithEventRequestId(c.Request.Context(), requestID))
 
 		// Set X-Request-Id header
 		c.Writer.Header().Set("X-Request-Id", requestID)
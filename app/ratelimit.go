@@ -0,0 +1,157 @@
+package app
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensentry/idp/metrics"
+	"github.com/opensentry/idp/utils"
+)
+
+const rateLimitSweepInterval = 5 * time.Minute
+
+// RateLimit allows Burst requests at once, refilled at Rate requests per second.
+type RateLimit struct {
+	Method string  `mapstructure:"method"`
+	Path   string  `mapstructure:"path"` // Route as mounted in gin, e.g. /humans/authenticate
+	Rate   float64 `mapstructure:"rate"`
+	Burst  int     `mapstructure:"burst"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit // Needed by sweep to tell when the bucket is full again
+}
+
+// RateLimiter keeps a token bucket per route and caller, callers are told apart by client ip and by client_id in buckets of their own. Routes without a limit are not limited.
+type RateLimiter struct {
+	limits         map[string]RateLimit // Keyed by method and route
+	trustedProxies []*net.IPNet
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewRateLimiter(limits []RateLimit, trustedProxies []*net.IPNet) *RateLimiter {
+	rl := &RateLimiter{
+		limits:         make(map[string]RateLimit),
+		trustedProxies: trustedProxies,
+		buckets:        make(map[string]*tokenBucket),
+		lastSweep:      time.Now(),
+	}
+	for _, limit := range limits {
+		if limit.Rate <= 0 || limit.Burst <= 0 {
+			continue
+		}
+		rl.limits[strings.ToUpper(limit.Method)+" "+limit.Path] = limit
+	}
+	return rl
+}
+
+// Allow takes a token from the bucket of key. If none is left it returns how long until the next one.
+func (rl *RateLimiter) Allow(key string, limit RateLimit) (allowed bool, retryAfter time.Duration) {
+	return rl.allow(key, limit, time.Now())
+}
+
+func (rl *RateLimiter) allow(key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.sweep(now)
+
+	bucket, found := rl.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.limit = limit
+
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+}
+
+// sweep drops buckets that have been idle long enough to be full again, as they are no different from a new bucket.
+// How long that takes depends on the limit, a slow refilling bucket may have to be kept for longer than the sweep interval.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.limit.Rate >= float64(bucket.limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// RateLimitRequired limits requests to the routes that have a limit per client ip. It does not need the request to be authenticated,
+// so it also limits the routes registered before AuthenticationRequired.
+// NOTE: Must be put into use before the routes it is to limit, see ClientRateLimitRequired for the limit per client.
+func RateLimitRequired(logKey string, rl *RateLimiter) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		ipData, err := utils.GetClientIpData(c.Request, rl.trustedProxies)
+		if err != nil {
+			c.MustGet(logKey).(*logrus.Entry).WithFields(logrus.Fields{"func": "RateLimitRequired"}).Debug(err.Error())
+		}
+
+		limitRequest(c, logKey, rl, "ip", ipData.Ip)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ClientRateLimitRequired limits requests to the routes that have a limit per client_id, in buckets apart from the ones per client ip,
+// so a client does not get a fresh bucket by changing ip or subject. Requests without a client_id are left to RateLimitRequired.
+// NOTE: Must be put into use after AuthenticationRequired, which sets client_id.
+func ClientRateLimitRequired(logKey string, rl *RateLimiter) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		clientId := c.GetString("client_id")
+		if clientId == "" {
+			c.Next()
+			return
+		}
+
+		limitRequest(c, logKey, rl, "client_id", clientId)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// limitRequest takes a token from the bucket of the route and caller, keyed by what the caller is told apart by.
+func limitRequest(c *gin.Context, logKey string, rl *RateLimiter, by string, caller string) {
+	route := c.Request.Method + " " + c.FullPath()
+	limit, found := rl.limits[route]
+	if !found {
+		c.Next()
+		return
+	}
+
+	allowed, retryAfter := rl.Allow(by+"|"+route+"|"+caller, limit)
+	if allowed {
+		c.Next()
+		return
+	}
+
+	log := c.MustGet(logKey).(*logrus.Entry)
+	log.WithFields(logrus.Fields{"func": "RateLimitRequired", by: caller, "route": route}).Debug("Rate limited")
+	metrics.RateLimitedTotal.WithLabelValues(c.Request.Method, c.FullPath()).Inc()
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, JsonError{ErrorCode: ERROR_RATE_LIMITED, Error: "Too many requests. Hint: Retry after the number of seconds in the Retry-After header"})
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensentry/idp/utils"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limit := RateLimit{Rate: 1, Burst: 2}
+	start := time.Now()
+
+	tests := []struct {
+		after      time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, time.Second},
+		{500 * time.Millisecond, false, 500 * time.Millisecond},
+		{time.Second, true, 0},
+		{time.Second, false, time.Second},
+		{10 * time.Second, true, 0},
+		{10 * time.Second, true, 0}, // Refilled to burst, not beyond
+		{10 * time.Second, false, time.Second},
+	}
+
+	rl := NewRateLimiter(nil, nil)
+	for i, test := range tests {
+		allowed, retryAfter := rl.allow("key", limit, start.Add(test.after))
+		if allowed != test.allowed || retryAfter != test.retryAfter {
+			t.Errorf("Request %d: expected %v retry after %s, got %v retry after %s", i, test.allowed, test.retryAfter, allowed, retryAfter)
+		}
+	}
+
+	if allowed, _ := rl.allow("other key", limit, start.Add(10*time.Second)); !allowed {
+		t.Error("Expected buckets to be kept per key")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	slow := RateLimit{Rate: 0.001, Burst: 1} // Refills in 1000s, longer than the sweep interval
+	fast := RateLimit{Rate: 1, Burst: 1}
+
+	rl := NewRateLimiter(nil, nil)
+	start := time.Now()
+	rl.allow("slow", slow, start)
+	rl.allow("fast", fast, start)
+
+	rl.allow("other", fast, start.Add(rateLimitSweepInterval))
+	if _, found := rl.buckets["fast"]; found {
+		t.Error("Expected the full bucket to be swept")
+	}
+	if allowed, _ := rl.allow("slow", slow, start.Add(rateLimitSweepInterval)); allowed {
+		t.Error("Expected the bucket that is not full again to be kept")
+	}
+}
+
+func TestRateLimitRequiredClientIp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	trustedProxies, err := utils.ParseCidrs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		sameAs     int // Index of the earlier request expected to share the bucket, -1 if none
+	}{
+		{"direct", "203.0.113.1:1234", "", -1},
+		{"direct forged header", "203.0.113.1:1234", "198.51.100.7", 0},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.7", -1},
+		{"other trusted proxy", "10.0.0.2:1234", "198.51.100.7", 2},
+		{"trusted proxy without header", "10.0.0.3:1234", "", -1},
+	}
+
+	for i, test := range tests {
+		rl := NewRateLimiter([]RateLimit{{Method: "GET", Path: "/limited", Rate: 0.001, Burst: 1}}, trustedProxies)
+
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			c.Set("log", logrus.NewEntry(logrus.New()))
+		})
+		r.Use(RateLimitRequired("log", rl))
+		r.GET("/limited", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		codes := make([]int, 0, i+1)
+		for _, earlier := range append([]int{test.sameAs}, i) {
+			if earlier < 0 {
+				continue
+			}
+			req := httptest.NewRequest("GET", "/limited", nil)
+			req.RemoteAddr = tests[earlier].remoteAddr
+			if tests[earlier].forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tests[earlier].forwarded)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1000" {
+				t.Errorf("%s: expected Retry-After 1000, got %s", test.name, w.Header().Get("Retry-After"))
+			}
+		}
+
+		last := codes[len(codes)-1]
+		if test.sameAs >= 0 && last != http.StatusTooManyRequests {
+			t.Errorf("%s: expected to share the bucket of %s, got %d", test.name, tests[test.sameAs].name, last)
+		}
+		if test.sameAs < 0 && last != http.StatusOK {
+			t.Errorf("%s: expected a bucket of its own, got %d", test.name, last)
+		}
+	}
+}
+
+func TestClientRateLimitRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rl := NewRateLimiter([]RateLimit{{Method: "GET", Path: "/limited", Rate: 0.001, Burst: 1}}, nil)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("log", logrus.NewEntry(logrus.New()))
+		c.Set("client_id", c.GetHeader("Client-Id")) // Set by AuthenticationRequired
+	})
+	r.Use(RateLimitRequired("log", rl))
+	r.Use(ClientRateLimitRequired("log", rl))
+	r.GET("/limited", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		clientId   string
+		expected   int
+	}{
+		{"first", "203.0.113.1:1234", "client", http.StatusOK},
+		{"same client other ip", "203.0.113.2:1234", "client", http.StatusTooManyRequests},
+		{"other client same ip", "203.0.113.1:1234", "other", http.StatusTooManyRequests},
+		{"other client other ip", "203.0.113.3:1234", "other", http.StatusOK},
+		{"unauthenticated other ip", "203.0.113.4:1234", "", http.StatusOK},
+		{"unauthenticated same ip", "203.0.113.4:1234", "", http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("Client-Id", test.clientId)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Scopes required by the routes mounted with AuthorizationRequired. Used to publish the scopes of this resource server.
+var mountedScopes = make(map[string]bool)
+var mountedScopesLock sync.Mutex
+
+func registerMountedScopes(scopes ...string) {
+	mountedScopesLock.Lock()
+	defer mountedScopesLock.Unlock()
+
+	for _, scope := range scopes {
+		mountedScopes[scope] = true
+	}
+}
+
+// MountedScopes returns the sorted list of scopes required by the mounted routes.
+func MountedScopes() (scopes []string) {
+	mountedScopesLock.Lock()
+	defer mountedScopesLock.Unlock()
+
+	for scope := range mountedScopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// DescribeScope makes a default description and consent text from a scope following the <publisher>:<verb>:<resource>[:<action>] naming.
+// Eg. idp:update:humans:password gives "Update humans password" and "Allow the application to update humans password on your behalf".
+func DescribeScope(scope string) (description string, consentText string) {
+	parts := strings.Split(scope, ":")
+	if len(parts) < 3 {
+		return scope, "Allow the application to use " + scope + " on your behalf"
+	}
+
+	what := strings.ToLower(parts[1]) + " " + strings.Join(parts[2:], " ")
+	return strings.ToUpper(what[:1]) + what[1:], "Allow the application to " + what + " on your behalf"
+}
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"context"
+
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/opensentry/idp/tracing"
+
+	hydra "github.com/charmixer/hydra/client"
+	aap "github.com/opensentry/aap/client"
+)
+
+// NewHydraClient is hydra.NewHydraClient with calls traced as part of ctx, use it with the context of the request being handled.
+func NewHydraClient(ctx context.Context, config *clientcredentials.Config) *hydra.HydraClient {
+	return &hydra.HydraClient{Client: tracing.HttpClient(ctx, config)}
+}
+
+// NewAapClient is aap.NewAapClient with calls traced as part of ctx, use it with the context of the request being handled.
+func NewAapClient(ctx context.Context, config *clientcredentials.Config) *aap.AapClient {
+	return &aap.AapClient{Client: tracing.HttpClient(ctx, config)}
+}
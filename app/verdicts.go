@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/opensentry/idp/config"
+
+	bulky "github.com/charmixer/bulky/client"
+	aap "github.com/opensentry/aap/client"
+)
+
+const DEFAULT_VERDICT_CACHE_TTL = 60 * time.Second
+const DEFAULT_VERDICT_CACHE_STALE_GRACE = 5 * time.Minute
+const MAX_VERDICT_CACHE_ENTRIES = 50000
+
+type verdictCacheEntry struct {
+	verdict    aap.Verdict
+	expiresAt  time.Time // Fresh until
+	staleUntil time.Time // Usable while AAP is unavailable until
+}
+
+type VerdictCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	StaleHits uint64 // Expired verdicts used because AAP was unavailable
+}
+
+// HitRatio is the share of lookups answered from the cache, stale answers included.
+func (s VerdictCacheStats) HitRatio() float64 {
+	total := s.Hits + s.StaleHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.StaleHits) / float64(total)
+}
+
+// VerdictCache holds AAP judge verdicts keyed by token hash, publisher, scope and owners.
+// Verdicts never outlive the token they were given for, and are all dropped when AAP announces a change of grants over NATS.
+type VerdictCache struct {
+	ttl        time.Duration
+	staleGrace time.Duration
+
+	lock    sync.Mutex
+	entries map[string]verdictCacheEntry
+
+	hits      uint64
+	misses    uint64
+	staleHits uint64
+}
+
+func NewVerdictCache(ttl time.Duration, staleGrace time.Duration) *VerdictCache {
+	if ttl <= 0 {
+		ttl = DEFAULT_VERDICT_CACHE_TTL
+	}
+	if staleGrace < 0 {
+		staleGrace = DEFAULT_VERDICT_CACHE_STALE_GRACE
+	}
+	return &VerdictCache{
+		ttl:        ttl,
+		staleGrace: staleGrace,
+		entries:    make(map[string]verdictCacheEntry),
+	}
+}
+
+func VerdictCacheKey(token string, publisher string, scope string, owners []string) string {
+	return hashToken(token) + "|" + publisher + "|" + scope + "|" + strings.Join(owners, ",")
+}
+
+// Get returns the fresh verdict for key. If allowStale is set an expired verdict within the stale grace is returned as well.
+func (vc *VerdictCache) Get(key string, allowStale bool) (verdict aap.Verdict, found bool) {
+	now := time.Now()
+
+	vc.lock.Lock()
+	entry, found := vc.entries[key]
+	vc.lock.Unlock()
+
+	if found && now.Before(entry.expiresAt) {
+		atomic.AddUint64(&vc.hits, 1)
+		return entry.verdict, true
+	}
+
+	if found && allowStale && now.Before(entry.staleUntil) {
+		atomic.AddUint64(&vc.staleHits, 1)
+		return entry.verdict, true
+	}
+
+	if !allowStale {
+		atomic.AddUint64(&vc.misses, 1)
+	}
+	return aap.Verdict{}, false
+}
+
+// Set caches the verdict. tokenExpiresAt is the unix time the access token expires, 0 if unknown.
+func (vc *VerdictCache) Set(key string, verdict aap.Verdict, tokenExpiresAt int64) {
+	now := time.Now()
+
+	expiresAt := now.Add(vc.ttl)
+	staleUntil := expiresAt.Add(vc.staleGrace)
+	if tokenExpiresAt > 0 {
+		tokenExp := time.Unix(tokenExpiresAt, 0)
+		if tokenExp.Before(expiresAt) {
+			expiresAt = tokenExp
+		}
+		if tokenExp.Before(staleUntil) {
+			staleUntil = tokenExp
+		}
+	}
+
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	if len(vc.entries) >= MAX_VERDICT_CACHE_ENTRIES {
+		for k, e := range vc.entries {
+			if now.After(e.staleUntil) {
+				delete(vc.entries, k)
+			}
+		}
+
+		// Still full of live entries, start over rather than grow without bounds.
+		if len(vc.entries) >= MAX_VERDICT_CACHE_ENTRIES {
+			vc.entries = make(map[string]verdictCacheEntry)
+		}
+	}
+
+	vc.entries[key] = verdictCacheEntry{verdict: verdict, expiresAt: expiresAt, staleUntil: staleUntil}
+}
+
+func (vc *VerdictCache) Flush() {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+	vc.entries = make(map[string]verdictCacheEntry)
+}
+
+func (vc *VerdictCache) Stats() VerdictCacheStats {
+	return VerdictCacheStats{
+		Hits:      atomic.LoadUint64(&vc.hits),
+		Misses:    atomic.LoadUint64(&vc.misses),
+		StaleHits: atomic.LoadUint64(&vc.staleHits),
+	}
+}
+
+// SubscribeInvalidations flushes the cache on every message AAP publishes on the subjects, as any change of grants, consents or shadows may change a verdict.
+func (vc *VerdictCache) SubscribeInvalidations(natsConnection *nats.Conn, subjects []string) error {
+	for _, subject := range subjects {
+		_, err := natsConnection.Subscribe(subject, func(msg *nats.Msg) {
+			vc.Flush()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JudgeScopes asks AAP whether the access token is granted all of the scopes, answering from the cache where possible.
+// If AAP can not be reached, verdicts within the stale grace are used so short AAP outages do not take the idp down.
+// The returned verdict is the one of the last scope judged, it holds the introspected identity and scope.
+func JudgeScopes(ctx context.Context, aapConfig *clientcredentials.Config, cache *VerdictCache, token string, tokenExpiresAt int64, publisher string, owners []string, scopes ...string) (granted bool, verdict aap.Verdict, err error) {
+	if len(scopes) <= 0 {
+		return false, aap.Verdict{}, errors.New("No scopes to judge")
+	}
+
+	verdicts := make(map[string]aap.Verdict)
+	var judgeRequests []aap.ReadEntitiesJudgeRequest
+	for _, scope := range scopes {
+		if cache != nil {
+			cached, found := cache.Get(VerdictCacheKey(token, publisher, scope, owners), false)
+			if found {
+				verdicts[scope] = cached
+				continue
+			}
+		}
+
+		judgeRequests = append(judgeRequests, aap.ReadEntitiesJudgeRequest{
+			AccessToken: token,
+			Publisher:   publisher,
+			Scope:       scope,
+			Owners:      owners,
+		})
+	}
+
+	if len(judgeRequests) > 0 {
+		judged, err := judge(ctx, aapConfig, judgeRequests)
+		if err != nil {
+			// Only an unreachable AAP is bridged with stale verdicts, a refusal is not.
+			if cache == nil || err == errAapForbidden {
+				return false, aap.Verdict{}, err
+			}
+
+			for _, r := range judgeRequests {
+				stale, found := cache.Get(VerdictCacheKey(token, publisher, r.Scope, owners), true)
+				if !found {
+					return false, aap.Verdict{}, err
+				}
+				verdicts[r.Scope] = stale
+			}
+		} else {
+			for i, r := range judgeRequests {
+				verdicts[r.Scope] = judged[i]
+				if cache != nil {
+					cache.Set(VerdictCacheKey(token, publisher, r.Scope, owners), judged[i], tokenExpiresAt)
+				}
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		verdict = verdicts[scope]
+		if verdict.Granted == false {
+			return false, verdict, nil
+		}
+	}
+	return true, verdict, nil
+}
+
+var errAapForbidden = errors.New("AAP judge forbidden")
+
+func judge(ctx context.Context, aapConfig *clientcredentials.Config, judgeRequests []aap.ReadEntitiesJudgeRequest) (verdicts []aap.Verdict, err error) {
+	aapClient := NewAapClient(ctx, aapConfig)
+	url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.judge")
+	status, responses, err := aap.ReadEntitiesJudge(aapClient, url, judgeRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusForbidden {
+		return nil, errAapForbidden
+	}
+
+	if status != http.StatusOK {
+		return nil, errors.New("Call aap.ReadEntitiesJudge failed with status " + http.StatusText(status))
+	}
+
+	for i := range judgeRequests {
+		var verdict aap.ReadEntitiesJudgeResponse
+		status, restErr := bulky.Unmarshal(i, responses, &verdict)
+		if restErr != nil {
+			return nil, errors.New("Unmarshal ReadEntitiesJudgeResponse failed")
+		}
+
+		// A failed judgement is a denial, not something to cache as an error.
+		if status != http.StatusOK {
+			verdicts = append(verdicts, aap.Verdict{Granted: false})
+			continue
+		}
+		verdicts = append(verdicts, aap.Verdict(verdict))
+	}
+
+	return verdicts, nil
+}
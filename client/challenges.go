@@ -45,6 +45,8 @@ type Challenge struct {
 	VerifiedAt int64 `json:"verified_at"`
 
 	Data string `json:"data,omitempty"`
+
+	EmailDelivery *EmailDelivery `json:"email_delivery,omitempty"`
 }
 
 type ChallengeVerification struct {
@@ -14,19 +14,46 @@ type Client struct {
 	RedirectUris            []string `json:"redirect_uris"              validate:"omitempty,dive,url"`
 	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method" validate:"omitempty,eq=none|eq=client_secret_post|eq=client_secret_basic|eq=private_key_jwt"`
 	PostLogoutRedirectUris  []string `json:"post_logout_redirect_uris"  validate:"omitempty,dive,url"`
+	Audiences               []string `json:"audiences"                  validate:"omitempty"`
+
+	Jwks    *JsonWebKeySet `json:"jwks,omitempty"     validate:"omitempty"`
+	JwksUri string         `json:"jwks_uri,omitempty" validate:"omitempty,url"`
+
+	SecondarySecretExpiresAt int64 `json:"secondary_secret_exp,omitempty"`
+}
+
+// JsonWebKeySet holds the public keys used by private_key_jwt clients to sign their client assertions.
+type JsonWebKeySet struct {
+	Keys []JsonWebKey `json:"keys" validate:"required,min=1,dive"`
+}
+
+// JsonWebKey is the public part of a JSON Web Key (RFC 7517). Private key parameters are never accepted.
+type JsonWebKey struct {
+	Kty string   `json:"kty"           validate:"required,eq=RSA|eq=EC|eq=OKP"`
+	Use string   `json:"use,omitempty" validate:"omitempty,eq=sig"`
+	Kid string   `json:"kid,omitempty"`
+	Alg string   `json:"alg,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
 }
 
 type CreateClientsResponse Client
 type CreateClientsRequest struct {
-	Name                    string   `json:"name"                       validate:"required"`
-	Description             string   `json:"description"                validate:"required"`
-	IsPublic                bool     `json:"is_public"                  `
-	Secret                  string   `json:"secret,omitempty"           validate:"omitempty,max=55"`
-	GrantTypes              []string `json:"grant_types"                validate:"omitempty,dive,eq=authorization_code|eq=implicit|eq=password|eq=client_credentials|eq=device_code|eq=refresh_token"`
-	ResponseTypes           []string `json:"response_types"             validate:"omitempty,dive,eq=code|eq=token"`
-	RedirectUris            []string `json:"redirect_uris"              validate:"omitempty,dive,url"`
-	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method" validate:"omitempty,eq=none|eq=client_secret_post|eq=client_secret_basic|eq=private_key_jwt"`
-	PostLogoutRedirectUris  []string `json:"post_logout_redirect_uris"  validate:"omitempty,dive,url"`
+	Name                    string         `json:"name"                       validate:"required"`
+	Description             string         `json:"description"                validate:"required"`
+	IsPublic                bool           `json:"is_public"                  `
+	Secret                  string         `json:"secret,omitempty"           validate:"omitempty,max=55"`
+	GrantTypes              []string       `json:"grant_types"                validate:"omitempty,dive,eq=authorization_code|eq=implicit|eq=password|eq=client_credentials|eq=device_code|eq=refresh_token"`
+	ResponseTypes           []string       `json:"response_types"             validate:"omitempty,dive,eq=code|eq=token"`
+	RedirectUris            []string       `json:"redirect_uris"              validate:"omitempty,dive,url"`
+	TokenEndpointAuthMethod string         `json:"token_endpoint_auth_method" validate:"omitempty,eq=none|eq=client_secret_post|eq=client_secret_basic|eq=private_key_jwt"`
+	PostLogoutRedirectUris  []string       `json:"post_logout_redirect_uris"  validate:"omitempty,dive,url"`
+	Jwks                    *JsonWebKeySet `json:"jwks,omitempty"     validate:"omitempty"`
+	JwksUri                 string         `json:"jwks_uri,omitempty" validate:"omitempty,url"`
 }
 
 type ReadClientsResponse []Client
@@ -34,6 +61,28 @@ type ReadClientsRequest struct {
 	Id string `json:"id,omitempty" validate:"uuid"`
 }
 
+type UpdateClientsResponse Client
+type UpdateClientsRequest struct {
+	Id                      string   `json:"id"                                   validate:"required,uuid"`
+	Name                    string   `json:"name,omitempty"                       validate:"omitempty"`
+	Description             string   `json:"description,omitempty"                validate:"omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"                validate:"omitempty,dive,eq=authorization_code|eq=implicit|eq=password|eq=client_credentials|eq=device_code|eq=refresh_token"`
+	ResponseTypes           []string `json:"response_types,omitempty"             validate:"omitempty,dive,eq=code|eq=token"`
+	RedirectUris            []string `json:"redirect_uris,omitempty"              validate:"omitempty,dive,url"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty" validate:"omitempty,eq=none|eq=client_secret_post|eq=client_secret_basic|eq=private_key_jwt"`
+	PostLogoutRedirectUris  []string `json:"post_logout_redirect_uris,omitempty"  validate:"omitempty,dive,url"`
+	Audiences               []string `json:"audiences,omitempty"                  validate:"omitempty"`
+
+	Jwks    *JsonWebKeySet `json:"jwks,omitempty"     validate:"omitempty"`
+	JwksUri string         `json:"jwks_uri,omitempty" validate:"omitempty,url"`
+}
+
+type UpdateClientsSecretResponse Client
+type UpdateClientsSecretRequest struct {
+	Id          string `json:"id"                     validate:"required,uuid"`
+	GracePeriod *int64 `json:"grace_period,omitempty" validate:"omitempty,min=0"` // Seconds, nil uses config client.secret.rotation.grace_period
+}
+
 type DeleteClientsResponse Identity
 type DeleteClientsRequest struct {
 	Id string `json:"id" validate:"required,uuid"`
@@ -59,6 +108,26 @@ func ReadClients(client *IdpClient, url string, requests []ReadClientsRequest) (
 	return status, responses, nil
 }
 
+func UpdateClients(client *IdpClient, url string, requests []UpdateClientsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func UpdateClientsSecret(client *IdpClient, url string, requests []UpdateClientsSecretRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
 func DeleteClients(client *IdpClient, url string, requests []DeleteClientsRequest) (status int, responses bulky.Responses, err error) {
 	status, err = handleRequest(client, requests, "DELETE", url, &responses)
 
@@ -0,0 +1,47 @@
+package client
+
+const (
+	EMAIL_STATUS_PENDING = "pending"
+	EMAIL_STATUS_SENT    = "sent"
+	EMAIL_STATUS_DEAD    = "dead"
+)
+
+// EmailDelivery is the state of the latest email sent for a challenge or an invite. Emails are delivered from an outbox after the request commits,
+// pending ones are retried with backoff until sent, and dead lettered after too many attempts or when the challenge or invite expires.
+type EmailDelivery struct {
+	Id            string `json:"id"                        validate:"required,uuid"`
+	Status        string `json:"status"                    validate:"required,oneof=pending sent dead"`
+	Attempts      int64  `json:"attempts"                  validate:"numeric"`
+	NextAttemptAt int64  `json:"next_attempt_at,omitempty" validate:"omitempty,numeric"`
+	SentAt        int64  `json:"sent_at,omitempty"         validate:"omitempty,numeric"`
+}
+
+// EmailPreview is an email template rendered without sending it, to check changes to templates.
+type EmailPreview struct {
+	Template string `json:"template" validate:"required"`
+	Locale   string `json:"locale"   validate:"required"`
+	Subject  string `json:"subject"  validate:"required"`
+	Text     string `json:"text"`
+	Html     string `json:"html,omitempty"`
+}
+
+type EmailSent struct {
+	Template string `json:"template" validate:"required"`
+	Locale   string `json:"locale"   validate:"required"`
+	To       string `json:"to"       validate:"required,email"`
+}
+
+type CreateEmailsPreviewResponse EmailPreview
+type CreateEmailsPreviewRequest struct {
+	Template string                 `json:"template"         validate:"required,oneof=authenticate recover delete emailconfirm emailchange invite"`
+	Locale   string                 `json:"locale,omitempty" validate:"omitempty,max=35"`
+	Data     map[string]interface{} `json:"data,omitempty"` // Overrides the sample data key by key
+}
+
+type CreateEmailsSendResponse EmailSent
+type CreateEmailsSendRequest struct {
+	Template string                 `json:"template"         validate:"required,oneof=authenticate recover delete emailconfirm emailchange invite"`
+	Locale   string                 `json:"locale,omitempty" validate:"omitempty,max=35"`
+	Data     map[string]interface{} `json:"data,omitempty"` // Overrides the sample data key by key
+	To       string                 `json:"to"               validate:"required,email"`
+}
@@ -21,11 +21,17 @@ const HUMAN_ALREADY_EXISTS = 24
 
 const HUMAN_TOKEN_INVALID = 25
 
-//const CLIENT_NOT_FOUND = 50
+const CLIENT_NOT_FOUND = 50
 const CLIENT_NOT_CREATED = 51
+const CLIENT_NOT_UPDATED = 52
+const CLIENT_IS_PUBLIC = 53
+const CLIENT_JWKS_INVALID = 54
+const CLIENT_JWKS_REQUIRED = 55
 
 const RESOURCESERVER_NOT_FOUND = 60
 
+const ROLE_NOT_FOUND = 70
+
 const CHALLENGE_NOT_FOUND = 30
 const CHALLENGE_NOT_CREATED = 31
 const CHALLENGE_CONFIRMATION_TYPE_INVALID = 32
@@ -37,9 +43,20 @@ const INVITE_NOT_FOUND = 90
 const INVITE_NOT_CREATED = 91
 const INVITE_EXPIRES_IN_THE_PAST = 92
 
+const SCOPE_NOT_FOUND = 100
+const SCOPE_EXISTS = 101
+
 const FOLLOW_NOT_FOUND = 110
 const FOLLOW_NOT_CREATED = 111
 
+const EMAIL_TEMPLATE_NOT_FOUND = 120
+const EMAIL_TEMPLATE_DATA_INVALID = 121
+const EMAIL_NOT_SENT = 122
+
+const WEBHOOK_NOT_FOUND = 130
+const WEBHOOK_DELIVERY_NOT_FOUND = 131
+const WEBHOOK_URL_NOT_ALLOWED = 132
+
 func InitRestErrors() {
 	bulky.AppendErrors(
 		map[int]map[string]string{
@@ -95,21 +112,50 @@ func InitRestErrors() {
 				"dev": "TOTP not required",
 			},
 
-			/*CLIENT_NOT_FOUND:
-			  {
-			    "en":  "Not found",
-			    "dev": "Client not found",
-			  },*/
+			CLIENT_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Client not found",
+			},
 			CLIENT_NOT_CREATED: {
 				"en":  "Not created",
 				"dev": "Failed to create client. This requires investigation as it should never happen with validation in place.",
 			},
+			CLIENT_NOT_UPDATED: {
+				"en":  "Not updated",
+				"dev": "Failed to update client. Hint: Hydra rejected the update or is unavailable.",
+			},
+			CLIENT_IS_PUBLIC: {
+				"en":  "Client is public",
+				"dev": "Client is public and has no secret to rotate",
+			},
+			CLIENT_JWKS_INVALID: {
+				"en":  "Invalid JSON Web Key Set",
+				"dev": "JSON Web Key Set is invalid. Hint: Keys must be valid public signing keys and jwks_uri must use https.",
+			},
+			CLIENT_JWKS_REQUIRED: {
+				"en":  "JSON Web Key Set required",
+				"dev": "Token endpoint auth method private_key_jwt requires exactly one of jwks or jwks_uri",
+			},
 
 			RESOURCESERVER_NOT_FOUND: {
 				"en":  "Not found",
 				"dev": "Resource Server not found",
 			},
 
+			SCOPE_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Scope not found",
+			},
+			SCOPE_EXISTS: {
+				"en":  "Already exists",
+				"dev": "Scope already exists on the resource server",
+			},
+
+			ROLE_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Role not found",
+			},
+
 			INVITE_NOT_FOUND: {
 				"en":  "Not found",
 				"dev": "Invite not found",
@@ -131,6 +177,32 @@ func InitRestErrors() {
 				"en":  "Not created",
 				"dev": "Failed to create follow. This requires investigation as it should never happen with validation in place.",
 			},
+
+			EMAIL_TEMPLATE_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Email template not found. Hint: Is it configured in templates?",
+			},
+			EMAIL_TEMPLATE_DATA_INVALID: {
+				"en":  "Invalid data",
+				"dev": "Failed to render the email template. Hint: The data must have every key the template uses.",
+			},
+			EMAIL_NOT_SENT: {
+				"en":  "Not sent",
+				"dev": "Failed to send email. Hint: See the log for the error of the mail transport.",
+			},
+
+			WEBHOOK_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Webhook not found",
+			},
+			WEBHOOK_DELIVERY_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Webhook delivery not found. Hint: Deliveries are removed with their event after config events.outbox.retention.",
+			},
+			WEBHOOK_URL_NOT_ALLOWED: {
+				"en":  "Webhook url not allowed",
+				"dev": "Webhook url must use https. Hint: http is allowed when config webhooks.require_https is false.",
+			},
 		},
 	)
 }
@@ -0,0 +1,18 @@
+package client
+
+const (
+	HEALTH_STATUS_OK          = "ok"
+	HEALTH_STATUS_UNAVAILABLE = "unavailable"
+)
+
+// Health responses are plain json, not bulky, so orchestrators can probe without knowing the request format.
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// NOTE: The reason a dependency is unavailable is only logged, as the health endpoints are not authenticated.
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Latency int64  `json:"latency_ms"`
+}
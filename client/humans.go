@@ -11,6 +11,7 @@ type Human struct {
 	Name             string `json:"name"                    validate:"required`
 	Email            string `json:"email"                   validate:"required,email"`
 	EmailConfirmedAt int64  `json:"email_confirmed_at"`
+	Locale           string `json:"locale,omitempty"        validate:"omitempty,max=35"`
 	AllowLogin       bool   `json:"allow_login"             validate:"required"`
 	TotpRequired     bool   `json:"totp_required"           `
 	TotpSecret       string `json:"totp_secret"             `
@@ -56,6 +57,7 @@ type CreateHumansRequest struct {
 	Username         string `json:"username,omitempty" validate:"omitempty"`
 	Email            string `json:"email,omitempty"    validate:"omitempty,email"`
 	Name             string `json:"name,omitempty"     validate:"omitempty"`
+	Locale           string `json:"locale,omitempty"   validate:"omitempty,max=35"`
 	AllowLogin       bool   `json:"allow_login"`
 	EmailConfirmedAt int64  `json:"email_confirmed_at"`
 }
@@ -69,8 +71,9 @@ type ReadHumansRequest struct {
 
 type UpdateHumansResponse Human
 type UpdateHumansRequest struct {
-	Id   string `json:"id" validate:"required,uuid"`
-	Name string `json:"name,omitempty"`
+	Id     string `json:"id"               validate:"required,uuid"`
+	Name   string `json:"name,omitempty"`
+	Locale string `json:"locale,omitempty" validate:"omitempty,max=35"`
 }
 
 type DeleteHumansResponse HumanRedirect
@@ -11,8 +11,11 @@ type Invite struct {
 
 	Email    string `json:"email"              validate:"required,email"`
 	Username string `json:"username,omitempty" validate:"omitempty"`
+	Locale   string `json:"locale,omitempty"   validate:"omitempty,max=35"`
 
 	SentAt int64 `json:"sent_at,omitempty" validate:"omitempty,numeric"`
+
+	EmailDelivery *EmailDelivery `json:"email_delivery,omitempty"`
 }
 
 type InviteClaimChallenge struct {
@@ -23,6 +26,7 @@ type CreateInvitesResponse Invite
 type CreateInvitesRequest struct {
 	Email     string `json:"email"              validate:"required,email"`
 	Username  string `json:"username,omitempty" validate:"omitempty"`
+	Locale    string `json:"locale,omitempty"   validate:"omitempty,max=35"`
 	ExpiresAt int64  `json:"exp,omitempty"      validate:"omitempty,numeric"`
 }
 
@@ -0,0 +1,44 @@
+package client
+
+// Client metadata as defined by RFC 7591 section 2. Used by the dynamic client registration endpoints which follow the RFC instead of the bulky request format.
+type ClientRegistrationRequest struct {
+	ClientId                string         `json:"client_id,omitempty"` // Only used on update, RFC 7592 section 2.2
+	ClientName              string         `json:"client_name,omitempty"`
+	RedirectUris            []string       `json:"redirect_uris,omitempty"`
+	PostLogoutRedirectUris  []string       `json:"post_logout_redirect_uris,omitempty"`
+	GrantTypes              []string       `json:"grant_types,omitempty"`
+	ResponseTypes           []string       `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string         `json:"token_endpoint_auth_method,omitempty"`
+	Jwks                    *JsonWebKeySet `json:"jwks,omitempty"`
+	JwksUri                 string         `json:"jwks_uri,omitempty"`
+}
+
+// Client information response as defined by RFC 7591 section 3.2.1 and RFC 7592 section 3.
+type ClientRegistrationResponse struct {
+	ClientId                string         `json:"client_id"`
+	ClientSecret            string         `json:"client_secret,omitempty"`
+	ClientIdIssuedAt        int64          `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64          `json:"client_secret_expires_at"`
+	RegistrationAccessToken string         `json:"registration_access_token,omitempty"`
+	RegistrationClientUri   string         `json:"registration_client_uri"`
+	ClientName              string         `json:"client_name"`
+	RedirectUris            []string       `json:"redirect_uris,omitempty"`
+	PostLogoutRedirectUris  []string       `json:"post_logout_redirect_uris,omitempty"`
+	GrantTypes              []string       `json:"grant_types"`
+	ResponseTypes           []string       `json:"response_types"`
+	TokenEndpointAuthMethod string         `json:"token_endpoint_auth_method"`
+	Jwks                    *JsonWebKeySet `json:"jwks,omitempty"`
+	JwksUri                 string         `json:"jwks_uri,omitempty"`
+}
+
+// Error response as defined by RFC 7591 section 3.2.2.
+type ClientRegistrationError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+const (
+	REGISTRATION_ERROR_INVALID_REDIRECT_URI    = "invalid_redirect_uri"
+	REGISTRATION_ERROR_INVALID_CLIENT_METADATA = "invalid_client_metadata"
+	REGISTRATION_ERROR_INVALID_TOKEN           = "invalid_token"
+)
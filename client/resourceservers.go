@@ -23,6 +23,14 @@ type ReadResourceServersRequest struct {
 	Id string `json:"id,omitempty" validate:"uuid"`
 }
 
+type UpdateResourceServersResponse ResourceServer
+type UpdateResourceServersRequest struct {
+	Id          string `json:"id"                    validate:"required,uuid"`
+	Name        string `json:"name,omitempty"        validate:"omitempty"`
+	Description string `json:"description,omitempty" validate:"omitempty"`
+	Audience    string `json:"aud,omitempty"         validate:"omitempty"`
+}
+
 type DeleteResourceServersResponse Identity
 type DeleteResourceServersRequest struct {
 	Id string `json:"id" validate:"required,uuid"`
@@ -48,6 +56,16 @@ func ReadResourceServers(client *IdpClient, url string, requests []ReadResourceS
 	return status, responses, nil
 }
 
+func UpdateResourceServers(client *IdpClient, url string, requests []UpdateResourceServersRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
 func DeleteResourceServers(client *IdpClient, url string, requests []DeleteResourceServersRequest) (status int, responses bulky.Responses, err error) {
 	status, err = handleRequest(client, requests, "DELETE", url, &responses)
 
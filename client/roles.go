@@ -21,6 +21,13 @@ type ReadRolesRequest struct {
 	Id string `json:"id,omitempty" validate:"uuid"`
 }
 
+type UpdateRolesResponse Role
+type UpdateRolesRequest struct {
+	Id          string `json:"id"                    validate:"required,uuid"`
+	Name        string `json:"name,omitempty"        validate:"omitempty"`
+	Description string `json:"description,omitempty" validate:"omitempty"`
+}
+
 type DeleteRolesResponse Identity
 type DeleteRolesRequest struct {
 	Id string `json:"id" validate:"required,uuid"`
@@ -46,6 +53,16 @@ func ReadRoles(client *IdpClient, url string, requests []ReadRolesRequest) (stat
 	return status, responses, nil
 }
 
+func UpdateRoles(client *IdpClient, url string, requests []UpdateRolesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
 func DeleteRoles(client *IdpClient, url string, requests []DeleteRolesRequest) (status int, responses bulky.Responses, err error) {
 	status, err = handleRequest(client, requests, "DELETE", url, &responses)
 
@@ -0,0 +1,81 @@
+package client
+
+import (
+	bulky "github.com/charmixer/bulky/client"
+)
+
+type Scope struct {
+	Id               string `json:"id"                validate:"required,uuid"`
+	ResourceServerId string `json:"resourceserver_id" validate:"required,uuid"`
+	Name             string `json:"name"              validate:"required"`
+	Description      string `json:"description"       validate:"required"`
+	ConsentText      string `json:"consent_text"`
+}
+
+type CreateScopesResponse Scope
+type CreateScopesRequest struct {
+	ResourceServerId string `json:"resourceserver_id" validate:"required,uuid"`
+	Name             string `json:"name"              validate:"required"`
+	Description      string `json:"description"       validate:"required"`
+	ConsentText      string `json:"consent_text"      validate:"omitempty"`
+}
+
+type ReadScopesResponse []Scope
+type ReadScopesRequest struct {
+	Id               string `json:"id,omitempty"                validate:"omitempty,uuid"`
+	ResourceServerId string `json:"resourceserver_id,omitempty" validate:"omitempty,uuid"`
+}
+
+type UpdateScopesResponse Scope
+type UpdateScopesRequest struct {
+	Id          string `json:"id"                     validate:"required,uuid"`
+	Description string `json:"description,omitempty"  validate:"omitempty"`
+	ConsentText string `json:"consent_text,omitempty" validate:"omitempty"`
+}
+
+type DeleteScopesResponse struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+type DeleteScopesRequest struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+
+func CreateScopes(client *IdpClient, url string, requests []CreateScopesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "POST", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadScopes(client *IdpClient, url string, requests []ReadScopesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func UpdateScopes(client *IdpClient, url string, requests []UpdateScopesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func DeleteScopes(client *IdpClient, url string, requests []DeleteScopesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "DELETE", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bulky "github.com/charmixer/bulky/client"
+)
+
+type Webhook struct {
+	Id          string   `json:"id"                    validate:"required,uuid"`
+	Url         string   `json:"url"                   validate:"required,url"`
+	Events      []string `json:"events"                validate:"omitempty,dive,required"`
+	Description string   `json:"description,omitempty" validate:"omitempty"`
+}
+
+type WebhookDelivery struct {
+	Id            string                   `json:"id"              validate:"required,uuid"`
+	Webhook       string                   `json:"webhook"         validate:"required,uuid"`
+	Event         string                   `json:"event"           validate:"required,uuid"`
+	EventType     string                   `json:"event_type"      validate:"required"`
+	Status        string                   `json:"status"          validate:"required,oneof=pending delivered failed"`
+	Attempts      int64                    `json:"attempts"        validate:"min=0"`
+	NextAttemptAt int64                    `json:"next_attempt_at" validate:"min=0"`
+	DeliveredAt   int64                    `json:"delivered_at"    validate:"min=0"`
+	History       []WebhookDeliveryAttempt `json:"history"`
+}
+
+type WebhookDeliveryAttempt struct {
+	Attempt    int64  `json:"attempt"`
+	Time       int64  `json:"time"`
+	StatusCode int64  `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+	Duration   int64  `json:"duration"` // Milliseconds
+}
+
+type CreateWebhooksResponse struct {
+	Webhook
+	Secret string `json:"secret" validate:"required"` // Only ever returned here
+}
+type CreateWebhooksRequest struct {
+	Url         string   `json:"url"                   validate:"required,url"`
+	Events      []string `json:"events,omitempty"      validate:"omitempty,dive,required"`
+	Description string   `json:"description,omitempty" validate:"omitempty"`
+}
+
+type ReadWebhooksResponse []Webhook
+type ReadWebhooksRequest struct {
+	Id string `json:"id,omitempty" validate:"omitempty,uuid"`
+}
+
+type UpdateWebhooksResponse Webhook
+type UpdateWebhooksRequest struct {
+	Id          string   `json:"id"                    validate:"required,uuid"`
+	Url         string   `json:"url,omitempty"         validate:"omitempty,url"`
+	Events      []string `json:"events,omitempty"      validate:"omitempty,dive,required"`
+	Description string   `json:"description,omitempty" validate:"omitempty"`
+}
+
+type DeleteWebhooksResponse struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+type DeleteWebhooksRequest struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+
+type ReadWebhooksDeliveriesResponse []WebhookDelivery
+type ReadWebhooksDeliveriesRequest struct {
+	Webhook string `json:"webhook"          validate:"required,uuid"`
+	Id      string `json:"id,omitempty"     validate:"omitempty,uuid"`
+	Status  string `json:"status,omitempty" validate:"omitempty,oneof=pending delivered failed"`
+}
+
+type CreateWebhooksDeliveriesRedeliverResponse WebhookDelivery
+type CreateWebhooksDeliveriesRedeliverRequest struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+
+func CreateWebhooks(client *IdpClient, url string, requests []CreateWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "POST", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadWebhooks(client *IdpClient, url string, requests []ReadWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func UpdateWebhooks(client *IdpClient, url string, requests []UpdateWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func DeleteWebhooks(client *IdpClient, url string, requests []DeleteWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "DELETE", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadWebhooksDeliveries(client *IdpClient, url string, requests []ReadWebhooksDeliveriesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func CreateWebhooksDeliveriesRedeliver(client *IdpClient, url string, requests []CreateWebhooksDeliveriesRedeliverRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "POST", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+// VerifyWebhookSignature checks the X-Idp-Signature header of a webhook request against its body, for receivers of webhooks.
+// Requests signed more than tolerance before or after now are rejected, so a request someone got hold of can not be replayed later.
+func VerifyWebhookSignature(secret string, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Missing timestamp in signature")
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > tolerance || d < -tolerance {
+		return errors.New("Signature timestamp outside tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, s := range signatures {
+		b, err := hex.DecodeString(s)
+		if err == nil && hmac.Equal(b, expected) {
+			return nil
+		}
+	}
+	return errors.New("Signature mismatch")
+}
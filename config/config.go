@@ -8,6 +8,42 @@ import (
 func setDefaults() {
 	viper.SetDefault("config.app.path", "./app.yml")
 	viper.SetDefault("config.discovery.path", "./discovery.yml")
+	viper.SetDefault("templates.default_locale", "en")
+	viper.SetDefault("mail.transport", "smtp")
+	viper.SetDefault("mail.dkim.canonicalization", "relaxed/relaxed")
+	viper.SetDefault("mail.outbox.interval", 1)
+	viper.SetDefault("mail.outbox.batch_size", 20)
+	viper.SetDefault("mail.outbox.lease", 60)
+	viper.SetDefault("mail.outbox.retention", 86400)
+	viper.SetDefault("mail.outbox.retry.base_delay", 5)
+	viper.SetDefault("mail.outbox.retry.max_delay", 300)
+	viper.SetDefault("mail.outbox.retry.max_attempts", 8)
+	viper.SetDefault("events.outbox.interval", 1)
+	viper.SetDefault("events.outbox.batch_size", 100)
+	viper.SetDefault("events.outbox.lease", 30)
+	viper.SetDefault("events.outbox.retention", 604800)
+	viper.SetDefault("events.outbox.retry.base_delay", 1)
+	viper.SetDefault("events.outbox.retry.max_delay", 60)
+	viper.SetDefault("webhooks.interval", 1)
+	viper.SetDefault("webhooks.batch_size", 20)
+	viper.SetDefault("webhooks.lease", 60)
+	viper.SetDefault("webhooks.timeout", 10)
+	viper.SetDefault("webhooks.retry.base_delay", 10)
+	viper.SetDefault("webhooks.retry.max_delay", 3600)
+	viper.SetDefault("webhooks.retry.max_attempts", 10)
+	viper.SetDefault("webhooks.require_https", true)
+	viper.SetDefault("webhooks.allow_private_addresses", false)
+	viper.SetDefault("client.secret.rotation.grace_period", 86400)
+	viper.SetDefault("client.secret.rotation.cleanup_interval", 60)
+	viper.SetDefault("client.reconcile.delete_orphans", false)
+	viper.SetDefault("client.reconcile.min_age", 300)
+	viper.SetDefault("nats.jetstream.stream", "IDP")
+	viper.SetDefault("nats.jetstream.subjects", []string{"idp.>"})
+	viper.SetDefault("nats.jetstream.max_age", 604800)
+	viper.SetDefault("nats.jetstream.duplicate_window", 120)
+	viper.SetDefault("aap.judge.cache.ttl", 60)
+	viper.SetDefault("aap.judge.cache.stale_grace", 300)
+	viper.SetDefault("aap.judge.cache.invalidate.subjects", []string{"aap.>"})
 }
 
 func GetString(key string) string {
@@ -26,10 +62,18 @@ func GetStringStrict(key string) string {
 	return viper.GetString(key)
 }
 
+func GetBool(key string) bool {
+	return viper.GetBool(key)
+}
+
 func GetStringSlice(key string) []string {
 	return viper.GetStringSlice(key)
 }
 
+func UnmarshalKey(key string, rawVal interface{}) error {
+	return viper.UnmarshalKey(key, rawVal)
+}
+
 func InitConfigurations() error {
 	var err error
 
@@ -14,9 +14,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
-
-	bulkyClient "github.com/charmixer/bulky/client"
-	aap "github.com/opensentry/aap/client"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -45,7 +43,7 @@ func GetChallenges(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginReadTx(env.Driver)
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -92,6 +90,22 @@ func GetChallenges(env *app.Environment) gin.HandlerFunc {
 					return
 				}
 
+				var ids []string
+				for _, d := range dbChallenges {
+					ids = append(ids, d.Id)
+				}
+				deliveries, err := idp.FetchEmailDeliveries(tx, ids)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
 				if len(dbChallenges) > 0 {
 					for _, d := range dbChallenges {
 						ok = append(ok, client.Challenge{
@@ -105,6 +119,8 @@ func GetChallenges(env *app.Environment) gin.HandlerFunc {
 							CodeType:     d.CodeType,
 							VerifiedAt:   d.VerifiedAt,
 							Data:         d.Data,
+
+							EmailDelivery: marshalEmailDelivery(deliveries[d.Id]),
 						})
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
@@ -126,6 +142,7 @@ func GetChallenges(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -155,7 +172,7 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -163,6 +180,7 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -236,19 +254,21 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 
 				var otpCode idp.ChallengeCode
 				var challenge idp.Challenge
+				var queuedEmail idp.Email
 				if client.OTPType(newChallenge.CodeType) == client.TOTP {
 					challenge, err = idp.CreateChallengeUsingTotp(tx, ct, newChallenge)
 				} else {
 					challenge, otpCode, err = idp.CreateChallengeUsingOtp(tx, ct, newChallenge)
 				}
 				if err == nil && challenge.Id != "" {
+					idp.EmitEventChallengeCreated(c.Request.Context(), &events, challenge)
 
 					if otpCode.Code != "" && r.Email != "" {
 
 						// Sent challenge to requested email
 
-						emailTemplate := (*env.TemplateMap)[ct]
-						if emailTemplate == (app.EmailTemplate{}) {
+						emailTemplates := env.EmailTemplates[ct.EmailTemplateName()]
+						if emailTemplates == nil {
 							e := tx.Rollback()
 							if e != nil {
 								log.Debug(e.Error())
@@ -259,6 +279,24 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 							return
 						}
 
+						// Mail in the locale of the subject, if the subject is a human
+						humans, err := idp.FetchHumans(tx, []idp.Human{{Identity: idp.Identity{Id: r.Subject}}})
+						if err != nil {
+							e := tx.Rollback()
+							if e != nil {
+								log.Debug(e.Error())
+							}
+							bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+							request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+							log.Debug(err.Error())
+							return
+						}
+						var locale string
+						if len(humans) > 0 {
+							locale = humans[0].Locale
+						}
+						emailTemplate := emailTemplates.Locale(locale)
+
 						var data = ConfirmTemplateData{
 							Challenge: challenge.Id,
 							Sender:    emailTemplate.Sender.Name,
@@ -267,14 +305,7 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 							Code:      otpCode.Code, // Note this is the clear text generated code and not the hashed one stored in DB.
 						}
 
-						smtpConfig := idp.SMTPConfig{
-							Host:          config.GetString("mail.smtp.host"),
-							Username:      config.GetString("mail.smtp.user"),
-							Password:      config.GetString("mail.smtp.password"),
-							Sender:        emailTemplate.Sender,
-							SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
-						}
-						_, err = idp.SendEmailUsingTemplate(smtpConfig, r.Email, r.Email, emailTemplate.Subject, emailTemplate.File, data)
+						queuedEmail, err = idp.QueueEmailUsingTemplate(tx, challenge.Id, challenge.ExpiresAt, r.Email, r.Email, emailTemplate, data)
 						if err != nil {
 							e := tx.Rollback()
 							if e != nil {
@@ -301,6 +332,8 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 						RedirectTo:       challenge.RedirectTo,
 						CodeType:         challenge.CodeType,
 						Code:             challenge.Code,
+
+						EmailDelivery: marshalEmailDelivery(queuedEmail),
 					})
 					continue
 				}
@@ -318,6 +351,16 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -327,11 +370,26 @@ func PostChallenges(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
 
+// marshalEmailDelivery returns nil if no email was sent.
+func marshalEmailDelivery(m idp.Email) *client.EmailDelivery {
+	if m.Id == "" {
+		return nil
+	}
+	return &client.EmailDelivery{
+		Id:            m.Id,
+		Status:        m.Status,
+		Attempts:      m.Attempts,
+		NextAttemptAt: m.NextAttemptAt,
+		SentAt:        m.SentAt,
+	}
+}
+
 func judgeRequiredScope(env *app.Environment, c *gin.Context, log *logrus.Entry, token *oauth2.Token, requiredScopes ...string) (valid bool, err error) {
 
 	// Check that access token has required scopes
@@ -353,41 +411,19 @@ func judgeRequiredScope(env *app.Environment, c *gin.Context, log *logrus.Entry,
 
 	publisherId := config.GetString("id") // Resource Server (this)
 
-	var judgeRequests []aap.ReadEntitiesJudgeRequest
-	for _, scope := range requiredScopes {
-		judgeRequests = append(judgeRequests, aap.ReadEntitiesJudgeRequest{
-			AccessToken: token.AccessToken,
-			Publisher:   publisherId,
-			Scope:       scope,
-			Owners:      []string{sub},
-		})
+	var tokenExpiresAt int64
+	if exp, exists := c.Get("exp"); exists {
+		tokenExpiresAt = exp.(int64)
 	}
 
-	aapClient := aap.NewAapClient(env.AapConfig)
-	url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.judge")
-	status, responses, err := aap.ReadEntitiesJudge(aapClient, url, judgeRequests)
+	granted, _, err := app.JudgeScopes(c.Request.Context(), env.AapConfig, env.VerdictCache, token.AccessToken, tokenExpiresAt, publisherId, []string{sub}, requiredScopes...)
 	if err != nil {
 		return false, err
 	}
 
-	if status == http.StatusOK {
-
-		var verdict aap.ReadEntitiesJudgeResponse
-		status, restErr := bulkyClient.Unmarshal(0, responses, &verdict)
-		if restErr != nil {
-			log.Debug(restErr)
-			return false, errors.New("Unmarshal ReadEntitiesJudgeResponse failed")
-		}
-
-		if status == http.StatusOK {
-
-			if verdict.Granted == true {
-				// log.WithFields(logrus.Fields{"sub": sub, "scope": strRequiredScopes}).Debug("Authorized")
-				return true, nil // Authenticated
-			}
-
-		}
-
+	if granted == true {
+		// log.WithFields(logrus.Fields{"sub": sub, "scope": strRequiredScopes}).Debug("Authorized")
+		return true, nil // Authenticated
 	}
 
 	// Deny by default
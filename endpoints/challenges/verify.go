@@ -10,6 +10,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -38,7 +39,7 @@ func PutVerify(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -46,6 +47,7 @@ func PutVerify(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -172,6 +174,7 @@ func PutVerify(env *app.Environment) gin.HandlerFunc {
 						Verified:     true,
 						RedirectTo:   verifiedChallenge.RedirectTo,
 					})
+					idp.EmitEventChallengeVerified(c.Request.Context(), &events, verifiedChallenge)
 					continue
 				}
 
@@ -185,6 +188,16 @@ func PutVerify(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -192,6 +205,7 @@ func PutVerify(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
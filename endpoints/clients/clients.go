@@ -1,15 +1,18 @@
 package clients
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
 
 	"github.com/opensentry/idp/app"
 	"github.com/opensentry/idp/client"
-	_ "github.com/opensentry/idp/client/errors"
+	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 	sec "github.com/opensentry/idp/secret"
 
 	bulky "github.com/charmixer/bulky/server"
@@ -41,7 +44,7 @@ func GetClients(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginReadTx(env.Driver)
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -99,6 +102,11 @@ func GetClients(env *app.Environment) gin.HandlerFunc {
 							}
 						}
 
+						jwks, err := unmarshalClientJwks(d.Jwks)
+						if err != nil {
+							log.Debug(err.Error())
+						}
+
 						ok = append(ok, client.Client{
 							Id:                      d.Id,
 							Secret:                  descryptedClientSecret,
@@ -109,6 +117,9 @@ func GetClients(env *app.Environment) gin.HandlerFunc {
 							RedirectUris:            d.RedirectUris,
 							TokenEndpointAuthMethod: d.TokenEndpointAuthMethod,
 							PostLogoutRedirectUris:  d.PostLogoutRedirectUris,
+							Audiences:               d.Audiences,
+							Jwks:                    jwks,
+							JwksUri:                 d.JwksUri,
 						})
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
@@ -131,6 +142,7 @@ func GetClients(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -161,7 +173,7 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to begin transaction")
@@ -169,6 +181,7 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -189,6 +202,23 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 			for _, request := range iRequests {
 				r := request.Input.(client.CreateClientsRequest)
 
+				jwks, err := validateClientJwks(r.TokenEndpointAuthMethod, r.Jwks, r.JwksUri)
+				if err != nil {
+					log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Invalid jwks")
+
+					e := tx.Rollback()
+					if e != nil {
+						log.WithFields(logrus.Fields{"error": e.Error()}).Debug("Failed to rollback transaction")
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					if r.TokenEndpointAuthMethod == TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT && (r.Jwks == nil) == (r.JwksUri == "") {
+						request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.CLIENT_JWKS_REQUIRED)
+					} else {
+						request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.CLIENT_JWKS_INVALID)
+					}
+					return
+				}
+
 				newClient := idp.Client{
 					Identity: idp.Identity{
 						Issuer: config.GetString("idp.public.issuer"),
@@ -201,10 +231,13 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 					RedirectUris:            r.RedirectUris,
 					TokenEndpointAuthMethod: r.TokenEndpointAuthMethod,
 					PostLogoutRedirectUris:  r.PostLogoutRedirectUris,
+					Jwks:                    jwks,
+					JwksUri:                 r.JwksUri,
 				}
 
+				// Clients authenticating with private_key_jwt prove themselves with their keys, a secret would only be a liability.
 				var secret string
-				if r.IsPublic == false {
+				if r.IsPublic == false && r.TokenEndpointAuthMethod != TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT {
 
 					if r.Secret == "" {
 
@@ -272,9 +305,11 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 						RedirectUris:            objClient.RedirectUris,
 						TokenEndpointAuthMethod: objClient.TokenEndpointAuthMethod,
 						PostLogoutRedirectUris:  objClient.PostLogoutRedirectUris,
+						Jwks:                    r.Jwks,
+						JwksUri:                 objClient.JwksUri,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
-					idp.EmitEventClientCreated(env.Nats, objClient)
+					idp.EmitEventClientCreated(c.Request.Context(), &events, objClient)
 					continue
 				}
 
@@ -292,102 +327,267 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 
 				// proxy to hydra
-				var hydraClients []hydra.CreateClientRequest
-
-				var createEntitiesRequests []aap.CreateEntitiesRequest
-				for _, c := range newClients {
-					hydraClients = append(hydraClients, hydra.CreateClientRequest{
-						Id:                      c.Id,
-						Name:                    c.Name,
-						Secret:                  c.Secret,
-						Scope:                   "", // nothing yet, subscribe does this
-						GrantTypes:              c.GrantTypes,
-						Audience:                c.Audiences,
-						ResponseTypes:           c.ResponseTypes,
-						RedirectUris:            c.RedirectUris,
-						PostLogoutRedirectUris:  c.PostLogoutRedirectUris,
-						TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
-					})
-
-					createEntitiesRequests = append(createEntitiesRequests, aap.CreateEntitiesRequest{
-						Reference: c.Id,
-						Creator:   requestedBy.Id,
-						Scopes: []string{
-							"aap:read:grants",
-							"aap:create:grants",
-							"aap:delete:grants",
-							"aap:read:publishes",
-							"aap:create:publishes",
-							"aap:delete:publishes",
-							"aap:read:subscriptions",
-							"aap:create:subscriptions",
-							"aap:delete:subscriptions",
-							"aap:read:consents",
-							"aap:create:consents",
-							"aap:delete:consents",
-							"aap:read:shadows",
-							"aap:create:shadows",
-							"aap:delete:shadows",
-
-							"mg:aap:read:grants",
-							"mg:aap:create:grants",
-							"mg:aap:delete:grants",
-							"mg:aap:read:publishes",
-							"mg:aap:create:publishes",
-							"mg:aap:delete:publishes",
-							"mg:aap:read:subscriptions",
-							"mg:aap:create:subscriptions",
-							"mg:aap:delete:subscriptions",
-							"mg:aap:read:consents",
-							"mg:aap:create:consents",
-							"mg:aap:delete:consents",
-							"mg:aap:read:shadows",
-							"mg:aap:create:shadows",
-							"mg:aap:delete:shadows",
-
-							"0:mg:aap:read:grants",
-							"0:mg:aap:create:grants",
-							"0:mg:aap:delete:grants",
-							"0:mg:aap:read:publishes",
-							"0:mg:aap:create:publishes",
-							"0:mg:aap:delete:publishes",
-							"0:mg:aap:read:subscriptions",
-							"0:mg:aap:create:subscriptions",
-							"0:mg:aap:delete:subscriptions",
-							"0:mg:aap:read:consents",
-							"0:mg:aap:create:consents",
-							"0:mg:aap:delete:consents",
-							"0:mg:aap:read:shadows",
-							"0:mg:aap:create:shadows",
-							"0:mg:aap:delete:shadows",
-						},
-					})
-				}
-
 				url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
-				for _, h := range hydraClients {
-					c, err := hydra.CreateClient(url, h)
+				ctx := c.Request.Context()
+				for _, c := range newClients {
+					h, err := idp.CreateHydraClient(ctx, url, newHydraClient(c))
 					if err != nil {
 						log.Debug(err.Error())
 					} else {
-						log.Debug(c)
+						log.Debug(h)
 					}
 				}
 
-				// Initialize in AAP model
-				aapClient := aap.NewAapClient(env.AapConfig)
-				url = config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.collection")
-				status, response, err := aap.CreateEntities(aapClient, url, createEntitiesRequests)
+				initializeClientsInAap(c.Request.Context(), env, requestedBy.Id, newClients, log)
+
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PutClients(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutClients",
+		})
+
+		var requests []client.UpdateClientsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := c.MustGet("sub").(string)
+			var requestedBy *idp.Identity
+			if requestor != "" {
+				identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: requestor}})
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				if len(identities) > 0 {
+					requestedBy = &identities[0]
+				}
+			}
+
+			var updatedClients []idp.Client
+			var updatedRequests []*bulky.Request
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateClientsRequest)
 
+				log = log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbClients, err := idp.FetchClients(tx, requestedBy, []idp.Client{{Identity: idp.Identity{Id: r.Id}}})
 				if err != nil {
-					log.WithFields(logrus.Fields{"error": err.Error(), "newClients": newClients}).Debug("Failed to initialize entity in AAP model")
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbClients) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.CLIENT_NOT_FOUND)
+					return
 				}
 
-				log.WithFields(logrus.Fields{"status": status, "response": response}).Debug("Initialize request for clients in AAP model")
+				var jwks string
+				if r.Jwks != nil || r.JwksUri != "" {
+					if r.Jwks != nil && r.JwksUri != "" {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.CLIENT_JWKS_REQUIRED)
+						return
+					}
+
+					jwks, err = validateClientJwks(r.TokenEndpointAuthMethod, r.Jwks, r.JwksUri)
+					if err != nil {
+						log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Invalid jwks")
 
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.CLIENT_JWKS_INVALID)
+						return
+					}
+				}
+
+				// Switching to private_key_jwt requires keys, either given now or already on the client.
+				authMethod := r.TokenEndpointAuthMethod
+				if authMethod == "" {
+					authMethod = dbClients[0].TokenEndpointAuthMethod
+				}
+				if authMethod == TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT && jwks == "" && r.JwksUri == "" && dbClients[0].Jwks == "" && dbClients[0].JwksUri == "" {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.CLIENT_JWKS_REQUIRED)
+					return
+				}
+
+				updateClient := idp.Client{
+					Identity: idp.Identity{
+						Id: dbClients[0].Id,
+					},
+					Name:                    r.Name,
+					Description:             r.Description,
+					GrantTypes:              r.GrantTypes,
+					ResponseTypes:           r.ResponseTypes,
+					RedirectUris:            r.RedirectUris,
+					TokenEndpointAuthMethod: r.TokenEndpointAuthMethod,
+					PostLogoutRedirectUris:  r.PostLogoutRedirectUris,
+					Audiences:               r.Audiences,
+					Jwks:                    jwks,
+					JwksUri:                 r.JwksUri,
+				}
+
+				updatedClient, err := idp.UpdateClient(tx, requestedBy, updateClient)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if updatedClient.Id != "" {
+					updatedClients = append(updatedClients, updatedClient)
+					updatedRequests = append(updatedRequests, request)
+
+					updatedJwks, err := unmarshalClientJwks(updatedClient.Jwks)
+					if err != nil {
+						log.Debug(err.Error())
+					}
+
+					ok := client.UpdateClientsResponse{
+						Id:                      updatedClient.Id,
+						Name:                    updatedClient.Name,
+						Description:             updatedClient.Description,
+						GrantTypes:              updatedClient.GrantTypes,
+						ResponseTypes:           updatedClient.ResponseTypes,
+						RedirectUris:            updatedClient.RedirectUris,
+						TokenEndpointAuthMethod: updatedClient.TokenEndpointAuthMethod,
+						PostLogoutRedirectUris:  updatedClient.PostLogoutRedirectUris,
+						Audiences:               updatedClient.Audiences,
+						Jwks:                    updatedJwks,
+						JwksUri:                 updatedClient.JwksUri,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)
+				log.Debug("Update client failed. Hint: Maybe input validation needs to be improved.")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+
+				// Hydra must accept the changes before we commit, otherwise the two stores drift apart.
+				url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+
+				var previousHydraClients []idp.HydraClient
+				for i, u := range updatedClients {
+					hydraClient, err := idp.ReadHydraClient(c.Request.Context(), url, u.Id)
+					if err == nil {
+						update := hydraClient
+						update.Name = u.Name
+						update.GrantTypes = u.GrantTypes
+						update.Audience = u.Audiences
+						update.ResponseTypes = u.ResponseTypes
+						update.RedirectUris = u.RedirectUris
+						update.PostLogoutRedirectUris = u.PostLogoutRedirectUris
+						update.TokenEndpointAuthMethod = u.TokenEndpointAuthMethod
+						update.Jwks = newHydraClient(u).Jwks
+						update.JwksUri = u.JwksUri
+						_, err = idp.UpdateHydraClient(c.Request.Context(), url, u.Id, update)
+					}
+					if err != nil {
+						log.WithFields(logrus.Fields{"id": u.Id, "error": err.Error()}).Debug("Failed to update client in Hydra")
+
+						revertHydraClients(c.Request.Context(), url, previousHydraClients, log)
+
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						updatedRequests[i].Output = bulky.NewErrorResponse(updatedRequests[i].Index, http.StatusInternalServerError, E.CLIENT_NOT_UPDATED)
+						return
+					}
+
+					previousHydraClients = append(previousHydraClients, hydraClient)
+				}
+
+				err = tx.Commit()
+				if err != nil {
+					log.Debug(err.Error())
+					revertHydraClients(c.Request.Context(), url, previousHydraClients, log)
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				}
 				return
 			}
 
@@ -396,11 +596,22 @@ func PostClients(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
 
+// Best effort restore of hydra clients to the state they had before an update that was rolled back in the db.
+func revertHydraClients(ctx context.Context, url string, hydraClients []idp.HydraClient, log *logrus.Entry) {
+	for _, h := range hydraClients {
+		_, err := idp.UpdateHydraClient(ctx, url, h.Id, h)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": h.Id, "error": err.Error()}).Debug("Failed to revert client in Hydra")
+		}
+	}
+}
+
 func DeleteClients(env *app.Environment) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 
@@ -418,7 +629,7 @@ func DeleteClients(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -426,6 +637,7 @@ func DeleteClients(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -485,6 +697,7 @@ func DeleteClients(env *app.Environment) gin.HandlerFunc {
 
 					ok := client.DeleteClientsResponse{Id: deletedClient.Id}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+					idp.EmitEventClientDeleted(c.Request.Context(), &events, clientToDelete)
 					continue
 				}
 
@@ -501,12 +714,27 @@ func DeleteClients(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 
 				// proxy to hydra
 				url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+				ctx := c.Request.Context()
 				for _, c := range deleteHydraClients {
-					hydra.DeleteClient(url, c)
+					err := idp.DeleteHydraClient(ctx, url, c)
+					if err != nil {
+						// The client reconciliation job removes the orphan from hydra later on.
+						log.WithFields(logrus.Fields{"id": c, "error": err.Error()}).Debug("Failed to delete client in Hydra")
+					}
 				}
 
 				return
@@ -517,7 +745,103 @@ func DeleteClients(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
+
+// Hydra client with everything idp knows about the client. Scope is left empty, subscribe does this.
+func newHydraClient(c idp.Client) idp.HydraClient {
+	hydraClient := idp.HydraClient{
+		Client: hydra.Client{
+			Id:                      c.Id,
+			Name:                    c.Name,
+			Secret:                  c.Secret,
+			Scope:                   "",
+			GrantTypes:              c.GrantTypes,
+			Audience:                c.Audiences,
+			ResponseTypes:           c.ResponseTypes,
+			RedirectUris:            c.RedirectUris,
+			PostLogoutRedirectUris:  c.PostLogoutRedirectUris,
+			TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
+		},
+		JwksUri: c.JwksUri,
+	}
+	if c.Jwks != "" {
+		hydraClient.Jwks = json.RawMessage(c.Jwks)
+	}
+	return hydraClient
+}
+
+// Scopes every client is granted on its own entity in AAP, so it can manage its grants, publishings, subscriptions, consents and shadows.
+var clientEntityScopes = []string{
+	"aap:read:grants",
+	"aap:create:grants",
+	"aap:delete:grants",
+	"aap:read:publishes",
+	"aap:create:publishes",
+	"aap:delete:publishes",
+	"aap:read:subscriptions",
+	"aap:create:subscriptions",
+	"aap:delete:subscriptions",
+	"aap:read:consents",
+	"aap:create:consents",
+	"aap:delete:consents",
+	"aap:read:shadows",
+	"aap:create:shadows",
+	"aap:delete:shadows",
+
+	"mg:aap:read:grants",
+	"mg:aap:create:grants",
+	"mg:aap:delete:grants",
+	"mg:aap:read:publishes",
+	"mg:aap:create:publishes",
+	"mg:aap:delete:publishes",
+	"mg:aap:read:subscriptions",
+	"mg:aap:create:subscriptions",
+	"mg:aap:delete:subscriptions",
+	"mg:aap:read:consents",
+	"mg:aap:create:consents",
+	"mg:aap:delete:consents",
+	"mg:aap:read:shadows",
+	"mg:aap:create:shadows",
+	"mg:aap:delete:shadows",
+
+	"0:mg:aap:read:grants",
+	"0:mg:aap:create:grants",
+	"0:mg:aap:delete:grants",
+	"0:mg:aap:read:publishes",
+	"0:mg:aap:create:publishes",
+	"0:mg:aap:delete:publishes",
+	"0:mg:aap:read:subscriptions",
+	"0:mg:aap:create:subscriptions",
+	"0:mg:aap:delete:subscriptions",
+	"0:mg:aap:read:consents",
+	"0:mg:aap:create:consents",
+	"0:mg:aap:delete:consents",
+	"0:mg:aap:read:shadows",
+	"0:mg:aap:create:shadows",
+	"0:mg:aap:delete:shadows",
+}
+
+func initializeClientsInAap(ctx context.Context, env *app.Environment, creator string, newClients []idp.Client, log *logrus.Entry) {
+	var createEntitiesRequests []aap.CreateEntitiesRequest
+	for _, c := range newClients {
+		createEntitiesRequests = append(createEntitiesRequests, aap.CreateEntitiesRequest{
+			Reference: c.Id,
+			Creator:   creator,
+			Scopes:    clientEntityScopes,
+		})
+	}
+
+	aapClient := app.NewAapClient(ctx, env.AapConfig)
+	url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.collection")
+	status, response, err := aap.CreateEntities(aapClient, url, createEntitiesRequests)
+
+	if err != nil {
+		log.WithFields(logrus.Fields{"error": err.Error(), "newClients": newClients}).Debug("Failed to initialize entity in AAP model")
+	}
+
+	log.WithFields(logrus.Fields{"status": status, "response": response}).Debug("Initialize request for clients in AAP model")
+}
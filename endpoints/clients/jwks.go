@@ -0,0 +1,80 @@
+package clients
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/opensentry/idp/client"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT = "private_key_jwt"
+
+// Validates the jwks and jwks_uri of a client and returns the key set JSON encoded for storage.
+// Only public signing keys are accepted, a client must never hand over its private key.
+func validateClientJwks(tokenEndpointAuthMethod string, jwks *client.JsonWebKeySet, jwksUri string) (encodedJwks string, err error) {
+
+	if tokenEndpointAuthMethod == TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT {
+		if (jwks == nil) == (jwksUri == "") {
+			return "", errors.New("private_key_jwt requires exactly one of jwks or jwks_uri")
+		}
+	}
+
+	if jwksUri != "" {
+		u, err := url.Parse(jwksUri)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "https" {
+			return "", errors.New("jwks_uri must use https")
+		}
+	}
+
+	if jwks == nil {
+		return "", nil
+	}
+
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		return "", err
+	}
+
+	var keySet jose.JSONWebKeySet
+	err = json.Unmarshal(data, &keySet)
+	if err != nil {
+		return "", err
+	}
+
+	if len(keySet.Keys) <= 0 {
+		return "", errors.New("jwks has no keys")
+	}
+
+	for _, key := range keySet.Keys {
+		if !key.Valid() {
+			return "", errors.New("jwks contains an invalid key")
+		}
+		if !key.IsPublic() {
+			return "", errors.New("jwks contains a private key")
+		}
+		if key.Use != "" && key.Use != "sig" {
+			return "", errors.New("jwks contains a key not meant for signing")
+		}
+	}
+
+	return string(data), nil
+}
+
+func unmarshalClientJwks(encodedJwks string) (jwks *client.JsonWebKeySet, err error) {
+	if encodedJwks == "" {
+		return nil, nil
+	}
+
+	jwks = &client.JsonWebKeySet{}
+	err = json.Unmarshal([]byte(encodedJwks), jwks)
+	if err != nil {
+		return nil, err
+	}
+	return jwks, nil
+}
@@ -0,0 +1,609 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	sec "github.com/opensentry/idp/secret"
+)
+
+// Dynamic client registration, RFC 7591 (registration) and RFC 7592 (management).
+// These endpoints are not protected by access tokens issued by hydra. Registration requires an initial access token from config
+// client.registration.initial_access_tokens and management requires the registration access token handed out on registration.
+
+const DEFAULT_REGISTRATION_ENDPOINT = "/connect/register"
+const DEFAULT_REGISTRATION_MAX_REDIRECT_URIS = 10
+
+var defaultRegistrationGrantTypes = []string{"authorization_code", "refresh_token", "client_credentials"}
+var defaultRegistrationRedirectUriSchemes = []string{"https"}
+
+func PostClientRegistration(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostClientRegistration",
+		})
+
+		if !validInitialAccessToken(bearerToken(c)) {
+			abortWithInvalidToken(c)
+			return
+		}
+
+		var r client.ClientRegistrationRequest
+		err := c.BindJSON(&r)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_CLIENT_METADATA, ErrorDescription: err.Error()})
+			return
+		}
+
+		jwks, regErr := validateRegistrationMetadata(&r)
+		if regErr != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, regErr)
+			return
+		}
+
+		keys := config.GetStringSlice("crypto.keys.clients")
+		if len(keys) <= 0 {
+			log.WithFields(logrus.Fields{"key": "crypto.keys.clients"}).Debug("Missing config")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		cryptoKey := keys[0]
+
+		newClient := idp.Client{
+			Identity: idp.Identity{
+				Issuer: config.GetString("idp.public.issuer"),
+			},
+			Name:                    r.ClientName,
+			Description:             "Registered through dynamic client registration",
+			GrantTypes:              r.GrantTypes,
+			ResponseTypes:           r.ResponseTypes,
+			RedirectUris:            r.RedirectUris,
+			PostLogoutRedirectUris:  r.PostLogoutRedirectUris,
+			TokenEndpointAuthMethod: r.TokenEndpointAuthMethod,
+			Jwks:                    jwks,
+			JwksUri:                 r.JwksUri,
+		}
+
+		var secret string
+		if clientHasSecret(r.TokenEndpointAuthMethod) {
+			// BCrypt used by hydra to store passwords securely limits password to 55 chars not counting the terminating zero
+			secret, err = sec.CreateClientSecret(sec.RECOMMENDED_CLIENT_SECRET_ENTROPY_IN_BYTES)
+			if err != nil {
+				log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to generate random secret")
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+
+			newClient.Secret, err = idp.Encrypt(secret, cryptoKey) // Encrypt the secret before storage
+			if err != nil {
+				log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to encrypt secret")
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		registrationAccessToken, err := sec.CreateClientSecret(sec.RECOMMENDED_CLIENT_SECRET_ENTROPY_IN_BYTES)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to generate registration access token")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		newClient.RegistrationAccessToken = hashRegistrationAccessToken(registrationAccessToken)
+
+		session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to begin transaction")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer tx.Close() // rolls back if not already committed/rolled back
+		defer session.Close()
+		var events idp.EventBatch // Queued in the outbox as part of tx
+
+		// Registered clients are managed by the identity in config client.registration.owner, without it they are managed by no one.
+		var managedBy *idp.Identity
+		if owner := config.GetString("client.registration.owner"); owner != "" {
+			identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: owner}})
+			if err != nil || len(identities) <= 0 {
+				log.WithFields(logrus.Fields{"key": "client.registration.owner", "id": owner}).Debug("Registration owner not found")
+				tx.Rollback()
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			managedBy = &identities[0]
+		}
+
+		objClient, err := idp.CreateClient(tx, managedBy, newClient)
+		if err != nil || objClient.Id == "" {
+			log.WithFields(logrus.Fields{"name": newClient.Name}).Debug("Failed to create client")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		idp.EmitEventClientCreated(c.Request.Context(), &events, objClient)
+		err = idp.QueueEvents(tx, &events)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": objClient.Id, "error": err.Error()}).Debug("Failed to queue events")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		// The client in the db is encrypted, we need the clean secret to return to the client and use in hydra.
+		objClient.Secret = secret
+
+		// Unlike POST /clients hydra must accept the client before we commit, a partner has no way to repair a half registered client.
+		hydraUrl := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+		_, err = idp.CreateHydraClient(c.Request.Context(), hydraUrl, newHydraClient(objClient))
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": objClient.Id, "error": err.Error()}).Debug("Failed to create client in Hydra")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": objClient.Id, "error": err.Error()}).Debug("Failed to commit transaction")
+			e := idp.DeleteHydraClient(c.Request.Context(), hydraUrl, objClient.Id)
+			if e != nil {
+				log.WithFields(logrus.Fields{"id": objClient.Id, "error": e.Error()}).Debug("Failed to delete client in Hydra")
+			}
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		// Dynamically registered clients have no human creator, so the idp itself creates the entity in AAP.
+		initializeClientsInAap(c.Request.Context(), env, config.GetString("oauth2.client.id"), []idp.Client{objClient}, log)
+
+		response := newClientRegistrationResponse(objClient, r.Jwks)
+		response.RegistrationAccessToken = registrationAccessToken
+		c.JSON(http.StatusCreated, response)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func GetClientRegistration(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetClientRegistration",
+		})
+
+		keys := config.GetStringSlice("crypto.keys.clients")
+		if len(keys) <= 0 {
+			log.WithFields(logrus.Fields{"key": "crypto.keys.clients"}).Debug("Missing config")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		cryptoKey := keys[0]
+
+		session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to begin transaction")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer tx.Close() // rolls back if not already committed/rolled back
+		defer session.Close()
+
+		dbClient, ok := fetchRegisteredClient(c, tx, log)
+		if !ok {
+			return
+		}
+		tx.Commit()
+
+		if dbClient.Secret != "" {
+			dbClient.Secret, err = idp.Decrypt(dbClient.Secret, cryptoKey)
+			if err != nil {
+				log.WithFields(logrus.Fields{"id": dbClient.Id, "error": err.Error()}).Debug("Failed to decrypt secret")
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		jwks, err := unmarshalClientJwks(dbClient.Jwks)
+		if err != nil {
+			log.Debug(err.Error())
+		}
+
+		c.JSON(http.StatusOK, newClientRegistrationResponse(dbClient, jwks))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PutClientRegistration(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutClientRegistration",
+		})
+
+		keys := config.GetStringSlice("crypto.keys.clients")
+		if len(keys) <= 0 {
+			log.WithFields(logrus.Fields{"key": "crypto.keys.clients"}).Debug("Missing config")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		cryptoKey := keys[0]
+
+		session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to begin transaction")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer tx.Close() // rolls back if not already committed/rolled back
+		defer session.Close()
+		var events idp.EventBatch // Queued in the outbox as part of tx
+
+		dbClient, ok := fetchRegisteredClient(c, tx, log)
+		if !ok {
+			return
+		}
+
+		var r client.ClientRegistrationRequest
+		err = c.BindJSON(&r)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_CLIENT_METADATA, ErrorDescription: err.Error()})
+			return
+		}
+
+		if r.ClientId != dbClient.Id {
+			c.AbortWithStatusJSON(http.StatusBadRequest, client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_CLIENT_METADATA, ErrorDescription: "client_id does not match the client being updated"})
+			return
+		}
+
+		// Changing the authentication method would leave the client with a missing or superfluous secret.
+		if r.TokenEndpointAuthMethod != "" && r.TokenEndpointAuthMethod != dbClient.TokenEndpointAuthMethod {
+			c.AbortWithStatusJSON(http.StatusBadRequest, client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_CLIENT_METADATA, ErrorDescription: "token_endpoint_auth_method can not be changed"})
+			return
+		}
+		r.TokenEndpointAuthMethod = dbClient.TokenEndpointAuthMethod
+
+		jwks, regErr := validateRegistrationMetadata(&r)
+		if regErr != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, regErr)
+			return
+		}
+
+		// RFC 7592 section 2.2: Omitted fields are removed, so the metadata is replaced as a whole.
+		updateClient := idp.Client{
+			Identity:                idp.Identity{Id: dbClient.Id},
+			Name:                    r.ClientName,
+			GrantTypes:              r.GrantTypes,
+			ResponseTypes:           r.ResponseTypes,
+			RedirectUris:            r.RedirectUris,
+			PostLogoutRedirectUris:  r.PostLogoutRedirectUris,
+			TokenEndpointAuthMethod: r.TokenEndpointAuthMethod,
+			Jwks:                    jwks,
+			JwksUri:                 r.JwksUri,
+		}
+
+		updatedClient, err := idp.ReplaceClient(tx, nil, updateClient)
+		if err != nil || updatedClient.Id == "" {
+			log.WithFields(logrus.Fields{"id": dbClient.Id}).Debug("Failed to update client")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		idp.EmitEventClientUpdated(c.Request.Context(), &events, updatedClient)
+		err = idp.QueueEvents(tx, &events)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": updatedClient.Id, "error": err.Error()}).Debug("Failed to queue events")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		// Hydra must accept the changes before we commit, otherwise the two stores drift apart.
+		hydraUrl := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+		previousHydraClient, err := idp.ReadHydraClient(c.Request.Context(), hydraUrl, updatedClient.Id)
+		if err == nil {
+			update := previousHydraClient
+			update.Name = updatedClient.Name
+			update.GrantTypes = updatedClient.GrantTypes
+			update.ResponseTypes = updatedClient.ResponseTypes
+			update.RedirectUris = updatedClient.RedirectUris
+			update.PostLogoutRedirectUris = updatedClient.PostLogoutRedirectUris
+			update.JwksUri = updatedClient.JwksUri
+			update.Jwks = newHydraClient(updatedClient).Jwks
+			_, err = idp.UpdateHydraClient(c.Request.Context(), hydraUrl, updatedClient.Id, update)
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": updatedClient.Id, "error": err.Error()}).Debug("Failed to update client in Hydra")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": updatedClient.Id, "error": err.Error()}).Debug("Failed to commit transaction")
+			revertHydraClients(c.Request.Context(), hydraUrl, []idp.HydraClient{previousHydraClient}, log)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if updatedClient.Secret != "" {
+			updatedClient.Secret, err = idp.Decrypt(updatedClient.Secret, cryptoKey)
+			if err != nil {
+				log.WithFields(logrus.Fields{"id": updatedClient.Id, "error": err.Error()}).Debug("Failed to decrypt secret")
+				updatedClient.Secret = ""
+			}
+		}
+
+		c.JSON(http.StatusOK, newClientRegistrationResponse(updatedClient, r.Jwks))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func DeleteClientRegistration(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "DeleteClientRegistration",
+		})
+
+		session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to begin transaction")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer tx.Close() // rolls back if not already committed/rolled back
+		defer session.Close()
+		var events idp.EventBatch // Queued in the outbox as part of tx
+
+		dbClient, ok := fetchRegisteredClient(c, tx, log)
+		if !ok {
+			return
+		}
+
+		_, err = idp.DeleteClient(tx, nil, dbClient)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": dbClient.Id, "error": err.Error()}).Debug("Failed to delete client")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		idp.EmitEventClientDeleted(c.Request.Context(), &events, dbClient)
+		err = idp.QueueEvents(tx, &events)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": dbClient.Id, "error": err.Error()}).Debug("Failed to queue events")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		hydraUrl := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+		err = idp.DeleteHydraClient(c.Request.Context(), hydraUrl, dbClient.Id)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": dbClient.Id, "error": err.Error()}).Debug("Failed to delete client in Hydra")
+			tx.Rollback()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			// The client reconciliation job recreates the client in hydra later on.
+			log.WithFields(logrus.Fields{"id": dbClient.Id, "error": err.Error()}).Debug("Failed to commit transaction")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// Fetches the client from the path and checks the registration access token. Aborts the request and returns false if not allowed.
+// An unknown client and a wrong token give the same response to not disclose which clients exist.
+func fetchRegisteredClient(c *gin.Context, tx neo4j.Transaction, log *logrus.Entry) (dbClient idp.Client, ok bool) {
+	token := bearerToken(c)
+	if token == "" {
+		abortWithInvalidToken(c)
+		return idp.Client{}, false
+	}
+
+	dbClients, err := idp.FetchClients(tx, nil, []idp.Client{{Identity: idp.Identity{Id: c.Param("client_id")}}})
+	if err != nil {
+		log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to fetch client")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return idp.Client{}, false
+	}
+
+	if len(dbClients) <= 0 || dbClients[0].RegistrationAccessToken == "" {
+		abortWithInvalidToken(c)
+		return idp.Client{}, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(dbClients[0].RegistrationAccessToken), []byte(hashRegistrationAccessToken(token))) != 1 {
+		abortWithInvalidToken(c)
+		return idp.Client{}, false
+	}
+
+	return dbClients[0], true
+}
+
+// Applies the RFC 7591 defaults and the registration policy from config. Returns the JSON encoded jwks for storage.
+func validateRegistrationMetadata(r *client.ClientRegistrationRequest) (jwks string, regErr *client.ClientRegistrationError) {
+	invalidMetadata := func(description string) *client.ClientRegistrationError {
+		return &client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_CLIENT_METADATA, ErrorDescription: description}
+	}
+	invalidRedirectUri := func(description string) *client.ClientRegistrationError {
+		return &client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_REDIRECT_URI, ErrorDescription: description}
+	}
+
+	if len(r.GrantTypes) == 0 {
+		r.GrantTypes = []string{"authorization_code"}
+	}
+	if len(r.ResponseTypes) == 0 {
+		r.ResponseTypes = []string{"code"}
+	}
+	if r.TokenEndpointAuthMethod == "" {
+		r.TokenEndpointAuthMethod = "client_secret_basic"
+	}
+	if r.ClientName == "" {
+		r.ClientName = "Dynamically registered client"
+	}
+
+	allowedGrantTypes := config.GetStringSlice("client.registration.allowed_grant_types")
+	if len(allowedGrantTypes) == 0 {
+		allowedGrantTypes = defaultRegistrationGrantTypes
+	}
+	for _, grantType := range r.GrantTypes {
+		if !containsString(allowedGrantTypes, grantType) {
+			return "", invalidMetadata("grant_type " + grantType + " is not allowed")
+		}
+	}
+
+	for _, responseType := range r.ResponseTypes {
+		switch responseType {
+		case "code":
+			if !containsString(r.GrantTypes, "authorization_code") {
+				return "", invalidMetadata("response_type code requires grant_type authorization_code")
+			}
+		case "token":
+			if !containsString(r.GrantTypes, "implicit") {
+				return "", invalidMetadata("response_type token requires grant_type implicit")
+			}
+		default:
+			return "", invalidMetadata("response_type " + responseType + " is not supported")
+		}
+	}
+
+	switch r.TokenEndpointAuthMethod {
+	case "none", "client_secret_post", "client_secret_basic", TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT:
+	default:
+		return "", invalidMetadata("token_endpoint_auth_method " + r.TokenEndpointAuthMethod + " is not supported")
+	}
+
+	jwks, err := validateClientJwks(r.TokenEndpointAuthMethod, r.Jwks, r.JwksUri)
+	if err != nil {
+		return "", invalidMetadata(err.Error())
+	}
+
+	maxRedirectUris := config.GetInt("client.registration.max_redirect_uris")
+	if maxRedirectUris <= 0 {
+		maxRedirectUris = DEFAULT_REGISTRATION_MAX_REDIRECT_URIS
+	}
+	if len(r.RedirectUris) > maxRedirectUris || len(r.PostLogoutRedirectUris) > maxRedirectUris {
+		return "", invalidRedirectUri("too many redirect uris")
+	}
+
+	if (containsString(r.GrantTypes, "authorization_code") || containsString(r.GrantTypes, "implicit")) && len(r.RedirectUris) == 0 {
+		return "", invalidRedirectUri("redirect_uris are required for grant types using redirects")
+	}
+
+	allowedSchemes := config.GetStringSlice("client.registration.allowed_redirect_uri_schemes")
+	if len(allowedSchemes) == 0 {
+		allowedSchemes = defaultRegistrationRedirectUriSchemes
+	}
+	for _, redirectUri := range append(append([]string{}, r.RedirectUris...), r.PostLogoutRedirectUris...) {
+		u, err := url.Parse(redirectUri)
+		if err != nil || u.Scheme == "" {
+			return "", invalidRedirectUri(redirectUri + " is not an absolute uri")
+		}
+		if u.Fragment != "" {
+			return "", invalidRedirectUri(redirectUri + " must not contain a fragment")
+		}
+		if !containsString(allowedSchemes, strings.ToLower(u.Scheme)) {
+			return "", invalidRedirectUri(redirectUri + " uses a scheme that is not allowed")
+		}
+	}
+
+	return jwks, nil
+}
+
+func newClientRegistrationResponse(c idp.Client, jwks *client.JsonWebKeySet) client.ClientRegistrationResponse {
+	return client.ClientRegistrationResponse{
+		ClientId:                c.Id,
+		ClientSecret:            c.Secret,
+		ClientIdIssuedAt:        c.IssuedAt,
+		ClientSecretExpiresAt:   0, // Secrets do not expire
+		RegistrationClientUri:   registrationClientUri(c.Id),
+		ClientName:              c.Name,
+		RedirectUris:            c.RedirectUris,
+		PostLogoutRedirectUris:  c.PostLogoutRedirectUris,
+		GrantTypes:              c.GrantTypes,
+		ResponseTypes:           c.ResponseTypes,
+		TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
+		Jwks:                    jwks,
+		JwksUri:                 c.JwksUri,
+	}
+}
+
+func registrationClientUri(id string) string {
+	endpoint := config.GetString("idp.public.endpoints.clients.registration")
+	if endpoint == "" {
+		endpoint = DEFAULT_REGISTRATION_ENDPOINT
+	}
+	return config.GetString("idp.public.url") + endpoint + "/" + id
+}
+
+func clientHasSecret(tokenEndpointAuthMethod string) bool {
+	return tokenEndpointAuthMethod != "none" && tokenEndpointAuthMethod != TOKEN_ENDPOINT_AUTH_METHOD_PRIVATE_KEY_JWT
+}
+
+func validInitialAccessToken(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	// No configured tokens means registration is closed.
+	valid := false
+	for _, t := range config.GetStringSlice("client.registration.initial_access_tokens") {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// @SecurityRisk: Only the hash of registration access tokens is stored, they grant full control over the client.
+func hashRegistrationAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func bearerToken(c *gin.Context) string {
+	split := strings.SplitN(c.Request.Header.Get("Authorization"), " ", 2)
+	if len(split) == 2 && strings.EqualFold(split[0], "bearer") {
+		return split[1]
+	}
+	return ""
+}
+
+func abortWithInvalidToken(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, client.ClientRegistrationError{Error: client.REGISTRATION_ERROR_INVALID_TOKEN})
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package clients
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	E "github.com/opensentry/idp/client/errors"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+	sec "github.com/opensentry/idp/secret"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+func PutClientsSecret(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutClientsSecret",
+		})
+
+		var requests []client.UpdateClientsSecretRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		keys := config.GetStringSlice("crypto.keys.clients")
+		if len(keys) <= 0 {
+			log.WithFields(logrus.Fields{"key": "crypto.keys.clients"}).Debug("Missing config")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		cryptoKey := keys[0]
+
+		defaultGracePeriod := int64(config.GetInt("client.secret.rotation.grace_period")) // 0 pushes the new secret to Hydra right away
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
+
+			requestor := c.MustGet("sub").(string)
+			var requestedBy *idp.Identity
+			if requestor != "" {
+				identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: requestor}})
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				if len(identities) > 0 {
+					requestedBy = &identities[0]
+				}
+			}
+
+			var rotatedClients []idp.Client
+			var rotatedRequests []*bulky.Request
+			var previousSecrets []string // Encrypted as in the db, used to revert hydra
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateClientsSecretRequest)
+
+				log = log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbClients, err := idp.FetchClients(tx, requestedBy, []idp.Client{{Identity: idp.Identity{Id: r.Id}}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbClients) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.CLIENT_NOT_FOUND)
+					return
+				}
+				dbClient := dbClients[0]
+
+				if dbClient.Secret == "" {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.CLIENT_IS_PUBLIC)
+					return
+				}
+
+				// BCrypt used by hydra to store passwords securely limits password to 55 chars not counting the terminating zero
+				secret, err := sec.CreateClientSecret(sec.RECOMMENDED_CLIENT_SECRET_ENTROPY_IN_BYTES)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to generate random secret")
+					return
+				}
+
+				encryptedClientSecret, err := idp.Encrypt(secret, cryptoKey) // Encrypt the secret before storage
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to encrypt secret")
+					return
+				}
+
+				gracePeriod := defaultGracePeriod
+				if r.GracePeriod != nil {
+					gracePeriod = *r.GracePeriod
+				}
+
+				// Hydra only holds one secret per client. Within the grace period it keeps the previous secret,
+				// and jobs.CleanupExpiredClientSecrets pushes the new one once the grace period has passed.
+				var secondarySecretExpiresAt int64
+				if gracePeriod > 0 {
+					secondarySecretExpiresAt = time.Now().Unix() + gracePeriod
+				}
+
+				rotatedClient, err := idp.RotateClientSecret(tx, requestedBy, idp.Client{
+					Identity:                 idp.Identity{Id: dbClient.Id},
+					Secret:                   encryptedClientSecret,
+					SecondarySecretExpiresAt: secondarySecretExpiresAt,
+				})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if rotatedClient.Id != "" {
+
+					// The client in the db is encrypted, we need the clean secret to return to user and use in hydra.
+					rotatedClient.Secret = secret
+
+					rotatedClients = append(rotatedClients, rotatedClient)
+					rotatedRequests = append(rotatedRequests, request)
+					if dbClient.SecondarySecret != "" {
+						previousSecrets = append(previousSecrets, dbClient.SecondarySecret) // Still the one in hydra
+					} else {
+						previousSecrets = append(previousSecrets, dbClient.Secret)
+					}
+					idp.EmitEventClientSecretRotated(c.Request.Context(), &events, rotatedClient)
+
+					ok := client.UpdateClientsSecretResponse{
+						Id:                       rotatedClient.Id,
+						Secret:                   rotatedClient.Secret,
+						Name:                     rotatedClient.Name,
+						Description:              rotatedClient.Description,
+						GrantTypes:               rotatedClient.GrantTypes,
+						ResponseTypes:            rotatedClient.ResponseTypes,
+						RedirectUris:             rotatedClient.RedirectUris,
+						TokenEndpointAuthMethod:  rotatedClient.TokenEndpointAuthMethod,
+						PostLogoutRedirectUris:   rotatedClient.PostLogoutRedirectUris,
+						Audiences:                rotatedClient.Audiences,
+						SecondarySecretExpiresAt: rotatedClient.SecondarySecretExpiresAt,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)
+				log.Debug("Rotate client secret failed. Hint: Maybe input validation needs to be improved.")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				// Without a grace period Hydra must accept the new secret before we commit, otherwise the two stores drift apart.
+				url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+
+				var previousHydraClients []idp.HydraClient
+				for i, rc := range rotatedClients {
+					if rc.SecondarySecretExpiresAt > 0 {
+						continue
+					}
+
+					hydraClient, err := idp.ReadHydraClient(c.Request.Context(), url, rc.Id)
+					if err == nil {
+						update := hydraClient
+						update.Secret = rc.Secret
+						_, err = idp.UpdateHydraClient(c.Request.Context(), url, rc.Id, update)
+					}
+					if err != nil {
+						log.WithFields(logrus.Fields{"id": rc.Id, "error": err.Error()}).Debug("Failed to update client secret in Hydra")
+
+						revertHydraClientSecrets(c.Request.Context(), url, previousHydraClients, cryptoKey, log)
+
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						rotatedRequests[i].Output = bulky.NewErrorResponse(rotatedRequests[i].Index, http.StatusInternalServerError, E.CLIENT_NOT_UPDATED)
+						return
+					}
+
+					// Hydra never returns the secret, so the reverted client needs the previous one from the db.
+					previous := hydraClient
+					previous.Secret = previousSecrets[i]
+					previousHydraClients = append(previousHydraClients, previous)
+				}
+
+				err = tx.Commit()
+				if err != nil {
+					log.Debug(err.Error())
+					revertHydraClientSecrets(c.Request.Context(), url, previousHydraClients, cryptoKey, log)
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					return
+				}
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// Best effort restore of the previous secrets in hydra. The secrets given are encrypted as stored in the db.
+func revertHydraClientSecrets(ctx context.Context, url string, hydraClients []idp.HydraClient, cryptoKey string, log *logrus.Entry) {
+	var revertClients []idp.HydraClient
+	for _, h := range hydraClients {
+		secret, err := idp.Decrypt(h.Secret, cryptoKey)
+		if err != nil {
+			log.WithFields(logrus.Fields{"id": h.Id, "error": err.Error()}).Debug("Failed to decrypt previous client secret")
+			continue
+		}
+		h.Secret = secret
+		revertClients = append(revertClients, h)
+	}
+	revertHydraClients(ctx, url, revertClients, log)
+}
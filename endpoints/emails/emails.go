@@ -0,0 +1,163 @@
+package emails
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	E "github.com/opensentry/idp/client/errors"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+// Fills in for the ids of the challenge or invite a real email is sent for
+const SAMPLE_ID = "00000000-0000-0000-0000-000000000000"
+
+func PostEmailsPreview(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostEmailsPreview",
+		})
+
+		var requests []client.CreateEmailsPreviewRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateEmailsPreviewRequest)
+
+				log := log.WithFields(logrus.Fields{"template": r.Template, "locale": r.Locale})
+
+				emailTemplates := env.EmailTemplates[r.Template]
+				if emailTemplates == nil {
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.EMAIL_TEMPLATE_NOT_FOUND)
+					continue
+				}
+				emailTemplate := emailTemplates.Locale(r.Locale)
+
+				text, html, err := emailTemplate.Render(templateData(r.Template, emailTemplate, "", r.Data))
+				if err != nil {
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.EMAIL_TEMPLATE_DATA_INVALID)
+					log.Debug(err.Error())
+					continue
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, client.CreateEmailsPreviewResponse{
+					Template: r.Template,
+					Locale:   emailTemplate.Locale,
+					Subject:  emailTemplate.Subject,
+					Text:     text,
+					Html:     html,
+				})
+			}
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// PostEmailsSend sends the email right away using the mailer instead of the outbox, so errors of the mail transport are seen by the caller.
+func PostEmailsSend(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostEmailsSend",
+		})
+
+		var requests []client.CreateEmailsSendRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateEmailsSendRequest)
+
+				log := log.WithFields(logrus.Fields{"template": r.Template, "locale": r.Locale})
+
+				emailTemplates := env.EmailTemplates[r.Template]
+				if emailTemplates == nil {
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.EMAIL_TEMPLATE_NOT_FOUND)
+					continue
+				}
+				emailTemplate := emailTemplates.Locale(r.Locale)
+
+				m, err := idp.ComposeEmailUsingTemplate(r.To, r.To, emailTemplate, templateData(r.Template, emailTemplate, r.To, r.Data))
+				if err != nil {
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.EMAIL_TEMPLATE_DATA_INVALID)
+					log.Debug(err.Error())
+					continue
+				}
+
+				err = idp.DeliverEmail(c.Request.Context(), env.Mailer, m)
+				if err != nil {
+					request.Output = bulky.NewErrorResponse(request.Index, http.StatusBadGateway, E.EMAIL_NOT_SENT)
+					log.Debug(err.Error())
+					continue
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, client.CreateEmailsSendResponse{
+					Template: r.Template,
+					Locale:   emailTemplate.Locale,
+					To:       r.To,
+				})
+				log.WithFields(logrus.Fields{"to": r.To}).Debug("Test email sent")
+			}
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// templateData is sample data with the keys the endpoints render the template with, overridden by data key by key.
+func templateData(name string, tpl *idp.EmailTemplate, email string, data map[string]interface{}) map[string]interface{} {
+	if email == "" {
+		email = tpl.Sender.Email
+	}
+
+	sample := map[string]interface{}{
+		"Id":    SAMPLE_ID,
+		"Email": email,
+	}
+
+	if name == idp.EMAIL_TEMPLATE_INVITE {
+		u, err := url.Parse(config.GetString("invite.url"))
+		if err == nil {
+			q := u.Query()
+			q.Add("id", SAMPLE_ID)
+			u.RawQuery = q.Encode()
+			sample["InvitationUrl"] = u.String()
+		}
+		sample["IdentityProvider"] = config.GetString("provider.name")
+	} else {
+		sample["Challenge"] = SAMPLE_ID
+		sample["Sender"] = tpl.Sender.Name
+		sample["Code"] = "123456"
+	}
+
+	for k, v := range data {
+		sample[k] = v
+	}
+	return sample
+}
@@ -0,0 +1,152 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+)
+
+const DEFAULT_CHECK_TIMEOUT = 2 * time.Second
+
+type check func(ctx context.Context, env *app.Environment) error
+
+var checks = map[string]check{
+	"neo4j": checkNeo4j,
+	"nats":  checkNats,
+	"hydra": checkHydra,
+	"aap":   checkAap,
+	"smtp":  checkSmtp,
+}
+
+// GetAlive answers as long as the process is able to serve requests. It checks no dependencies, so an orchestrator does not restart the idp because of an outage elsewhere.
+func GetAlive(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.JSON(http.StatusOK, client.HealthResponse{Status: client.HEALTH_STATUS_OK})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GetReady checks all dependencies concurrently and answers 503 if any of them is unavailable.
+func GetReady(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetReady",
+		})
+
+		timeout := time.Duration(config.GetInt("health.timeout")) * time.Second
+		if timeout <= 0 {
+			timeout = DEFAULT_CHECK_TIMEOUT
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		response := client.HealthResponse{Status: client.HEALTH_STATUS_OK, Checks: make(map[string]client.HealthCheck)}
+
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		for name, chk := range checks {
+			wg.Add(1)
+			go func(name string, chk check) {
+				defer wg.Done()
+
+				start := time.Now()
+				err := runCheck(ctx, env, chk)
+				result := client.HealthCheck{Status: client.HEALTH_STATUS_OK, Latency: time.Since(start).Milliseconds()}
+				if err != nil {
+					log.WithFields(logrus.Fields{"dependency": name, "error": err.Error()}).Info("Dependency unavailable")
+					result.Status = client.HEALTH_STATUS_UNAVAILABLE
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				response.Checks[name] = result
+				if err != nil {
+					response.Status = client.HEALTH_STATUS_UNAVAILABLE
+				}
+			}(name, chk)
+		}
+		wg.Wait()
+
+		if response.Status != client.HEALTH_STATUS_OK {
+			c.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// runCheck bounds checks that can not be cancelled, like the neo4j driver, by the deadline of ctx.
+func runCheck(ctx context.Context, env *app.Environment, chk check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- chk(ctx, env)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func checkNeo4j(ctx context.Context, env *app.Environment) error {
+	return env.Driver.VerifyConnectivity()
+}
+
+func checkNats(ctx context.Context, env *app.Environment) error {
+	if env.Nats == nil || !env.Nats.IsConnected() {
+		return errors.New("Not connected to NATS")
+	}
+	return nil
+}
+
+func checkHydra(ctx context.Context, env *app.Environment) error {
+	return checkHttp(ctx, config.GetString("hydra.public.url")+"/health/ready", true)
+}
+
+// AAP has no health endpoint, any answer that is not a server error means it is reachable.
+func checkAap(ctx context.Context, env *app.Environment) error {
+	return checkHttp(ctx, config.GetString("aap.public.url"), false)
+}
+
+func checkHttp(ctx context.Context, url string, requireOk bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 500 || (requireOk && res.StatusCode != http.StatusOK) {
+		return errors.New("Unexpected status " + http.StatusText(res.StatusCode))
+	}
+	return nil
+}
+
+// checkSmtp connects to the relay and waits for its greeting, without sending any mail. Other transports are always ready.
+func checkSmtp(ctx context.Context, env *app.Environment) error {
+	mailer, ok := env.Mailer.(*idp.SMTPMailer)
+	if !ok {
+		return nil
+	}
+	return mailer.Ping(ctx)
+}
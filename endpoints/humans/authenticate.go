@@ -12,6 +12,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 	hydra "github.com/charmixer/hydra/client"
@@ -40,7 +41,7 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		hydraClient := hydra.NewHydraClient(env.HydraConfig)
+		hydraClient := app.NewHydraClient(c.Request.Context(), env.HydraConfig)
 
 		controllerVerifyOtp := config.GetString("idpui.public.url") + config.GetString("idpui.public.endpoints.verify")
 		redirectToVerifyOtp, err := url.Parse(controllerVerifyOtp)
@@ -66,25 +67,11 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		var templateFile string
-		var emailSubject string
-		var sender idp.SMTPSender
-
-		sender = idp.SMTPSender{Name: config.GetString("provider.name"), Email: config.GetString("provider.email")}
-		templateFile = config.GetString("templates.emailconfirm.email.templatefile")
-		emailSubject = config.GetString("templates.emailconfirm.email.subject")
-
-		smtpConfig := idp.SMTPConfig{
-			Host:          config.GetString("mail.smtp.host"),
-			Username:      config.GetString("mail.smtp.user"),
-			Password:      config.GetString("mail.smtp.password"),
-			Sender:        sender,
-			SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
-		}
+		emailTemplates := env.EmailTemplates[idp.EMAIL_TEMPLATE_EMAILCONFIRM]
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -92,6 +79,7 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -112,7 +100,7 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 
 				log = log.WithFields(logrus.Fields{"challenge": r.Challenge})
 
-				hydraLoginResponse, err := hydra.GetLogin(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.login"), hydraClient, r.Challenge)
+				hydraLoginResponse, err := idp.ReadHydraLogin(c.Request.Context(), config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.login"), hydraClient, r.Challenge)
 				if err != nil {
 					e := tx.Rollback()
 					if e != nil {
@@ -129,6 +117,7 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 
 				var subject string = hydraLoginResponse.Subject
 				var clientId string = hydraLoginResponse.Client.ClientId
+				var uiLocales []string = hydraLoginResponse.OidcContext.UiLocales
 
 				deny := client.CreateHumansAuthenticateResponse{}
 				deny.Id = subject
@@ -251,7 +240,8 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 
 					log.WithFields(logrus.Fields{"acr": acr, "id": accept.Id}).Debug("Authenticated")
 					request.Output = bulky.NewOkResponse(request.Index, accept)
-					idp.EmitEventIdentityAuthenticated(env.Nats, idp.Identity{Id: accept.Id}, acr)
+					idp.EmitEventIdentityAuthenticated(c.Request.Context(), &events, idp.Identity{Id: accept.Id}, acr)
+					metrics.ObserveAuthentication(acr, true)
 					continue
 				}
 
@@ -298,6 +288,7 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 							return
 						}
 						log.WithFields(logrus.Fields{"id": challenge.Subject}).Debug("Email Confirmed")
+						idp.EmitEventHumanEmailConfirmed(c.Request.Context(), &events, idp.Human{Identity: idp.Identity{Id: challenge.Subject}})
 
 						log.WithFields(logrus.Fields{"fixme": 1}).Debug("Check if challenge actually matches login_challenge and that session matches?")
 
@@ -335,7 +326,8 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 
 						log.WithFields(logrus.Fields{"acr": acr, "id": accept.Id}).Debug("Authenticated")
 						request.Output = bulky.NewOkResponse(request.Index, accept)
-						idp.EmitEventIdentityAuthenticated(env.Nats, idp.Identity{Id: accept.Id}, acr)
+						idp.EmitEventIdentityAuthenticated(c.Request.Context(), &events, idp.Identity{Id: accept.Id}, acr)
+						metrics.ObserveAuthentication(acr, true)
 						continue
 					}
 
@@ -409,12 +401,14 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 
 						log.WithFields(logrus.Fields{"acr": acr, "id": accept.Id}).Debug("Authenticated")
 						request.Output = bulky.NewOkResponse(request.Index, accept)
-						idp.EmitEventIdentityAuthenticated(env.Nats, idp.Identity{Id: accept.Id}, acr)
+						idp.EmitEventIdentityAuthenticated(c.Request.Context(), &events, idp.Identity{Id: accept.Id}, acr)
+						metrics.ObserveAuthentication(acr, true)
 						continue
 					}
 
 					// Deny by default
 					log.WithFields(logrus.Fields{"acr": acr}).Debug("Authentication denied")
+					metrics.ObserveAuthentication(acr, false)
 					request.Output = bulky.NewOkResponse(request.Index, deny)
 					continue
 				}
@@ -507,17 +501,20 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 									}
 
 									if challenge != (idp.Challenge{}) {
+										idp.EmitEventChallengeCreated(c.Request.Context(), &events, challenge)
 
 										if otpCode.Code != "" && human.Email != "" {
 
+											emailTemplate := emailTemplates.Locale(append([]string{human.Locale}, uiLocales...)...)
+
 											var data = ConfirmTemplateData{
 												Challenge: challenge.Id,
-												Sender:    sender.Name,
+												Sender:    emailTemplate.Sender.Name,
 												Id:        challenge.Subject,
 												Email:     human.Email,
 												Code:      otpCode.Code, // Note this is the clear text generated code and not the hashed one stored in DB.
 											}
-											_, err = idp.SendEmailUsingTemplate(smtpConfig, human.Email, human.Email, emailSubject, templateFile, data)
+											_, err = idp.QueueEmailUsingTemplate(tx, challenge.Id, challenge.ExpiresAt, human.Email, human.Email, emailTemplate, data)
 											if err != nil {
 												e := tx.Rollback()
 												if e != nil {
@@ -564,6 +561,10 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 										return
 									}
 
+									if challenge != (idp.Challenge{}) {
+										idp.EmitEventChallengeCreated(c.Request.Context(), &events, challenge)
+									}
+
 									q = redirectToVerifyOtp.Query()
 									q.Add("otp_challenge", challenge.Id)
 									redirectToVerifyOtp.RawQuery = q.Encode()
@@ -601,7 +602,8 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 								accept.RedirectTo = hydraLoginAcceptResponse.RedirectTo
 
 								log.WithFields(logrus.Fields{"id": accept.Id, "acr": acr}).Debug("Authenticated")
-								idp.EmitEventIdentityAuthenticated(env.Nats, idp.Identity{Id: accept.Id}, acr)
+								idp.EmitEventIdentityAuthenticated(c.Request.Context(), &events, idp.Identity{Id: accept.Id}, acr)
+								metrics.ObserveAuthentication(acr, true)
 							}
 
 							request.Output = bulky.NewOkResponse(request.Index, accept)
@@ -610,20 +612,34 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 						} else {
 
 							deny.IsPasswordInvalid = true
+							idp.EmitEventHumanLoginFailed(c.Request.Context(), &events, human, idp.LOGIN_FAILED_PASSWORD_INVALID)
 
 						}
 
+					} else {
+						idp.EmitEventHumanLoginFailed(c.Request.Context(), &events, human, idp.LOGIN_FAILED_NOT_ALLOWED)
 					}
 
 				}
 
 				// Deny by default
 				log.WithFields(logrus.Fields{"id": r.Id}).Debug("Authentication denied")
+				metrics.ObserveAuthentication("", false)
 				request.Output = bulky.NewOkResponse(request.Index, deny)
 			}
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -633,6 +649,7 @@ func PostAuthenticate(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
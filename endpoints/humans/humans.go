@@ -12,6 +12,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	aap "github.com/opensentry/aap/client"
 
@@ -44,7 +45,7 @@ func GetHumans(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginReadTx(env.Driver)
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -107,6 +108,7 @@ func GetHumans(env *app.Environment) gin.HandlerFunc {
 							//Password:             i.Password,
 							Name:         i.Name,
 							Email:        i.Email,
+							Locale:       i.Locale,
 							AllowLogin:   i.AllowLogin,
 							TotpRequired: i.TotpRequired,
 							TotpSecret:   i.TotpSecret,
@@ -132,6 +134,7 @@ func GetHumans(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -154,7 +157,7 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -162,6 +165,7 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -232,6 +236,7 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 					Identity:         idp.Identity{Id: r.Id},
 					Username:         r.Username,
 					Name:             r.Name,
+					Locale:           r.Locale,
 					Password:         hashedPassword,
 					AllowLogin:       true,
 					EmailConfirmedAt: r.EmailConfirmedAt,
@@ -266,13 +271,14 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 						Name:             human.Name,
 						Email:            human.Email,
 						EmailConfirmedAt: human.EmailConfirmedAt,
+						Locale:           human.Locale,
 						AllowLogin:       human.AllowLogin,
 						TotpRequired:     human.TotpRequired,
 						TotpSecret:       human.TotpSecret,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
 					log.WithFields(logrus.Fields{"id": ok.Id}).Debug("Human created")
-					idp.EmitEventHumanCreated(env.Nats, human)
+					idp.EmitEventHumanCreated(c.Request.Context(), &events, human)
 					continue
 				}
 
@@ -297,6 +303,16 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 
 				var createGrantsRequests []aap.CreateGrantsRequest
@@ -324,15 +340,23 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 						"idp:update:humans:logout",
 						"idp:read:resourceservers",   // ?
 						"idp:create:resourceservers", // ?
+						"idp:update:resourceservers", // ?
 						"idp:delete:resourceservers", // ?
+						"idp:read:scopes",
+						"idp:create:scopes",
+						"idp:update:scopes",
+						"idp:delete:scopes",
 						"idp:create:clients",
 						"idp:read:clients",
+						"idp:update:clients",
+						"idp:update:clients:secret",
 						"idp:delete:clients",
 						"idp:read:identities",
 
 						// not sure this is ideal
 						"idp:create:roles",
 						"idp:read:roles",
+						"idp:update:roles",
 						"idp:delete:roles",
 					}
 
@@ -348,7 +372,7 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 				}
 
 				// Initialize in AAP model
-				aapClient := aap.NewAapClient(env.AapConfig)
+				aapClient := app.NewAapClient(c.Request.Context(), env.AapConfig)
 				url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.grants")
 				status, response, err := aap.CreateGrants(aapClient, url, createGrantsRequests)
 
@@ -366,6 +390,7 @@ func PostHumans(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -388,7 +413,7 @@ func PutHumans(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -396,6 +421,7 @@ func PutHumans(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -420,7 +446,8 @@ func PutHumans(env *app.Environment) gin.HandlerFunc {
 					Identity: idp.Identity{
 						Id: r.Id,
 					},
-					Name: r.Name,
+					Name:   r.Name,
+					Locale: r.Locale,
 				}
 				human, err := idp.UpdateHuman(tx, updateHuman)
 				if err != nil {
@@ -441,11 +468,18 @@ func PutHumans(env *app.Environment) gin.HandlerFunc {
 						//Password: human.Password,
 						Name:         human.Name,
 						Email:        human.Email,
+						Locale:       human.Locale,
 						AllowLogin:   human.AllowLogin,
 						TotpRequired: human.TotpRequired,
 						TotpSecret:   human.TotpSecret,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+
+					changed := []string{"name"}
+					if r.Locale != "" {
+						changed = append(changed, "locale")
+					}
+					idp.EmitEventHumanUpdated(c.Request.Context(), &events, human, changed)
 					continue
 				}
 
@@ -465,6 +499,16 @@ func PutHumans(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -474,6 +518,7 @@ func PutHumans(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -502,21 +547,11 @@ func DeleteHumans(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		var sender idp.SMTPSender = idp.SMTPSender{Name: config.GetString("provider.name"), Email: config.GetString("provider.email")}
-		var templateFile string = config.GetString("templates.delete.email.templatefile")
-		var emailSubject string = config.GetString("templates.delete.email.subject")
-
-		smtpConfig := idp.SMTPConfig{
-			Host:          config.GetString("mail.smtp.host"),
-			Username:      config.GetString("mail.smtp.user"),
-			Password:      config.GetString("mail.smtp.password"),
-			Sender:        sender,
-			SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
-		}
+		emailTemplates := env.EmailTemplates[idp.EMAIL_TEMPLATE_DELETE]
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -524,6 +559,7 @@ func DeleteHumans(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -605,17 +641,20 @@ func DeleteHumans(env *app.Environment) gin.HandlerFunc {
 					}
 
 					if challenge != (idp.Challenge{}) {
+						idp.EmitEventChallengeCreated(c.Request.Context(), &events, challenge)
 
 						if otpCode.Code != "" && human.Email != "" {
 
+							emailTemplate := emailTemplates.Locale(human.Locale)
+
 							var data = ConfirmTemplateData{
 								Challenge: challenge.Id,
-								Sender:    sender.Name,
+								Sender:    emailTemplate.Sender.Name,
 								Id:        challenge.Subject,
 								Email:     human.Email,
 								Code:      otpCode.Code, // Note this is the clear text generated code and not the hashed one stored in DB.
 							}
-							_, err = idp.SendEmailUsingTemplate(smtpConfig, human.Email, human.Email, emailSubject, templateFile, data)
+							_, err = idp.QueueEmailUsingTemplate(tx, challenge.Id, challenge.ExpiresAt, human.Email, human.Email, emailTemplate, data)
 							if err != nil {
 								e := tx.Rollback()
 								if e != nil {
@@ -655,6 +694,16 @@ func DeleteHumans(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -664,6 +713,7 @@ func DeleteHumans(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
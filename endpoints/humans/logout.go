@@ -1,6 +1,7 @@
 package humans
 
 import (
+	"context"
 	hydra "github.com/charmixer/hydra/client"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -11,6 +12,8 @@ import (
 	"github.com/opensentry/idp/client"
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -74,6 +77,7 @@ func PostLogout(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -94,7 +98,7 @@ func GetLogout(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		hydraClient := hydra.NewHydraClient(env.HydraConfig)
+		hydraClient := app.NewHydraClient(c.Request.Context(), env.HydraConfig)
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
@@ -137,6 +141,7 @@ func GetLogout(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -157,10 +162,12 @@ func PutLogout(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		hydraClient := hydra.NewHydraClient(env.HydraConfig)
+		hydraClient := app.NewHydraClient(c.Request.Context(), env.HydraConfig)
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
+			var events idp.EventBatch // Queued once all logouts are accepted
+
 			for _, request := range iRequests {
 				r := request.Input.(client.UpdateHumansLogoutAcceptRequest)
 
@@ -192,6 +199,7 @@ func PutLogout(env *app.Environment) gin.HandlerFunc {
 						Id:         hydraLogoutResponse.Subject,
 						RedirectTo: hydraLogoutAcceptResponse.RedirectTo,
 					})
+					idp.EmitEventIdentityLoggedOut(c.Request.Context(), &events, idp.Identity{Id: hydraLogoutResponse.Subject})
 
 				}
 				continue
@@ -200,6 +208,11 @@ func PutLogout(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				// Hydra already logged out the identities, so failing to queue the events must not fail the request.
+				err = queueEvents(c.Request.Context(), env, &events)
+				if err != nil {
+					log.Debug(err.Error())
+				}
 				return
 			}
 
@@ -207,7 +220,26 @@ func PutLogout(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
+
+// queueEvents puts events in the outbox for handlers without a transaction of their own.
+func queueEvents(ctx context.Context, env *app.Environment, events *idp.EventBatch) (err error) {
+	session, tx, err := idp.BeginWriteTx(ctx, env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	err = idp.QueueEvents(tx, events)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -12,6 +12,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -45,21 +46,11 @@ func PostRecover(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		var sender idp.SMTPSender = idp.SMTPSender{Name: config.GetString("provider.name"), Email: config.GetString("provider.email")}
-		var templateFile string = config.GetString("templates.recover.email.templatefile")
-		var emailSubject string = config.GetString("templates.recover.email.subject")
-
-		smtpConfig := idp.SMTPConfig{
-			Host:          config.GetString("mail.smtp.host"),
-			Username:      config.GetString("mail.smtp.user"),
-			Password:      config.GetString("mail.smtp.password"),
-			Sender:        sender,
-			SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
-		}
+		emailTemplates := env.EmailTemplates[idp.EMAIL_TEMPLATE_RECOVER]
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -67,6 +58,7 @@ func PostRecover(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -148,17 +140,20 @@ func PostRecover(env *app.Environment) gin.HandlerFunc {
 					}
 
 					if challenge != (idp.Challenge{}) {
+						idp.EmitEventChallengeCreated(c.Request.Context(), &events, challenge)
 
 						if otpCode.Code != "" && human.Email != "" {
 
+							emailTemplate := emailTemplates.Locale(human.Locale)
+
 							var data = ConfirmTemplateData{
 								Challenge: challenge.Id,
-								Sender:    sender.Name,
+								Sender:    emailTemplate.Sender.Name,
 								Id:        challenge.Subject,
 								Email:     human.Email,
 								Code:      otpCode.Code, // Note this is the clear text generated code and not the hashed one stored in DB.
 							}
-							_, err = idp.SendEmailUsingTemplate(smtpConfig, human.Email, human.Email, emailSubject, templateFile, data)
+							_, err = idp.QueueEmailUsingTemplate(tx, challenge.Id, challenge.ExpiresAt, human.Email, human.Email, emailTemplate, data)
 							if err != nil {
 								e := tx.Rollback()
 								if e != nil {
@@ -180,6 +175,7 @@ func PostRecover(env *app.Environment) gin.HandlerFunc {
 							Id:         human.Id,
 							RedirectTo: redirectToConfirm.String(),
 						})
+						idp.EmitEventHumanRecoverRequested(c.Request.Context(), &events, human)
 						continue
 					}
 
@@ -198,6 +194,16 @@ func PostRecover(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -207,6 +213,7 @@ func PostRecover(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
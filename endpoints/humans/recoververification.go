@@ -9,6 +9,7 @@ import (
 	"github.com/opensentry/idp/client"
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -30,7 +31,7 @@ func PutRecoverVerification(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -38,6 +39,7 @@ func PutRecoverVerification(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -123,6 +125,8 @@ func PutRecoverVerification(env *app.Environment) gin.HandlerFunc {
 							Verified:   true,
 							RedirectTo: challenge.RedirectTo,
 						})
+						idp.EmitEventHumanPasswordChanged(c.Request.Context(), &events, updatedHuman)
+						idp.EmitEventHumanRecoverCompleted(c.Request.Context(), &events, updatedHuman)
 						continue
 					}
 
@@ -139,6 +143,16 @@ func PutRecoverVerification(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -148,6 +162,7 @@ func PutRecoverVerification(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -12,6 +12,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -47,25 +48,11 @@ func PostInvitesClaim(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		var templateFile string
-		var emailSubject string
-		var sender idp.SMTPSender
-
-		sender = idp.SMTPSender{Name: config.GetString("provider.name"), Email: config.GetString("provider.email")}
-		templateFile = config.GetString("templates.emailconfirm.email.templatefile")
-		emailSubject = config.GetString("templates.emailconfirm.email.subject")
-
-		smtpConfig := idp.SMTPConfig{
-			Host:          config.GetString("mail.smtp.host"),
-			Username:      config.GetString("mail.smtp.user"),
-			Password:      config.GetString("mail.smtp.password"),
-			Sender:        sender,
-			SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
-		}
+		emailTemplates := env.EmailTemplates[idp.EMAIL_TEMPLATE_EMAILCONFIRM]
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -73,6 +60,7 @@ func PostInvitesClaim(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			// requestor := c.MustGet("sub").(string)
 			// var requestedBy *idp.Identity
@@ -154,17 +142,20 @@ func PostInvitesClaim(env *app.Environment) gin.HandlerFunc {
 				}
 
 				if challenge != (idp.Challenge{}) {
+					idp.EmitEventChallengeCreated(c.Request.Context(), &events, challenge)
 
 					if otpCode.Code != "" && invite.Email != "" {
 
+						emailTemplate := emailTemplates.Locale(invite.Locale)
+
 						var data = ConfirmTemplateData{
 							Challenge: challenge.Id,
-							Sender:    sender.Name,
+							Sender:    emailTemplate.Sender.Name,
 							Id:        challenge.Subject,
 							Email:     invite.Email,
 							Code:      otpCode.Code, // Note this is the clear text generated code and not the hashed one stored in DB.
 						}
-						_, err = idp.SendEmailUsingTemplate(smtpConfig, invite.Email, invite.Email, emailSubject, templateFile, data)
+						_, err = idp.QueueEmailUsingTemplate(tx, challenge.Id, challenge.ExpiresAt, invite.Email, invite.Email, emailTemplate, data)
 						if err != nil {
 							e := tx.Rollback()
 							if e != nil {
@@ -185,6 +176,7 @@ func PostInvitesClaim(env *app.Environment) gin.HandlerFunc {
 					request.Output = bulky.NewOkResponse(request.Index, client.CreateInvitesClaimResponse{
 						RedirectTo: redirectToConfirm.String(),
 					})
+					idp.EmitEventInviteClaimed(c.Request.Context(), &events, invite)
 					continue
 				}
 
@@ -201,6 +193,16 @@ func PostInvitesClaim(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -210,6 +212,7 @@ func PostInvitesClaim(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
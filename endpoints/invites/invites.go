@@ -11,6 +11,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	aap "github.com/opensentry/aap/client"
 
@@ -48,7 +49,7 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -56,6 +57,7 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -158,6 +160,7 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 					},
 					Email:    r.Email,
 					Username: r.Username,
+					Locale:   r.Locale,
 				}
 				log.Debug(newInvite)
 				invite, err := idp.CreateInvite(tx, requestedBy, newInvite)
@@ -181,9 +184,10 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 						ExpiresAt: invite.ExpiresAt,
 						Email:     invite.Email,
 						Username:  invite.Username,
+						Locale:    invite.Locale,
 						SentAt:    invite.SentAt,
 					})
-					idp.EmitEventInviteCreated(env.Nats, invite)
+					idp.EmitEventInviteCreated(c.Request.Context(), &events, invite)
 					continue
 				}
 
@@ -200,6 +204,16 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 
 				var createEntitiesRequests []aap.CreateEntitiesRequest
@@ -260,7 +274,7 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 				}
 
 				// Initialize in AAP model
-				aapClient := aap.NewAapClient(env.AapConfig)
+				aapClient := app.NewAapClient(c.Request.Context(), env.AapConfig)
 				url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.collection")
 				status, response, err := aap.CreateEntities(aapClient, url, createEntitiesRequests)
 
@@ -278,6 +292,7 @@ func PostInvites(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -300,7 +315,7 @@ func GetInvites(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginReadTx(env.Driver)
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -354,6 +369,22 @@ func GetInvites(env *app.Environment) gin.HandlerFunc {
 					return
 				}
 
+				var ids []string
+				for _, i := range dbInvites {
+					ids = append(ids, i.Id)
+				}
+				deliveries, err := idp.FetchEmailDeliveries(tx, ids)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
 				if len(dbInvites) > 0 {
 					for _, i := range dbInvites {
 						ok = append(ok, client.Invite{
@@ -363,6 +394,9 @@ func GetInvites(env *app.Environment) gin.HandlerFunc {
 							Email:     i.Email,
 							SentAt:    i.SentAt,
 							Username:  i.Username,
+							Locale:    i.Locale,
+
+							EmailDelivery: marshalEmailDelivery(deliveries[i.Id]),
 						})
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
@@ -385,7 +419,22 @@ func GetInvites(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
+
+// marshalEmailDelivery returns nil if no email was sent.
+func marshalEmailDelivery(m idp.Email) *client.EmailDelivery {
+	if m.Id == "" {
+		return nil
+	}
+	return &client.EmailDelivery{
+		Id:            m.Id,
+		Status:        m.Status,
+		Attempts:      m.Attempts,
+		NextAttemptAt: m.NextAttemptAt,
+		SentAt:        m.SentAt,
+	}
+}
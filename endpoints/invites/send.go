@@ -11,6 +11,7 @@ import (
 	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -37,21 +38,7 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		sender := idp.SMTPSender{
-			Name:  config.GetString("provider.name"),
-			Email: config.GetString("provider.email"),
-		}
-
-		smtpConfig := idp.SMTPConfig{
-			Host:          config.GetString("mail.smtp.host"),
-			Username:      config.GetString("mail.smtp.user"),
-			Password:      config.GetString("mail.smtp.password"),
-			Sender:        sender,
-			SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
-		}
-
-		emailTemplateFile := config.GetString("invite.template.email.file")
-		emailSubject := config.GetString("invite.template.email.subject")
+		emailTemplates := env.EmailTemplates[idp.EMAIL_TEMPLATE_INVITE]
 
 		epInviteUrl, err := url.Parse(config.GetString("invite.url"))
 		if err != nil {
@@ -62,7 +49,7 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -70,6 +57,7 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -117,7 +105,7 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 						IdentityProvider: config.GetString("provider.name"),
 					}
 
-					_, err = idp.SendEmailUsingTemplate(smtpConfig, invite.Email, invite.Email, emailSubject, emailTemplateFile, data)
+					queuedEmail, err := idp.QueueEmailUsingTemplate(tx, invite.Id, invite.ExpiresAt, invite.Email, invite.Email, emailTemplates.Locale(invite.Locale), data)
 					if err != nil {
 						e := tx.Rollback()
 						if e != nil {
@@ -125,7 +113,7 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 						}
 						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
 						request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
-						log.WithFields(logrus.Fields{"id": invite.Id, "file": emailTemplateFile}).Debug(err.Error())
+						log.WithFields(logrus.Fields{"id": invite.Id}).Debug(err.Error())
 						return
 					}
 
@@ -148,8 +136,12 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 							ExpiresAt: updatedInvite.ExpiresAt,
 							Email:     updatedInvite.Email,
 							Username:  updatedInvite.Username,
+							Locale:    updatedInvite.Locale,
+							SentAt:    updatedInvite.SentAt,
+
+							EmailDelivery: marshalEmailDelivery(queuedEmail),
 						})
-						idp.EmitEventInviteSent(env.Nats, idp.Invite{Identity: idp.Identity{Id: updatedInvite.Id}})
+						idp.EmitEventInviteSent(c.Request.Context(), &events, idp.Invite{Identity: idp.Identity{Id: updatedInvite.Id}})
 						continue
 					}
 				}
@@ -166,6 +158,16 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -175,6 +177,7 @@ func PostInvitesSend(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -7,9 +7,10 @@ import (
 
 	"github.com/opensentry/idp/app"
 	"github.com/opensentry/idp/client"
-	_ "github.com/opensentry/idp/client/errors"
+	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	aap "github.com/opensentry/aap/client"
 
@@ -32,7 +33,7 @@ func GetResourceServers(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginReadTx(env.Driver)
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -107,6 +108,7 @@ func GetResourceServers(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -129,7 +131,7 @@ func PostResourceServers(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -137,6 +139,7 @@ func PostResourceServers(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -188,7 +191,7 @@ func PostResourceServers(env *app.Environment) gin.HandlerFunc {
 						Audience:    r.Audience,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
-					idp.EmitEventResourceServerCreated(env.Nats, resourceServer)
+					idp.EmitEventResourceServerCreated(c.Request.Context(), &events, resourceServer)
 					continue
 				}
 
@@ -205,6 +208,16 @@ func PostResourceServers(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 
 				var createEntitiesRequests []aap.CreateEntitiesRequest
@@ -265,7 +278,7 @@ func PostResourceServers(env *app.Environment) gin.HandlerFunc {
 				}
 
 				// Initialize in AAP model
-				aapClient := aap.NewAapClient(env.AapConfig)
+				aapClient := app.NewAapClient(c.Request.Context(), env.AapConfig)
 				url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.collection")
 				status, response, err := aap.CreateEntities(aapClient, url, createEntitiesRequests)
 
@@ -283,6 +296,134 @@ func PostResourceServers(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PutResourceServers(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutResourceServers",
+		})
+
+		var requests []client.UpdateResourceServersRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := c.MustGet("sub").(string)
+			var requestedBy *idp.Identity
+			if requestor != "" {
+				identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: requestor}})
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				if len(identities) > 0 {
+					requestedBy = &identities[0]
+				}
+			}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateResourceServersRequest)
+
+				log = log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbResourceServers, err := idp.FetchResourceServers(tx, requestedBy, []idp.ResourceServer{{Identity: idp.Identity{Id: r.Id}}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbResourceServers) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.RESOURCESERVER_NOT_FOUND)
+					return
+				}
+
+				updateResourceServer := idp.ResourceServer{
+					Identity: idp.Identity{
+						Id: dbResourceServers[0].Id,
+					},
+					Name:        r.Name,
+					Description: r.Description,
+					Audience:    r.Audience,
+				}
+
+				resourceServer, err := idp.UpdateResourceServer(tx, requestedBy, updateResourceServer)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if resourceServer != (idp.ResourceServer{}) {
+					ok := client.UpdateResourceServersResponse{
+						Id:          resourceServer.Id,
+						Name:        resourceServer.Name,
+						Description: resourceServer.Description,
+						Audience:    resourceServer.Audience,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)
+				log.Debug("Update resource server failed. Hint: Maybe input validation needs to be improved.")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -305,7 +446,7 @@ func DeleteResourceServers(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -313,6 +454,7 @@ func DeleteResourceServers(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 			var requestedBy *idp.Identity
@@ -369,6 +511,7 @@ func DeleteResourceServers(env *app.Environment) gin.HandlerFunc {
 
 					ok := client.DeleteResourceServersResponse{Id: deletedResourceServer.Id}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+					idp.EmitEventResourceServerDeleted(c.Request.Context(), &events, resourceServerToDelete)
 					continue
 				}
 
@@ -385,6 +528,16 @@ func DeleteResourceServers(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -394,6 +547,7 @@ func DeleteResourceServers(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -7,9 +7,10 @@ import (
 
 	"github.com/opensentry/idp/app"
 	"github.com/opensentry/idp/client"
-	_ "github.com/opensentry/idp/client/errors"
+	E "github.com/opensentry/idp/client/errors"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
 
 	aap "github.com/opensentry/aap/client"
 
@@ -32,7 +33,7 @@ func GetRoles(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginReadTx(env.Driver)
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -93,6 +94,7 @@ func GetRoles(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -115,7 +117,7 @@ func PostRoles(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -123,6 +125,7 @@ func PostRoles(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 
@@ -160,7 +163,7 @@ func PostRoles(env *app.Environment) gin.HandlerFunc {
 						Description: dbRole.Description,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
-					//idp.EmitEventResourceServerCreated(env.Nats, resourceServer)
+					idp.EmitEventRoleCreated(c.Request.Context(), &events, dbRole)
 					continue
 				}
 
@@ -177,6 +180,16 @@ func PostRoles(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 
 				var createEntitiesRequests []aap.CreateEntitiesRequest
@@ -237,7 +250,7 @@ func PostRoles(env *app.Environment) gin.HandlerFunc {
 				}
 
 				// Initialize in AAP model
-				aapClient := aap.NewAapClient(env.AapConfig)
+				aapClient := app.NewAapClient(c.Request.Context(), env.AapConfig)
 				url := config.GetString("aap.public.url") + config.GetString("aap.public.endpoints.entities.collection")
 				status, response, err := aap.CreateEntities(aapClient, url, createEntitiesRequests)
 
@@ -255,6 +268,120 @@ func PostRoles(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PutRoles(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutRoles",
+		})
+
+		var requests []client.UpdateRolesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := c.MustGet("sub").(string)
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateRolesRequest)
+
+				log = log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbRoles, err := idp.FetchRoles(tx, []idp.Role{{Identity: idp.Identity{Id: r.Id}}}, idp.Identity{Id: requestor})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbRoles) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.ROLE_NOT_FOUND)
+					return
+				}
+
+				updateRole := idp.Role{
+					Identity: idp.Identity{
+						Id: dbRoles[0].Id,
+					},
+					Name:        r.Name,
+					Description: r.Description,
+				}
+
+				dbRole, err := idp.UpdateRole(tx, updateRole, idp.Identity{Id: requestor})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if dbRole != (idp.Role{}) {
+					ok := client.UpdateRolesResponse{
+						Id:          dbRole.Id,
+						Name:        dbRole.Name,
+						Description: dbRole.Description,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)
+				log.Debug("Update role failed. Hint: Maybe input validation needs to be improved.")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -277,7 +404,7 @@ func DeleteRoles(env *app.Environment) gin.HandlerFunc {
 
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := idp.BeginWriteTx(env.Driver)
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -285,6 +412,7 @@ func DeleteRoles(env *app.Environment) gin.HandlerFunc {
 			}
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
+			var events idp.EventBatch // Queued in the outbox as part of tx
 
 			requestor := c.MustGet("sub").(string)
 
@@ -324,6 +452,7 @@ func DeleteRoles(env *app.Environment) gin.HandlerFunc {
 
 					ok := client.DeleteRolesResponse{Id: dbDeletedRole.Id}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+					idp.EmitEventRoleDeleted(c.Request.Context(), &events, roleToDelete)
 					continue
 				}
 
@@ -340,6 +469,16 @@ func DeleteRoles(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = idp.QueueEvents(tx, &events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
 				tx.Commit()
 				return
 			}
@@ -349,6 +488,7 @@ func DeleteRoles(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -0,0 +1,467 @@
+package scopes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	E "github.com/opensentry/idp/client/errors"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+func GetScopes(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetScopes",
+		})
+
+		var requests []client.ReadScopesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+
+				var dbScopes []idp.Scope
+				var err error
+				var ok client.ReadScopesResponse
+
+				// Scopes are public knowledge, everyone needs them to know what to ask for. So no managedBy filtering here.
+				if request.Input == nil {
+					dbScopes, err = idp.FetchScopes(tx, nil, nil)
+				} else {
+					r := request.Input.(client.ReadScopesRequest)
+					dbScopes, err = idp.FetchScopes(tx, nil, []idp.Scope{{Id: r.Id, ResourceServerId: r.ResourceServerId}})
+				}
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbScopes) > 0 {
+					for _, d := range dbScopes {
+						ok = append(ok, client.Scope{
+							Id:               d.Id,
+							ResourceServerId: d.ResourceServerId,
+							Name:             d.Name,
+							Description:      d.Description,
+							ConsentText:      d.ConsentText,
+						})
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				request.Output = bulky.NewOkResponse(request.Index, []client.Scope{})
+				continue
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PostScopes(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostScopes",
+		})
+
+		var requests []client.CreateScopesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := c.MustGet("sub").(string)
+			var requestedBy *idp.Identity
+			if requestor != "" {
+				identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: requestor}})
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				if len(identities) > 0 {
+					requestedBy = &identities[0]
+				}
+			}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateScopesRequest)
+
+				log = log.WithFields(logrus.Fields{"resourceserver_id": r.ResourceServerId, "name": r.Name})
+
+				// Only the managers of a resource server may define its scopes
+				dbResourceServers, err := idp.FetchResourceServers(tx, requestedBy, []idp.ResourceServer{{Identity: idp.Identity{Id: r.ResourceServerId}}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbResourceServers) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.RESOURCESERVER_NOT_FOUND)
+					return
+				}
+
+				dbScopes, err := idp.FetchScopes(tx, nil, []idp.Scope{{ResourceServerId: r.ResourceServerId, Name: r.Name}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbScopes) > 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.SCOPE_EXISTS)
+					return
+				}
+
+				scope, err := idp.CreateScope(tx, requestedBy, idp.Scope{
+					ResourceServerId: r.ResourceServerId,
+					Name:             r.Name,
+					Description:      r.Description,
+					ConsentText:      r.ConsentText,
+				})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if scope.Id != "" {
+					ok := client.CreateScopesResponse{
+						Id:               scope.Id,
+						ResourceServerId: scope.ResourceServerId,
+						Name:             scope.Name,
+						Description:      scope.Description,
+						ConsentText:      scope.ConsentText,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)
+				log.Debug("Create scope failed. Hint: Maybe input validation needs to be improved.")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PutScopes(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutScopes",
+		})
+
+		var requests []client.UpdateScopesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := c.MustGet("sub").(string)
+			var requestedBy *idp.Identity
+			if requestor != "" {
+				identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: requestor}})
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				if len(identities) > 0 {
+					requestedBy = &identities[0]
+				}
+			}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateScopesRequest)
+
+				log = log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbScopes, err := idp.FetchScopes(tx, requestedBy, []idp.Scope{{Id: r.Id}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbScopes) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.SCOPE_NOT_FOUND)
+					return
+				}
+
+				updatedScope, err := idp.UpdateScope(tx, requestedBy, idp.Scope{
+					Id:          dbScopes[0].Id,
+					Description: r.Description,
+					ConsentText: r.ConsentText,
+				})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if updatedScope.Id != "" {
+					ok := client.UpdateScopesResponse{
+						Id:               updatedScope.Id,
+						ResourceServerId: updatedScope.ResourceServerId,
+						Name:             updatedScope.Name,
+						Description:      updatedScope.Description,
+						ConsentText:      updatedScope.ConsentText,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)
+				log.Debug("Update scope failed. Hint: Maybe input validation needs to be improved.")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func DeleteScopes(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "DeleteScopes",
+		})
+
+		var requests []client.DeleteScopesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := c.MustGet("sub").(string)
+			var requestedBy *idp.Identity
+			if requestor != "" {
+				identities, err := idp.FetchIdentities(tx, []idp.Identity{{Id: requestor}})
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				if len(identities) > 0 {
+					requestedBy = &identities[0]
+				}
+			}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.DeleteScopesRequest)
+
+				log = log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbScopes, err := idp.FetchScopes(tx, requestedBy, []idp.Scope{{Id: r.Id}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbScopes) <= 0 {
+					// not found translate into already deleted
+					ok := client.DeleteScopesResponse{Id: r.Id}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				deletedScope, err := idp.DeleteScope(tx, requestedBy, dbScopes[0])
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.DeleteScopesResponse{Id: deletedScope.Id}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
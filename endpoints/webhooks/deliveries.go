@@ -0,0 +1,200 @@
+package webhooks
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	E "github.com/opensentry/idp/client/errors"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+// Deliveries read per request, newest event first
+const DELIVERIES_LIMIT = 100
+
+func GetWebhooksDeliveries(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetWebhooksDeliveries",
+		})
+
+		var requests []client.ReadWebhooksDeliveriesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.ReadWebhooksDeliveriesRequest)
+
+				var ids []string
+				if r.Id != "" {
+					ids = []string{r.Id}
+				}
+
+				dbDeliveries, err := idp.FetchWebhookDeliveries(tx, ids, r.Webhook, r.Status, DELIVERIES_LIMIT)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.ReadWebhooksDeliveriesResponse{}
+				for _, d := range dbDeliveries {
+					ok = append(ok, marshalWebhookDelivery(d))
+				}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// PostWebhooksDeliveriesRedeliver delivers the event again, whether the delivery failed or not. It is retried like a new delivery.
+func PostWebhooksDeliveriesRedeliver(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostWebhooksDeliveriesRedeliver",
+		})
+
+		var requests []client.CreateWebhooksDeliveriesRedeliverRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateWebhooksDeliveriesRedeliverRequest)
+
+				log := log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbDeliveries, err := idp.FetchWebhookDeliveries(tx, []string{r.Id}, "", "", 1)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbDeliveries) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.WEBHOOK_DELIVERY_NOT_FOUND)
+					return
+				}
+
+				dbDelivery, err := idp.RedeliverWebhookDelivery(tx, dbDeliveries[0])
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+				dbDelivery.History = dbDeliveries[0].History
+
+				ok := client.CreateWebhooksDeliveriesRedeliverResponse(marshalWebhookDelivery(dbDelivery))
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+				log.Debug("Webhook delivery queued for redelivery")
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func marshalWebhookDelivery(d idp.WebhookDelivery) client.WebhookDelivery {
+	history := []client.WebhookDeliveryAttempt{}
+	for _, a := range d.History {
+		history = append(history, client.WebhookDeliveryAttempt{
+			Attempt:    a.Attempt,
+			Time:       a.Time,
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			Duration:   a.Duration,
+		})
+	}
+
+	return client.WebhookDelivery{
+		Id:            d.Id,
+		Webhook:       d.Webhook.Id,
+		Event:         d.Event.Id,
+		EventType:     d.Event.Type,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		NextAttemptAt: d.NextAttemptAt,
+		DeliveredAt:   d.DeliveredAt,
+		History:       history,
+	}
+}
@@ -0,0 +1,434 @@
+package webhooks
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/client"
+	E "github.com/opensentry/idp/client/errors"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+	sec "github.com/opensentry/idp/secret"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+func GetWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetWebhooks",
+		})
+
+		var requests []client.ReadWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginReadTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				var dbWebhooks []idp.Webhook
+				var err error
+				var ok client.ReadWebhooksResponse
+
+				if request.Input == nil {
+					dbWebhooks, err = idp.FetchWebhooks(tx, nil)
+				} else {
+					r := request.Input.(client.ReadWebhooksRequest)
+					dbWebhooks, err = idp.FetchWebhooks(tx, []idp.Webhook{{Id: r.Id}})
+				}
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbWebhooks) > 0 {
+					for _, d := range dbWebhooks {
+						ok = append(ok, marshalWebhook(d))
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				request.Output = bulky.NewOkResponse(request.Index, []client.Webhook{})
+				continue
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// PostWebhooks subscribes urls to events. The secret the deliveries are signed with is generated and only ever returned here.
+func PostWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostWebhooks",
+		})
+
+		var requests []client.CreateWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		keys := config.GetStringSlice("crypto.keys.webhooks")
+		if len(keys) <= 0 {
+			log.WithFields(logrus.Fields{"key": "crypto.keys.webhooks"}).Debug("Missing config")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		cryptoKey := keys[0]
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateWebhooksRequest)
+
+				if !webhookUrlAllowed(r.Url) {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.WEBHOOK_URL_NOT_ALLOWED)
+					return
+				}
+
+				secret, err := sec.CreateClientSecret(sec.RECOMMENDED_CLIENT_SECRET_ENTROPY_IN_BYTES)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to generate random secret")
+					return
+				}
+
+				encryptedSecret, err := idp.Encrypt(secret, cryptoKey) // Encrypt the secret before storage
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Failed to encrypt secret")
+					return
+				}
+
+				dbWebhook, err := idp.CreateWebhook(tx, idp.Webhook{
+					Url:         r.Url,
+					Secret:      encryptedSecret,
+					Events:      r.Events,
+					Description: r.Description,
+				})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if dbWebhook.Id != "" {
+					ok := client.CreateWebhooksResponse{
+						Webhook: marshalWebhook(dbWebhook),
+						Secret:  secret,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				// Deny by default
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+				request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+				log.WithFields(logrus.Fields{"url": r.Url}).Debug("Create webhook failed")
+				return
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PutWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutWebhooks",
+		})
+
+		var requests []client.UpdateWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateWebhooksRequest)
+
+				log := log.WithFields(logrus.Fields{"id": r.Id})
+
+				if r.Url != "" && !webhookUrlAllowed(r.Url) {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewBadRequestErrorResponse(request.Index, E.WEBHOOK_URL_NOT_ALLOWED)
+					return
+				}
+
+				dbWebhooks, err := idp.FetchWebhooks(tx, []idp.Webhook{{Id: r.Id}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbWebhooks) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.WEBHOOK_NOT_FOUND)
+					return
+				}
+
+				// Events absent from the request are left as is, an empty list subscribes to all events.
+				dbWebhook, err := idp.UpdateWebhook(tx, idp.Webhook{
+					Id:          dbWebhooks[0].Id,
+					Url:         r.Url,
+					Events:      r.Events,
+					Description: r.Description,
+				})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.UpdateWebhooksResponse(marshalWebhook(dbWebhook))
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// DeleteWebhooks removes webhooks along with their deliveries, so pending deliveries are never made.
+func DeleteWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "DeleteWebhooks",
+		})
+
+		var requests []client.DeleteWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := idp.BeginWriteTx(c.Request.Context(), env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.DeleteWebhooksRequest)
+
+				log := log.WithFields(logrus.Fields{"id": r.Id})
+
+				dbWebhooks, err := idp.FetchWebhooks(tx, []idp.Webhook{{Id: r.Id}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbWebhooks) <= 0 {
+					// not found translate into already deleted
+					ok := client.DeleteWebhooksResponse{Id: r.Id}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				dbDeletedWebhook, err := idp.DeleteWebhook(tx, dbWebhooks[0])
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.DeleteWebhooksResponse{Id: dbDeletedWebhook.Id}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		metrics.ObserveBulkyResponses(c.FullPath(), responses)
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// The secret is never part of a response but that of PostWebhooks.
+// Events are signed but not encrypted, so webhooks must use https unless config webhooks.require_https is false.
+func webhookUrlAllowed(webhookUrl string) bool {
+	u, err := url.Parse(webhookUrl)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	return u.Scheme == "http" && !config.GetBool("webhooks.require_https")
+}
+
+func marshalWebhook(w idp.Webhook) client.Webhook {
+	events := w.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	return client.Webhook{
+		Id:          w.Id,
+		Url:         w.Url,
+		Events:      events,
+		Description: w.Description,
+	}
+}
@@ -35,6 +35,8 @@ func CreateClient(tx neo4j.Transaction, managedBy *Identity, newClient Client) (
 	params["postLogoutRedirectUris"] = []string{}
 	params["audiences"] = []string{}
 	params["tokenEndpointAuthMethod"] = ""
+	params["jwks"] = newClient.Jwks
+	params["jwksUri"] = newClient.JwksUri
 
 	if len(newClient.GrantTypes) > 0 {
 		params["grantTypes"] = newClient.GrantTypes
@@ -60,6 +62,10 @@ func CreateClient(tx neo4j.Transaction, managedBy *Identity, newClient Client) (
 		params["client_secret"] = newClient.Secret
 		cypClientSecret = `secret:$client_secret,`
 	}
+	if newClient.RegistrationAccessToken != "" {
+		params["registration_access_token"] = newClient.RegistrationAccessToken
+		cypClientSecret = cypClientSecret + `registration_access_token:$registration_access_token,`
+	}
 
 	cypManages := ""
 	if managedBy != nil {
@@ -81,6 +87,8 @@ func CreateClient(tx neo4j.Transaction, managedBy *Identity, newClient Client) (
       redirect_uris:$redirectUris,
       post_logout_redirect_uris:$postLogoutRedirectUris,
       token_endpoint_auth_method:$tokenEndpointAuthMethod,
+      jwks:$jwks,
+      jwks_uri:$jwksUri,
       audiences:$audiences
     })
 
@@ -206,3 +214,316 @@ func DeleteClient(tx neo4j.Transaction, managedBy *Identity, clientToDelete Clie
 	client.Id = clientToDelete.Id
 	return client, nil
 }
+
+// NOTE: Only fields set on clientToUpdate are changed. Nil slices are left untouched, use an empty slice to clear them. The secret is never updated here.
+func UpdateClient(tx neo4j.Transaction, managedBy *Identity, clientToUpdate Client) (client Client, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if clientToUpdate.Id == "" {
+		return Client{}, errors.New("Missing Client.Id")
+	}
+	params["id"] = clientToUpdate.Id
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	var cypSet []string
+	if clientToUpdate.Name != "" {
+		params["name"] = clientToUpdate.Name
+		cypSet = append(cypSet, `c.name=$name`)
+	}
+	if clientToUpdate.Description != "" {
+		params["description"] = clientToUpdate.Description
+		cypSet = append(cypSet, `c.description=$description`)
+	}
+	if clientToUpdate.GrantTypes != nil {
+		params["grantTypes"] = clientToUpdate.GrantTypes
+		cypSet = append(cypSet, `c.grant_types=$grantTypes`)
+	}
+	if clientToUpdate.ResponseTypes != nil {
+		params["responseTypes"] = clientToUpdate.ResponseTypes
+		cypSet = append(cypSet, `c.response_types=$responseTypes`)
+	}
+	if clientToUpdate.RedirectUris != nil {
+		params["redirectUris"] = clientToUpdate.RedirectUris
+		cypSet = append(cypSet, `c.redirect_uris=$redirectUris`)
+	}
+	if clientToUpdate.PostLogoutRedirectUris != nil {
+		params["postLogoutRedirectUris"] = clientToUpdate.PostLogoutRedirectUris
+		cypSet = append(cypSet, `c.post_logout_redirect_uris=$postLogoutRedirectUris`)
+	}
+	if clientToUpdate.Audiences != nil {
+		params["audiences"] = clientToUpdate.Audiences
+		cypSet = append(cypSet, `c.audiences=$audiences`)
+	}
+	if clientToUpdate.TokenEndpointAuthMethod != "" {
+		params["tokenEndpointAuthMethod"] = clientToUpdate.TokenEndpointAuthMethod
+		cypSet = append(cypSet, `c.token_endpoint_auth_method=$tokenEndpointAuthMethod`)
+	}
+	// A client has either jwks or jwks_uri, so setting one removes the other.
+	if clientToUpdate.Jwks != "" {
+		params["jwks"] = clientToUpdate.Jwks
+		cypSet = append(cypSet, `c.jwks=$jwks`)
+		if clientToUpdate.JwksUri == "" {
+			cypSet = append(cypSet, `c.jwks_uri=""`)
+		}
+	}
+	if clientToUpdate.JwksUri != "" {
+		params["jwksUri"] = clientToUpdate.JwksUri
+		cypSet = append(cypSet, `c.jwks_uri=$jwksUri`)
+		if clientToUpdate.Jwks == "" {
+			cypSet = append(cypSet, `c.jwks=""`)
+		}
+	}
+
+	cypUpdate := ""
+	if len(cypSet) > 0 {
+		cypUpdate = `SET ` + strings.Join(cypSet, ", ")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH %s(c:Client:Identity {id:$id})
+    %s
+    RETURN c
+  `, cypManages, cypUpdate)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Client{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		clientNode := record.GetByIndex(0)
+
+		if clientNode != nil {
+			client = marshalNodeToClient(clientNode.(neo4j.Node))
+		}
+	} else {
+		return Client{}, errors.New("Unable to update Client")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// ReplaceClient sets all client metadata to the values of clientToReplace, empty values remove the metadata. Description, audiences and the secret are left untouched.
+func ReplaceClient(tx neo4j.Transaction, managedBy *Identity, clientToReplace Client) (client Client, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if clientToReplace.Id == "" {
+		return Client{}, errors.New("Missing Client.Id")
+	}
+	params["id"] = clientToReplace.Id
+
+	if clientToReplace.Name == "" {
+		return Client{}, errors.New("Missing Client.Name")
+	}
+	params["name"] = clientToReplace.Name
+
+	params["grantTypes"] = append([]string{}, clientToReplace.GrantTypes...)
+	params["responseTypes"] = append([]string{}, clientToReplace.ResponseTypes...)
+	params["redirectUris"] = append([]string{}, clientToReplace.RedirectUris...)
+	params["postLogoutRedirectUris"] = append([]string{}, clientToReplace.PostLogoutRedirectUris...)
+	params["tokenEndpointAuthMethod"] = clientToReplace.TokenEndpointAuthMethod
+	params["jwks"] = clientToReplace.Jwks
+	params["jwksUri"] = clientToReplace.JwksUri
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH %s(c:Client:Identity {id:$id})
+    SET c.name=$name,
+        c.grant_types=$grantTypes,
+        c.response_types=$responseTypes,
+        c.redirect_uris=$redirectUris,
+        c.post_logout_redirect_uris=$postLogoutRedirectUris,
+        c.token_endpoint_auth_method=$tokenEndpointAuthMethod,
+        c.jwks=$jwks,
+        c.jwks_uri=$jwksUri
+    RETURN c
+  `, cypManages)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Client{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		clientNode := record.GetByIndex(0)
+
+		if clientNode != nil {
+			client = marshalNodeToClient(clientNode.(neo4j.Node))
+		}
+	} else {
+		return Client{}, errors.New("Unable to replace Client")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// With newClient.SecondarySecretExpiresAt set, the secret active in Hydra is kept as secondary secret until then, see DeleteExpiredClientSecret.
+// Without, the new secret is pushed to Hydra right away and no secondary secret is kept.
+func RotateClientSecret(tx neo4j.Transaction, managedBy *Identity, newClient Client) (client Client, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if newClient.Id == "" {
+		return Client{}, errors.New("Missing Client.Id")
+	}
+	params["id"] = newClient.Id
+
+	if newClient.Secret == "" {
+		return Client{}, errors.New("Missing Client.Secret")
+	}
+	params["secret"] = newClient.Secret
+
+	// Rotating again within the grace period keeps the secret that is still active in Hydra, not the staged one
+	cypSecondary := `REMOVE c.secondary_secret, c.secondary_secret_exp`
+	if newClient.SecondarySecretExpiresAt > 0 {
+		cypSecondary = `SET c.secondary_secret=coalesce(c.secondary_secret, c.secret), c.secondary_secret_exp=$secondary_secret_exp`
+		params["secondary_secret_exp"] = newClient.SecondarySecretExpiresAt
+	}
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH %s(c:Client:Identity {id:$id})
+    %s
+    SET c.secret=$secret
+    RETURN c
+  `, cypManages, cypSecondary)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Client{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		clientNode := record.GetByIndex(0)
+
+		if clientNode != nil {
+			client = marshalNodeToClient(clientNode.(neo4j.Node))
+		}
+	} else {
+		return Client{}, errors.New("Unable to rotate Client secret")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// FetchExpiredClientSecrets returns the clients whose secondary secret expired before expiresBefore, see DeleteExpiredClientSecret.
+func FetchExpiredClientSecrets(tx neo4j.Transaction, expiresBefore int64) (clients []Client, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["exp"] = expiresBefore
+
+	cypher = fmt.Sprintf(`
+    MATCH (c:Client:Identity)
+    WHERE c.secondary_secret_exp > 0 AND c.secondary_secret_exp <= $exp
+    RETURN c
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		clientNode := record.GetByIndex(0)
+
+		if clientNode != nil {
+			client := marshalNodeToClient(clientNode.(neo4j.Node))
+			clients = append(clients, client)
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteExpiredClientSecret removes the secondary secret of the client if it expired before expiresBefore, and returns an empty Client if not.
+// The client stays locked until tx ends, so the secret can be pushed to Hydra without a rotation slipping in between.
+func DeleteExpiredClientSecret(tx neo4j.Transaction, clientToUpdate Client, expiresBefore int64) (client Client, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if clientToUpdate.Id == "" {
+		return Client{}, errors.New("Missing Client.Id")
+	}
+	params["id"] = clientToUpdate.Id
+	params["exp"] = expiresBefore
+
+	cypher = fmt.Sprintf(`
+    MATCH (c:Client:Identity {id:$id})
+    WHERE c.secondary_secret_exp > 0 AND c.secondary_secret_exp <= $exp
+    REMOVE c.secondary_secret, c.secondary_secret_exp
+    RETURN c
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Client{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		clientNode := record.GetByIndex(0)
+
+		if clientNode != nil {
+			client = marshalNodeToClient(clientNode.(neo4j.Node))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
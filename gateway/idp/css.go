@@ -0,0 +1,222 @@
+package idp
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Many mail clients drop <style> elements, so rules are copied into the style attribute of the elements they match.
+// Only simple selectors are inlined: tag, .class, #id and combinations like td.cell, optionally comma separated.
+// Rules with other selectors and @-rules like @media stay in <style> only, for the clients that do support it.
+
+type cssSelector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+type cssRule struct {
+	selector     cssSelector
+	declarations string
+	specificity  int
+	order        int
+}
+
+var cssComments = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// InlineCss returns the document with the rules of its <style> elements inlined. Declarations already in a style attribute win over the inlined ones.
+func InlineCss(document string) (string, error) {
+	if !strings.Contains(document, "<style") {
+		return document, nil
+	}
+
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	var rules []cssRule
+	walkHtml(root, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "style" && n.FirstChild != nil {
+			rules = append(rules, parseCss(n.FirstChild.Data, len(rules))...)
+		}
+	})
+
+	if len(rules) == 0 {
+		return document, nil
+	}
+
+	walkHtml(root, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.Data == "style" {
+			return
+		}
+
+		var matched []cssRule
+		for _, r := range rules {
+			if r.selector.matches(n) {
+				matched = append(matched, r)
+			}
+		}
+		if len(matched) == 0 {
+			return
+		}
+
+		sort.SliceStable(matched, func(i, j int) bool {
+			if matched[i].specificity != matched[j].specificity {
+				return matched[i].specificity < matched[j].specificity
+			}
+			return matched[i].order < matched[j].order
+		})
+
+		var declarations []string
+		for _, r := range matched {
+			declarations = append(declarations, r.declarations)
+		}
+		if style := strings.TrimSpace(htmlAttr(n, "style")); style != "" {
+			declarations = append(declarations, strings.TrimSuffix(style, ";"))
+		}
+		setHtmlAttr(n, "style", strings.Join(declarations, "; "))
+	})
+
+	var b bytes.Buffer
+	if err = html.Render(&b, root); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func parseCss(css string, order int) (rules []cssRule) {
+	css = cssComments.ReplaceAllString(css, "")
+
+	depth := 0
+	start := 0
+	for i, ch := range css {
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				block := css[start : i+1]
+				start = i + 1
+
+				open := strings.Index(block, "{")
+				selectors := strings.TrimSpace(block[:open])
+				if strings.HasPrefix(selectors, "@") {
+					continue // @media and friends can not be inlined
+				}
+
+				declarations := strings.TrimSuffix(strings.TrimSpace(block[open+1:len(block)-1]), ";")
+				if declarations == "" {
+					continue
+				}
+
+				for _, s := range strings.Split(selectors, ",") {
+					selector, specificity, ok := parseCssSelector(strings.TrimSpace(s))
+					if !ok {
+						continue
+					}
+					rules = append(rules, cssRule{selector: selector, declarations: declarations, specificity: specificity, order: order})
+					order++
+				}
+			}
+		}
+	}
+
+	return rules
+}
+
+func parseCssSelector(s string) (selector cssSelector, specificity int, ok bool) {
+	if s == "" || strings.ContainsAny(s, " >+~:[*") {
+		return cssSelector{}, 0, false
+	}
+
+	i := strings.IndexAny(s, ".#")
+	if i < 0 {
+		i = len(s)
+	}
+	selector.tag = strings.ToLower(s[:i])
+	if selector.tag != "" {
+		specificity += 1
+	}
+
+	for rest := s[i:]; rest != ""; {
+		kind := rest[0]
+		rest = rest[1:]
+		end := strings.IndexAny(rest, ".#")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = rest[end:]
+
+		if name == "" {
+			return cssSelector{}, 0, false
+		}
+
+		if kind == '#' {
+			selector.id = name
+			specificity += 100
+		} else {
+			selector.classes = append(selector.classes, name)
+			specificity += 10
+		}
+	}
+
+	return selector, specificity, true
+}
+
+func (s cssSelector) matches(n *html.Node) bool {
+	if s.tag != "" && s.tag != n.Data {
+		return false
+	}
+	if s.id != "" && s.id != htmlAttr(n, "id") {
+		return false
+	}
+	if len(s.classes) > 0 {
+		classes := strings.Fields(htmlAttr(n, "class"))
+		for _, c := range s.classes {
+			found := false
+			for _, nc := range classes {
+				if nc == c {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func walkHtml(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkHtml(c, fn)
+	}
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setHtmlAttr(n *html.Node, key string, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,117 @@
+package idp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+const (
+	DKIM_CANONICALIZATION_SIMPLE  = "simple"
+	DKIM_CANONICALIZATION_RELAXED = "relaxed"
+)
+
+// dkimSignedHeaders are signed. Return-Path is left out, as relays may rewrite it.
+var dkimSignedHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+// DkimSigner signs messages with DKIM (RFC 6376) using rsa-sha256, or ed25519-sha256 (RFC 8463) for ed25519 keys.
+// The public key must be published in DNS as TXT record <Selector>._domainkey.<Domain>.
+type DkimSigner struct {
+	Domain   string
+	Selector string
+
+	HeaderCanonicalization string // simple or relaxed
+	BodyCanonicalization   string // simple or relaxed
+
+	key crypto.Signer
+}
+
+// NewDkimSigner reads the PEM encoded private key from file. The key may be PKCS #1 or PKCS #8, RSA or ed25519.
+// Canonicalization is header/body like relaxed/relaxed, which is also used when empty.
+func NewDkimSigner(domain string, selector string, keyFile string, canonicalization string) (*DkimSigner, error) {
+	if domain == "" {
+		return nil, errors.New("Missing domain")
+	}
+
+	if selector == "" {
+		return nil, errors.New("Missing selector")
+	}
+
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseDkimKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DkimSigner{Domain: domain, Selector: selector, key: key}
+
+	if canonicalization == "" {
+		canonicalization = DKIM_CANONICALIZATION_RELAXED + "/" + DKIM_CANONICALIZATION_RELAXED
+	}
+	c := strings.SplitN(canonicalization, "/", 2)
+	s.HeaderCanonicalization = c[0]
+	s.BodyCanonicalization = DKIM_CANONICALIZATION_SIMPLE // Default of RFC 6376 when only the header is given
+	if len(c) > 1 {
+		s.BodyCanonicalization = c[1]
+	}
+	for _, v := range []string{s.HeaderCanonicalization, s.BodyCanonicalization} {
+		if v != DKIM_CANONICALIZATION_SIMPLE && v != DKIM_CANONICALIZATION_RELAXED {
+			return nil, errors.New("Unsupported canonicalization " + canonicalization)
+		}
+	}
+
+	return s, nil
+}
+
+func parseDkimKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM encoded key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, errors.New("Unsupported key type, must be RSA or ed25519")
+}
+
+// Sign returns the message with a DKIM-Signature header prepended. The message must use CRLF line endings.
+func (s *DkimSigner) Sign(message []byte) ([]byte, error) {
+	var b bytes.Buffer
+	err := dkim.Sign(&b, bytes.NewReader(message), &dkim.SignOptions{
+		Domain:                 s.Domain,
+		Selector:               s.Selector,
+		Signer:                 s.key,
+		HeaderCanonicalization: dkim.Canonicalization(s.HeaderCanonicalization),
+		BodyCanonicalization:   dkim.Canonicalization(s.BodyCanonicalization),
+		HeaderKeys:             dkimSignedHeaders, // Headers missing from the message are still listed, so they can not be added later on
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
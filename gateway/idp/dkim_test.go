@@ -0,0 +1,101 @@
+package idp
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+func newTestDkimSigner(t *testing.T, key crypto.Signer, canonicalization string) (*DkimSigner, string) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeTemplateFiles(t, map[string]string{
+		"dkim.pem": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	signer, err := NewDkimSigner("example.com", "idp", filepath.Join(dir, "dkim.pem"), canonicalization)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+	if k, ok := key.Public().(ed25519.PublicKey); ok {
+		record = "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k)
+	}
+	return signer, record
+}
+
+func verifyDkim(t *testing.T, message string, record string) error {
+	verifications, err := dkim.VerifyWithOptions(strings.NewReader(message), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "idp._domainkey.example.com" {
+				t.Errorf("Unexpected lookup of %s", domain)
+			}
+			return []string{record}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("Expected 1 signature, got %d", len(verifications))
+	}
+	return verifications[0].Err
+}
+
+func TestComposeEmailSignedWithDkim(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string]crypto.Signer{"rsa": rsaKey, "ed25519": edKey}
+	for name, key := range keys {
+		for _, c := range []string{"", "relaxed/simple", "simple/relaxed", "simple/simple"} {
+			signer, record := newTestDkimSigner(t, key, c)
+			sender := SMTPSender{Name: "IDP  Provider", Email: "noreply@example.com", Dkim: signer}
+
+			m, err := ComposeEmail(sender, "Jane", "jane@example.com", "Your code  ", "Code: 1234  \n\n\n", "<p>Code:   <b>1234</b></p>")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(m.Message, "DKIM-Signature: ") {
+				t.Fatalf("%s %s: Expected DKIM-Signature first, got %q", name, c, m.Message[:40])
+			}
+			if !strings.Contains(m.Message, "\r\nMessage-ID: <") || !strings.Contains(m.Message, "@example.com>\r\n") || !strings.Contains(m.Message, "\r\nDate: ") {
+				t.Errorf("%s %s: Expected Message-ID and Date headers in %q", name, c, m.Message)
+			}
+
+			if err := verifyDkim(t, m.Message, record); err != nil {
+				t.Errorf("%s %s: Expected valid signature, got %s", name, c, err)
+			}
+
+			tampered := strings.Replace(m.Message, "1234", "4321", 1)
+			if err := verifyDkim(t, tampered, record); err == nil {
+				t.Errorf("%s %s: Expected tampered body to fail verification", name, c)
+			}
+		}
+	}
+}
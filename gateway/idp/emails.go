@@ -0,0 +1,296 @@
+package idp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"strings"
+)
+
+const (
+	EMAIL_STATUS_PENDING = "pending"
+	EMAIL_STATUS_SENT    = "sent"
+	EMAIL_STATUS_DEAD    = "dead"
+)
+
+// Email is a mail in the outbox. Message holds the complete message until it is sent or dead lettered, after which it is removed as it may contain codes.
+type Email struct {
+	Id string
+
+	From    string
+	To      string
+	Message string
+
+	Status        string
+	Attempts      int64
+	NextAttemptAt int64
+	SentAt        int64
+
+	IssuedAt  int64
+	ExpiresAt int64 // Not delivered after this, as the challenge or invite it belongs to is no longer valid
+}
+
+func marshalNodeToEmail(node neo4j.Node) Email {
+	p := node.Props()
+
+	var message string
+	if p["message"] != nil {
+		message = p["message"].(string)
+	}
+
+	return Email{
+		Id: p["id"].(string),
+
+		From:    p["from"].(string),
+		To:      p["to"].(string),
+		Message: message,
+
+		Status:        p["status"].(string),
+		Attempts:      p["attempts"].(int64),
+		NextAttemptAt: p["next_attempt_at"].(int64),
+		SentAt:        p["sent_at"].(int64),
+
+		IssuedAt:  p["iat"].(int64),
+		ExpiresAt: p["exp"].(int64),
+	}
+}
+
+// QueueEmail puts the mail in the outbox, owned by the challenge or invite with id owner. It is delivered by jobs.DeliverEmails once tx commits.
+func QueueEmail(tx neo4j.Transaction, owner string, newEmail Email) (email Email, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if owner == "" {
+		return Email{}, errors.New("Missing owner")
+	}
+	params["owner"] = owner
+
+	if newEmail.From == "" {
+		return Email{}, errors.New("Missing Email.From")
+	}
+	params["from"] = newEmail.From
+
+	if newEmail.To == "" {
+		return Email{}, errors.New("Missing Email.To")
+	}
+	params["to"] = newEmail.To
+
+	if newEmail.ExpiresAt <= 0 {
+		return Email{}, errors.New("Missing Email.ExpiresAt")
+	}
+	params["exp"] = newEmail.ExpiresAt
+
+	params["status"] = EMAIL_STATUS_PENDING
+
+	cypher = fmt.Sprintf(`
+    MATCH (o) WHERE (o:Challenge OR o:Invite) AND o.id = $owner
+    CREATE (o)-[:EMAILED]->(m:Email {
+      id:randomUUID(), iat:datetime().epochSeconds, exp:$exp,
+      from:$from, to:$to, message:$message,
+      status:$status, attempts:0, next_attempt_at:datetime().epochSeconds, sent_at:0
+    })
+    RETURN m
+  `)
+
+	params["message"] = newEmail.Message
+	result, err = tx.Run(cypher, params)
+	delete(params, "message") // Never log codes
+	if err != nil {
+		return Email{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		emailNode := record.GetByIndex(0)
+
+		if emailNode != nil {
+			email = marshalNodeToEmail(emailNode.(neo4j.Node))
+		}
+	} else {
+		return Email{}, errors.New("Unable to queue Email")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Email{}, err
+	}
+
+	return email, nil
+}
+
+// ClaimPendingEmails returns up to limit mails due for delivery and postpones their next attempt until leaseUntil,
+// so other instances delivering from the same outbox do not pick them while this one is sending.
+func ClaimPendingEmails(tx neo4j.Transaction, now int64, leaseUntil int64, limit int64) (emails []Email, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["status"] = EMAIL_STATUS_PENDING
+	params["now"] = now
+	params["lease"] = leaseUntil
+	params["limit"] = limit
+
+	cypher = fmt.Sprintf(`
+    MATCH (m:Email {status:$status}) WHERE m.next_attempt_at <= $now
+    WITH m ORDER BY m.next_attempt_at LIMIT $limit
+    SET m.next_attempt_at = $lease
+    RETURN m
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		emailNode := record.GetByIndex(0)
+
+		if emailNode != nil {
+			emails = append(emails, marshalNodeToEmail(emailNode.(neo4j.Node)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// UpdateEmailDelivery records the outcome of an attempt. Status is EMAIL_STATUS_PENDING to retry at nextAttemptAt, EMAIL_STATUS_SENT or EMAIL_STATUS_DEAD.
+func UpdateEmailDelivery(tx neo4j.Transaction, emailToUpdate Email, status string, nextAttemptAt int64) (email Email, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if emailToUpdate.Id == "" {
+		return Email{}, errors.New("Missing Email.Id")
+	}
+	params["id"] = emailToUpdate.Id
+	params["status"] = status
+	params["next_attempt_at"] = nextAttemptAt
+
+	cypSent := ""
+	switch status {
+	case EMAIL_STATUS_PENDING:
+	case EMAIL_STATUS_SENT:
+		cypSent = `SET m.sent_at = datetime().epochSeconds REMOVE m.message`
+	case EMAIL_STATUS_DEAD:
+		cypSent = `REMOVE m.message`
+	default:
+		return Email{}, errors.New("Unsupported Email status")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH (m:Email {id:$id})
+    SET m.status = $status, m.attempts = m.attempts + 1, m.next_attempt_at = $next_attempt_at
+    %s
+    RETURN m
+  `, cypSent)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Email{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		emailNode := record.GetByIndex(0)
+
+		if emailNode != nil {
+			email = marshalNodeToEmail(emailNode.(neo4j.Node))
+		}
+	} else {
+		return Email{}, errors.New("Unable to update Email")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Email{}, err
+	}
+
+	return email, nil
+}
+
+// DeleteExpiredEmails removes sent and dead lettered mails which expired before expiresBefore. Pending mails are left for the delivery job to dead letter.
+func DeleteExpiredEmails(tx neo4j.Transaction, expiresBefore int64) (deleted int64, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["exp"] = expiresBefore
+	params["status"] = EMAIL_STATUS_PENDING
+
+	cypher = fmt.Sprintf(`
+    MATCH (m:Email) WHERE m.status <> $status AND m.exp < $exp
+    DETACH DELETE m
+    RETURN count(m)
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return 0, err
+	}
+
+	if result.Next() {
+		deleted = result.Record().GetByIndex(0).(int64)
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// FetchEmailDeliveries returns the latest mail sent for each of the challenges or invites, by their id.
+func FetchEmailDeliveries(tx neo4j.Transaction, owners []string) (emails map[string]Email, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	emails = make(map[string]Email)
+	if len(owners) == 0 {
+		return emails, nil
+	}
+	params["owners"] = strings.Join(owners, ",")
+
+	cypher = fmt.Sprintf(`
+    MATCH (o)-[:EMAILED]->(m:Email) WHERE (o:Challenge OR o:Invite) AND o.id in split($owners, ",")
+    WITH o, m ORDER BY m.iat DESC
+    WITH o, collect(m)[0] as m
+    RETURN o.id, m
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		owner := record.GetByIndex(0)
+		emailNode := record.GetByIndex(1)
+
+		if owner != nil && emailNode != nil {
+			emails[owner.(string)] = marshalNodeToEmail(emailNode.(neo4j.Node))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
@@ -1,46 +1,484 @@
 package idp
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
 	nats "github.com/nats-io/nats.go"
+
+	"github.com/opensentry/idp/metrics"
+	"github.com/opensentry/idp/tracing"
+)
+
+// Events are CloudEvents 1.0 in structured mode, see docs/EVENTS.md for the schema of each subject.
+// The version is part of the type, like idp.human.created.v1, and is bumped on changes to the data that are not backwards compatible.
+
+const (
+	EVENT_SPEC_VERSION      = "1.0"
+	EVENT_DATA_CONTENT_TYPE = "application/json"
+	EVENT_CONTENT_TYPE      = "application/cloudevents+json"
+
+	EVENT_HUMAN_CREATED          = "idp.human.created"
+	EVENT_HUMAN_PASSWORD_CHANGED = "idp.human.password.changed"
+	EVENT_HUMAN_EMAIL_CHANGED    = "idp.human.email.changed"
+	EVENT_IDENTITY_AUTHENTICATED = "idp.identity.authenticated"
+	EVENT_CLIENT_CREATED         = "idp.client.created"
+	EVENT_CLIENT_SECRET_ROTATED  = "idp.client.secret.rotated"
+	EVENT_RESOURCESERVER_CREATED = "idp.resourceserver.created"
+	EVENT_INVITE_CREATED         = "idp.invite.created"
+	EVENT_INVITE_SENT            = "idp.invite.sent"
+
+	EVENT_HUMAN_UPDATED           = "idp.human.updated"
+	EVENT_HUMAN_DELETED           = "idp.human.deleted"
+	EVENT_HUMAN_LOGIN_FAILED      = "idp.human.login.failed"
+	EVENT_HUMAN_TOTP_ENABLED      = "idp.human.totp.enabled"
+	EVENT_HUMAN_TOTP_DISABLED     = "idp.human.totp.disabled"
+	EVENT_HUMAN_EMAIL_CONFIRMED   = "idp.human.email.confirmed"
+	EVENT_HUMAN_RECOVER_REQUESTED = "idp.human.recover.requested"
+	EVENT_HUMAN_RECOVER_COMPLETED = "idp.human.recover.completed"
+	EVENT_IDENTITY_LOGGED_OUT     = "idp.identity.logout"
+	EVENT_CHALLENGE_CREATED       = "idp.challenge.created"
+	EVENT_CHALLENGE_VERIFIED      = "idp.challenge.verified"
+	EVENT_ROLE_CREATED            = "idp.role.created"
+	EVENT_ROLE_DELETED            = "idp.role.deleted"
+	EVENT_CLIENT_UPDATED          = "idp.client.updated"
+	EVENT_CLIENT_DELETED          = "idp.client.deleted"
+	EVENT_RESOURCESERVER_DELETED  = "idp.resourceserver.deleted"
+	EVENT_INVITE_CLAIMED          = "idp.invite.claimed"
+
+	// Reasons of idp.human.login.failed
+	LOGIN_FAILED_PASSWORD_INVALID = "password_invalid"
+	LOGIN_FAILED_NOT_ALLOWED      = "login_not_allowed"
+)
+
+// EventSource is the source of all events, set at startup to the issuer of the idp.
+var EventSource = "idp"
+
+// Event is the CloudEvents envelope, published on the NATS subject named like its type without the version.
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	Id              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	Subject         string      `json:"subject,omitempty"` // Id of the entity the event is about
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// EventData is part of the data of all events.
+type EventData struct {
+	Actor     string `json:"actor,omitempty"`      // The identity causing the event, empty if not authenticated
+	RequestId string `json:"request_id,omitempty"` // X-Request-Id of the request causing the event
+}
+
+type HumanCreatedEvent struct {
+	EventData
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// HumanChangedEvent is the data of events changing a human. Changed holds the names of the changed fields, never their values.
+type HumanChangedEvent struct {
+	EventData
+	Id      string   `json:"id"`
+	Changed []string `json:"changed"`
+}
+
+// HumanEvent is the data of events about a human that need nothing but its id.
+type HumanEvent struct {
+	EventData
+	Id string `json:"id"`
+}
+
+type HumanLoginFailedEvent struct {
+	EventData
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type IdentityAuthenticatedEvent struct {
+	EventData
+	Id  string `json:"id"`
+	Acr string `json:"acr"`
+}
+
+type ClientCreatedEvent struct {
+	EventData
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ClientUpdatedEvent struct {
+	EventData
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ClientDeletedEvent struct {
+	EventData
+	Id string `json:"id"`
+}
+
+type ClientSecretRotatedEvent struct {
+	EventData
+	Id                       string `json:"id"`
+	SecondarySecretExpiresAt int64  `json:"secondary_secret_exp"`
+}
+
+type ResourceServerCreatedEvent struct {
+	EventData
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Audience string `json:"aud"`
+}
+
+type ResourceServerDeletedEvent struct {
+	EventData
+	Id string `json:"id"`
+}
+
+type InviteCreatedEvent struct {
+	EventData
+	Id        string `json:"id"`
+	Email     string `json:"email"`
+	Username  string `json:"username,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type InviteSentEvent struct {
+	EventData
+	Id string `json:"id"`
+}
+
+type InviteClaimedEvent struct {
+	EventData
+	Id    string `json:"id"`
+	Email string `json:"email"`
+}
+
+type IdentityLoggedOutEvent struct {
+	EventData
+	Id string `json:"id"`
+}
+
+type ChallengeEvent struct {
+	EventData
+	Id            string `json:"id"`
+	Sub           string `json:"sub"`  // Id of the identity challenged
+	ChallengeType string `json:"type"` // One of authenticate, recover, delete, email_confirm or email_change
+}
+
+type RoleEvent struct {
+	EventData
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type eventContextKey int
+
+const (
+	eventActorKey eventContextKey = iota
+	eventRequestIdKey
 )
 
-func EmitEventHumanCreated(natsConnection *nats.Conn, human Human) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", human.Id)
-	natsConnection.Publish("idp.human.created", []byte(e))
+// WithEventActor returns ctx with the identity of the caller, which events emitted with the context are attributed to.
+func WithEventActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, eventActorKey, actor)
+}
+
+// WithEventRequestId returns ctx with the id of the request, which events emitted with the context refer to.
+func WithEventRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, eventRequestIdKey, requestId)
+}
+
+func eventDataFromContext(ctx context.Context) (d EventData) {
+	d.Actor, _ = ctx.Value(eventActorKey).(string)
+	d.RequestId, _ = ctx.Value(eventRequestIdKey).(string)
+	return d
+}
+
+// NewEvent wraps data in the envelope. The type is the subject with version appended.
+func NewEvent(subject string, version string, id string, data interface{}) (Event, error) {
+	eventId, err := uuid.NewV4()
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		SpecVersion:     EVENT_SPEC_VERSION,
+		Id:              eventId.String(),
+		Source:          EventSource,
+		Type:            subject + "." + version,
+		Time:            time.Now().UTC(),
+		Subject:         id,
+		DataContentType: EVENT_DATA_CONTENT_TYPE,
+		Data:            data,
+	}, nil
+}
+
+// EventBatch collects the events of a transaction. QueueEvents writes them to the outbox as part of the transaction,
+// so they are published if and only if the transaction commits. A batch is not safe for concurrent use.
+type EventBatch struct {
+	events []OutboxEvent
+}
+
+// add wraps data in the envelope and keeps the trace context of ctx, so the relay publishes the event as part of the trace of the request.
+func (b *EventBatch) add(ctx context.Context, subject string, version string, id string, data interface{}) {
+	e, err := NewEvent(subject, version, id, data)
+	if err != nil {
+		metrics.NatsPublishFailuresTotal.WithLabelValues(subject).Inc()
+		return
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		metrics.NatsPublishFailuresTotal.WithLabelValues(subject).Inc()
+		return
+	}
+
+	msg := &nats.Msg{}
+	tracing.InjectNats(ctx, msg)
+	trace := make(map[string]string)
+	for k := range msg.Header {
+		trace[k] = msg.Header.Get(k)
+	}
+
+	b.events = append(b.events, OutboxEvent{
+		Id:      e.Id,
+		Subject: subject,
+		Type:    e.Type,
+		Time:    e.Time.UnixNano(),
+		Body:    string(body),
+		Trace:   trace,
+	})
+}
+
+// Len is the number of events in the batch.
+func (b *EventBatch) Len() int {
+	return len(b.events)
+}
+
+func challengeEventType(ct ChallengeType) string {
+	switch ct {
+	case ChallengeAuthenticate:
+		return "authenticate"
+	case ChallengeRecover:
+		return "recover"
+	case ChallengeDelete:
+		return "delete"
+	case ChallengeEmailConfirm:
+		return "email_confirm"
+	case ChallengeEmailChange:
+		return "email_change"
+	}
+	return ""
+}
+
+func EmitEventHumanCreated(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_CREATED, "v1", human.Id, HumanCreatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+		Username:  human.Username,
+		Email:     human.Email,
+		Name:      human.Name,
+		Locale:    human.Locale,
+	})
+}
+
+func EmitEventHumanUpdated(ctx context.Context, events *EventBatch, human Human, changed []string) {
+	events.add(ctx, EVENT_HUMAN_UPDATED, "v1", human.Id, HumanChangedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+		Changed:   changed,
+	})
+}
+
+func EmitEventHumanDeleted(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_DELETED, "v1", human.Id, HumanEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+	})
+}
+
+func EmitEventHumanLoginFailed(ctx context.Context, events *EventBatch, human Human, reason string) {
+	events.add(ctx, EVENT_HUMAN_LOGIN_FAILED, "v1", human.Id, HumanLoginFailedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+		Reason:    reason,
+	})
+}
+
+func EmitEventHumanTotpChanged(ctx context.Context, events *EventBatch, human Human) {
+	subject := EVENT_HUMAN_TOTP_DISABLED
+	if human.TotpRequired == true {
+		subject = EVENT_HUMAN_TOTP_ENABLED
+	}
+	events.add(ctx, subject, "v1", human.Id, HumanEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+	})
+}
+
+func EmitEventHumanEmailConfirmed(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_EMAIL_CONFIRMED, "v1", human.Id, HumanEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+	})
+}
+
+func EmitEventHumanRecoverRequested(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_RECOVER_REQUESTED, "v1", human.Id, HumanEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+	})
+}
+
+func EmitEventHumanRecoverCompleted(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_RECOVER_COMPLETED, "v1", human.Id, HumanEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+	})
+}
+
+func EmitEventIdentityAuthenticated(ctx context.Context, events *EventBatch, i Identity, acr string) {
+	events.add(ctx, EVENT_IDENTITY_AUTHENTICATED, "v1", i.Id, IdentityAuthenticatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        i.Id,
+		Acr:       acr,
+	})
+}
+
+func EmitEventIdentityLoggedOut(ctx context.Context, events *EventBatch, i Identity) {
+	events.add(ctx, EVENT_IDENTITY_LOGGED_OUT, "v1", i.Id, IdentityLoggedOutEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        i.Id,
+	})
+}
+
+func EmitEventHumanPasswordChanged(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_PASSWORD_CHANGED, "v1", human.Id, HumanChangedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+		Changed:   []string{"password"},
+	})
+}
+
+func EmitEventHumanEmailChanged(ctx context.Context, events *EventBatch, human Human) {
+	events.add(ctx, EVENT_HUMAN_EMAIL_CHANGED, "v1", human.Id, HumanChangedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        human.Id,
+		Changed:   []string{"email"},
+	})
+}
+
+func EmitEventChallengeCreated(ctx context.Context, events *EventBatch, challenge Challenge) {
+	events.add(ctx, EVENT_CHALLENGE_CREATED, "v1", challenge.Id, ChallengeEvent{
+		EventData:     eventDataFromContext(ctx),
+		Id:            challenge.Id,
+		Sub:           challenge.Subject,
+		ChallengeType: challengeEventType(challenge.ChallengeType),
+	})
+}
+
+func EmitEventChallengeVerified(ctx context.Context, events *EventBatch, challenge Challenge) {
+	events.add(ctx, EVENT_CHALLENGE_VERIFIED, "v1", challenge.Id, ChallengeEvent{
+		EventData:     eventDataFromContext(ctx),
+		Id:            challenge.Id,
+		Sub:           challenge.Subject,
+		ChallengeType: challengeEventType(challenge.ChallengeType),
+	})
+}
+
+func EmitEventRoleCreated(ctx context.Context, events *EventBatch, role Role) {
+	events.add(ctx, EVENT_ROLE_CREATED, "v1", role.Id, RoleEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        role.Id,
+		Name:      role.Name,
+	})
+}
+
+func EmitEventRoleDeleted(ctx context.Context, events *EventBatch, role Role) {
+	events.add(ctx, EVENT_ROLE_DELETED, "v1", role.Id, RoleEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        role.Id,
+		Name:      role.Name,
+	})
+}
+
+func EmitEventClientCreated(ctx context.Context, events *EventBatch, client Client) {
+	events.add(ctx, EVENT_CLIENT_CREATED, "v1", client.Id, ClientCreatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        client.Id,
+		Name:      client.Name,
+	})
+}
+
+func EmitEventClientUpdated(ctx context.Context, events *EventBatch, client Client) {
+	events.add(ctx, EVENT_CLIENT_UPDATED, "v1", client.Id, ClientUpdatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        client.Id,
+		Name:      client.Name,
+	})
 }
 
-func EmitEventIdentityAuthenticated(natsConnection *nats.Conn, i Identity, acr string) {
-	e := fmt.Sprintf("{\"id\":\"%s\", \"acr\":\"%s\"}", i.Id, acr)
-	natsConnection.Publish("idp.identity.authenticated", []byte(e))
+func EmitEventClientDeleted(ctx context.Context, events *EventBatch, client Client) {
+	events.add(ctx, EVENT_CLIENT_DELETED, "v1", client.Id, ClientDeletedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        client.Id,
+	})
 }
 
-func EmitEventHumanPasswordChanged(natsConnection *nats.Conn, human Human) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", human.Id)
-	natsConnection.Publish("idp.human.password.changed", []byte(e))
+func EmitEventClientSecretRotated(ctx context.Context, events *EventBatch, client Client) {
+	events.add(ctx, EVENT_CLIENT_SECRET_ROTATED, "v1", client.Id, ClientSecretRotatedEvent{
+		EventData:                eventDataFromContext(ctx),
+		Id:                       client.Id,
+		SecondarySecretExpiresAt: client.SecondarySecretExpiresAt,
+	})
 }
 
-func EmitEventHumanEmailChanged(natsConnection *nats.Conn, human Human) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", human.Id)
-	natsConnection.Publish("idp.human.email.changed", []byte(e))
+func EmitEventResourceServerCreated(ctx context.Context, events *EventBatch, resourceServer ResourceServer) {
+	events.add(ctx, EVENT_RESOURCESERVER_CREATED, "v1", resourceServer.Id, ResourceServerCreatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        resourceServer.Id,
+		Name:      resourceServer.Name,
+		Audience:  resourceServer.Audience,
+	})
 }
 
-func EmitEventClientCreated(natsConnection *nats.Conn, client Client) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", client.Id)
-	natsConnection.Publish("idp.client.created", []byte(e))
+func EmitEventResourceServerDeleted(ctx context.Context, events *EventBatch, resourceServer ResourceServer) {
+	events.add(ctx, EVENT_RESOURCESERVER_DELETED, "v1", resourceServer.Id, ResourceServerDeletedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        resourceServer.Id,
+	})
 }
 
-func EmitEventResourceServerCreated(natsConnection *nats.Conn, resourceServer ResourceServer) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", resourceServer.Id)
-	natsConnection.Publish("idp.resourceserver.created", []byte(e))
+func EmitEventInviteCreated(ctx context.Context, events *EventBatch, invite Invite) {
+	events.add(ctx, EVENT_INVITE_CREATED, "v1", invite.Id, InviteCreatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        invite.Id,
+		Email:     invite.Email,
+		Username:  invite.Username,
+		ExpiresAt: invite.ExpiresAt,
+	})
 }
 
-func EmitEventInviteCreated(natsConnection *nats.Conn, invite Invite) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", invite.Id)
-	natsConnection.Publish("idp.invite.created", []byte(e))
+func EmitEventInviteSent(ctx context.Context, events *EventBatch, invite Invite) {
+	events.add(ctx, EVENT_INVITE_SENT, "v1", invite.Id, InviteSentEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        invite.Id,
+	})
 }
 
-func EmitEventInviteSent(natsConnection *nats.Conn, invite Invite) {
-	e := fmt.Sprintf("{\"id\":\"%s\"}", invite.Id)
-	natsConnection.Publish("idp.invite.sent", []byte(e))
+func EmitEventInviteClaimed(ctx context.Context, events *EventBatch, invite Invite) {
+	events.add(ctx, EVENT_INVITE_CLAIMED, "v1", invite.Id, InviteClaimedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        invite.Id,
+		Email:     invite.Email,
+	})
 }
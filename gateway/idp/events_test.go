@@ -0,0 +1,84 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestNewEventMarshalsCloudEvent(t *testing.T) {
+	ctx := WithEventRequestId(WithEventActor(context.Background(), "actor-id"), "request-id")
+
+	e, err := NewEvent(EVENT_HUMAN_CREATED, "v1", "human-id", HumanCreatedEvent{
+		EventData: eventDataFromContext(ctx),
+		Id:        "human-id",
+		Name:      `Jane "JD" Doe\`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Expected valid json, got %s: %s", err, b)
+	}
+
+	expected := map[string]interface{}{
+		"specversion":     "1.0",
+		"source":          EventSource,
+		"type":            "idp.human.created.v1",
+		"subject":         "human-id",
+		"datacontenttype": "application/json",
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+	if m["id"] == "" || m["time"] == "" {
+		t.Errorf("Expected id and time, got %s", b)
+	}
+
+	data := m["data"].(map[string]interface{})
+	if data["actor"] != "actor-id" || data["request_id"] != "request-id" {
+		t.Errorf("Expected actor and request_id from context, got %v", data)
+	}
+	if data["name"] != `Jane "JD" Doe\` {
+		t.Errorf("Expected name to survive escaping, got %v", data["name"])
+	}
+}
+
+func TestEventBatchSubjects(t *testing.T) {
+	var events EventBatch
+	EmitEventHumanTotpChanged(context.Background(), &events, Human{Identity: Identity{Id: "human-id"}, TotpRequired: true})
+	EmitEventHumanTotpChanged(context.Background(), &events, Human{Identity: Identity{Id: "human-id"}})
+	EmitEventChallengeVerified(context.Background(), &events, Challenge{Id: "challenge-id", ChallengeType: ChallengeRecover})
+
+	if events.Len() != 3 {
+		t.Fatalf("Expected 3 events, got %d", events.Len())
+	}
+
+	expected := []string{EVENT_HUMAN_TOTP_ENABLED, EVENT_HUMAN_TOTP_DISABLED, EVENT_CHALLENGE_VERIFIED}
+	for i, e := range events.events {
+		if e.Subject != expected[i] || e.Type != expected[i]+".v1" {
+			t.Errorf("Expected subject %s, got %s with type %s", expected[i], e.Subject, e.Type)
+		}
+	}
+
+	// The body is the envelope as published
+	var e Event
+	if err := json.Unmarshal([]byte(events.events[2].Body), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Id != events.events[2].Id || e.Time.UnixNano() != events.events[2].Time {
+		t.Errorf("Expected id and time of the envelope, got %s", events.events[2].Body)
+	}
+	if d := e.Data.(map[string]interface{}); d["type"] != "recover" {
+		t.Errorf("Expected challenge type recover, got %v", d["type"])
+	}
+}
@@ -39,9 +39,17 @@ func CreateHumanFromInvite(tx neo4j.Transaction, newHuman Human) (human Human, e
 	params["password"] = newHuman.Password
 	params["email_confirmed_at"] = newHuman.EmailConfirmedAt
 
+	// Keep the locale of the invite unless another is requested
+	cypLocale := ""
+	if newHuman.Locale != "" {
+		params["locale"] = newHuman.Locale
+		cypLocale = `i.locale=$locale,`
+	}
+
 	cypher = fmt.Sprintf(`
     MATCH (i:Invite:Identity {id:$id})
-      SET i.email_confirmed_at=$email_confirmed_at,
+      SET %s
+          i.email_confirmed_at=$email_confirmed_at,
           i.username=$username,
           i.name=$name,
           i.allow_login=$allow_login,
@@ -56,7 +64,7 @@ func CreateHumanFromInvite(tx neo4j.Transaction, newHuman Human) (human Human, e
     REMOVE i:Invite
 
     RETURN i
-  `)
+  `, cypLocale)
 
 	if result, err = tx.Run(cypher, params); err != nil {
 		return Human{}, err
@@ -115,6 +123,7 @@ func CreateHuman(tx neo4j.Transaction, newHuman Human) (human Human, err error)
 	params["name"] = newHuman.Name
 	params["allow_login"] = newHuman.AllowLogin
 	params["password"] = newHuman.Password
+	params["locale"] = newHuman.Locale
 
 	cypher = fmt.Sprintf(`
     CREATE (i:Human:Identity {
@@ -130,6 +139,8 @@ func CreateHuman(tx neo4j.Transaction, newHuman Human) (human Human, err error)
 
       name: $name,
 
+      locale: $locale,
+
       allow_login: $allow_login,
 
       password: $password,
@@ -279,11 +290,17 @@ func UpdateHuman(tx neo4j.Transaction, newHuman Human) (human Human, err error)
 	params["id"] = newHuman.Id
 	params["name"] = newHuman.Name
 
+	cypLocale := ""
+	if newHuman.Locale != "" {
+		params["locale"] = newHuman.Locale
+		cypLocale = `, i.locale=$locale`
+	}
+
 	cypher = fmt.Sprintf(`
     MATCH (i:Human:Identity {id:$id})
-    SET i.name=$name
+    SET i.name=$name %s
     RETURN i
-  `)
+  `, cypLocale)
 
 	if result, err = tx.Run(cypher, params); err != nil {
 		return Human{}, err
@@ -0,0 +1,225 @@
+package idp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	hydra "github.com/charmixer/hydra/client"
+)
+
+// HydraClient is the Hydra admin client model extended with the fields github.com/charmixer/hydra/client does not know about.
+// NOTE: Hydra replaces the whole client on update, so always read the client, change it and write it back to not lose fields.
+// The document read from Hydra is kept as is, so fields not modelled here (allowed_cors_origins, metadata, ...) survive the write back.
+type HydraClient struct {
+	hydra.Client
+	Jwks    json.RawMessage `json:"jwks,omitempty"`
+	JwksUri string          `json:"jwks_uri,omitempty"`
+
+	raw map[string]json.RawMessage
+}
+
+// The keys of the Hydra client document owned by the fields of HydraClient. These are always written from the fields, even when emptied.
+var hydraClientKeys = []string{
+	"client_id",
+	"client_name",
+	"client_secret",
+	"scope",
+	"grant_types",
+	"audience",
+	"response_types",
+	"redirect_uris",
+	"token_endpoint_auth_method",
+	"post_logout_redirect_uris",
+	"jwks",
+	"jwks_uri",
+}
+
+type hydraClientFields HydraClient // Without the json methods of HydraClient
+
+func (c *HydraClient) UnmarshalJSON(data []byte) error {
+	var fields hydraClientFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = HydraClient(fields)
+	c.raw = raw
+	return nil
+}
+
+func (c HydraClient) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(hydraClientFields(c))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.raw == nil {
+		return data, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	document := make(map[string]json.RawMessage)
+	for k, v := range c.raw {
+		document[k] = v
+	}
+	for _, k := range hydraClientKeys {
+		delete(document, k)
+	}
+	for k, v := range fields {
+		document[k] = v
+	}
+	return json.Marshal(document)
+}
+
+// CreatedAt is when hydra created the client, zero if hydra did not tell.
+func (c HydraClient) CreatedAt() time.Time {
+	return c.rawTime("created_at")
+}
+
+// UpdatedAt is when hydra last updated the client, zero if hydra did not tell.
+func (c HydraClient) UpdatedAt() time.Time {
+	return c.rawTime("updated_at")
+}
+
+func (c HydraClient) rawTime(key string) (t time.Time) {
+	if v, exists := c.raw[key]; exists {
+		json.Unmarshal(v, &t) // Left zero if not a time
+	}
+	return t
+}
+
+func CreateHydraClient(ctx context.Context, url string, newClient HydraClient) (client HydraClient, err error) {
+	err = callHydraAdmin(ctx, "POST", url, newClient, &client)
+	return client, err
+}
+
+func ReadHydraClient(ctx context.Context, url string, id string) (client HydraClient, err error) {
+	err = callHydraAdmin(ctx, "GET", url+"/"+id, nil, &client)
+	return client, err
+}
+
+func UpdateHydraClient(ctx context.Context, url string, id string, updateClient HydraClient) (client HydraClient, err error) {
+	err = callHydraAdmin(ctx, "PUT", url+"/"+id, updateClient, &client)
+	return client, err
+}
+
+func DeleteHydraClient(ctx context.Context, url string, id string) error {
+	return callHydraAdmin(ctx, "DELETE", url+"/"+id, nil, nil)
+}
+
+// Lists all clients registered in hydra, following the limit/offset paging of the admin api.
+func ListHydraClients(ctx context.Context, url string) (clients []HydraClient, err error) {
+	limit := 500
+	for offset := 0; ; offset += limit {
+		var page []HydraClient
+		err = callHydraAdmin(ctx, "GET", fmt.Sprintf("%s?limit=%d&offset=%d", url, limit, offset), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, page...)
+
+		if len(page) < limit {
+			return clients, nil
+		}
+	}
+}
+
+// HydraLoginRequest is the Hydra login request extended with the fields github.com/charmixer/hydra/client does not know about.
+type HydraLoginRequest struct {
+	hydra.LoginResponse
+	OidcContext HydraOidcContext `json:"oidc_context"`
+}
+
+type HydraOidcContext struct {
+	UiLocales []string `json:"ui_locales,omitempty"` // Preferred locales of the end-user, most preferred first
+}
+
+// ReadHydraLogin is hydra.GetLogin returning HydraLoginRequest.
+func ReadHydraLogin(ctx context.Context, url string, client *hydra.HydraClient, challenge string) (login HydraLoginRequest, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return HydraLoginRequest{}, err
+	}
+
+	q := req.URL.Query()
+	q.Add("login_challenge", challenge)
+	req.URL.RawQuery = q.Encode()
+
+	err = doHydraRequest(client.Client, req, &login)
+	return login, err
+}
+
+// IntrospectHydraToken is hydra.IntrospectToken made with ctx, so it is traced as part of the request.
+func IntrospectHydraToken(ctx context.Context, introspectUrl string, token string) (introspection hydra.IntrospectResponse, err error) {
+	values := url.Values{}
+	values.Add("token", token)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", introspectUrl, strings.NewReader(values.Encode()))
+	if err != nil {
+		return hydra.IntrospectResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = doHydraRequest(http.DefaultClient, req, &introspection)
+	return introspection, err
+}
+
+// callHydraAdmin makes the call as part of ctx, so it is traced as a child of the caller's span and carries its trace context to Hydra.
+func callHydraAdmin(ctx context.Context, method string, url string, request interface{}, response interface{}) error {
+	var body []byte
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doHydraRequest(http.DefaultClient, req, response)
+}
+
+func doHydraRequest(client *http.Client, req *http.Request, response interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resData, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("Hydra %s %s failed with status %d: %s", req.Method, req.URL, res.StatusCode, string(resData)))
+	}
+
+	if response == nil || len(resData) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resData, response)
+}
@@ -0,0 +1,62 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHydraClientKeepsUnknownFields(t *testing.T) {
+	read := `{"client_id":"id","client_name":"name","jwks_uri":"https://example.com/jwks","allowed_cors_origins":["https://example.com"],"metadata":{"a":1},"token_endpoint_auth_signing_alg":"RS256"}`
+
+	var h HydraClient
+	if err := json.Unmarshal([]byte(read), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Id != "id" || h.JwksUri != "https://example.com/jwks" {
+		t.Fatalf("Expected modelled fields to be read, got %+v", h)
+	}
+
+	h.Name = "changed"
+	h.JwksUri = ""
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written map[string]interface{}
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written["client_name"] != "changed" {
+		t.Errorf("Expected client_name to be changed, got %s", data)
+	}
+	if _, ok := written["jwks_uri"]; ok {
+		t.Errorf("Expected emptied jwks_uri to be removed, got %s", data)
+	}
+	for _, k := range []string{"allowed_cors_origins", "metadata", "token_endpoint_auth_signing_alg"} {
+		if _, ok := written[k]; !ok {
+			t.Errorf("Expected %s to be kept, got %s", k, data)
+		}
+	}
+}
+
+func TestIntrospectHydraToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.FormValue("token") != "token" {
+			t.Errorf("Expected the token to be posted as form, got %s %v", r.Method, r.Form)
+		}
+		w.Write([]byte(`{"active":true,"sub":"sub","client_id":"client","exp":10}`))
+	}))
+	defer srv.Close()
+
+	introspection, err := IntrospectHydraToken(context.Background(), srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !introspection.Active || introspection.Sub != "sub" || introspection.ClientId != "client" || introspection.Exp != 10 {
+		t.Errorf("Expected the introspection to be read, got %+v", introspection)
+	}
+}
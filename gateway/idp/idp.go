@@ -148,6 +148,19 @@ func Decrypt(str string, key string) (string, error) {
 	return string(bDecryptedStr), nil
 }
 
+// DecryptWithKeys decrypts with the first of keys that works. Values are encrypted with the first key, the others are
+// previous keys kept while rotating, so values encrypted before the rotation can still be read.
+func DecryptWithKeys(str string, keys []string) (decrypted string, err error) {
+	err = errors.New("No keys")
+	for _, key := range keys {
+		decrypted, err = Decrypt(str, key)
+		if err == nil {
+			return decrypted, nil
+		}
+	}
+	return "", err
+}
+
 // The key argument should be 32 bytes to use AES-256
 func encrypt(plaintext []byte, key []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
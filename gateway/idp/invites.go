@@ -74,6 +74,12 @@ func CreateInvite(tx neo4j.Transaction, invitedBy *Identity, newInvite Invite) (
 		cypUsername = `, username:$username`
 	}
 
+	cypLocale := ""
+	if newInvite.Locale != "" {
+		params["locale"] = newInvite.Locale
+		cypLocale = `, locale:$locale`
+	}
+
 	params["exp"] = newInvite.ExpiresAt
 
 	cypInvites := ""
@@ -83,7 +89,7 @@ func CreateInvite(tx neo4j.Transaction, invitedBy *Identity, newInvite Invite) (
 	}
 
 	cypher = fmt.Sprintf(`
-    CREATE (inv:Invite:Identity {id:randomUUID(), email:$email, iat:datetime().epochSeconds, iss:$iss, exp:$exp, sent_at:0, email_confirmed_at:0 %s})
+    CREATE (inv:Invite:Identity {id:randomUUID(), email:$email, iat:datetime().epochSeconds, iss:$iss, exp:$exp, sent_at:0, email_confirmed_at:0 %s%s})
 
     WITH inv
 
@@ -94,7 +100,7 @@ func CreateInvite(tx neo4j.Transaction, invitedBy *Identity, newInvite Invite) (
     OPTIONAL MATCH (d:Invite:Identity) WHERE id(inv) <> id(d) AND d.exp < datetime().epochSeconds DETACH DELETE d
 
     RETURN inv
-  `, cypUsername, cypInvites)
+  `, cypUsername, cypLocale, cypInvites)
 
 	if result, err = tx.Run(cypher, params); err != nil {
 		return Invite{}, err
@@ -2,144 +2,190 @@ package idp
 
 import (
 	"bytes"
-	"crypto/tls"
-	"encoding/base64"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"net"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
-	"net/smtp"
-	"text/template"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/opensentry/idp/metrics"
+	"github.com/opensentry/idp/tracing"
 )
 
 type SMTPSender struct {
 	Name       string
 	Email      string
 	ReturnPath string
+	Dkim       *DkimSigner // Optional
 }
 
+// SMTPConfig is the relay used by SMTPMailer.
 type SMTPConfig struct {
 	Host          string
 	Username      string
 	Password      string
-	Sender        SMTPSender
 	SkipTlsVerify int
 }
 
-type unencryptedAuth struct {
-	smtp.Auth
-}
+func SendEmailUsingTemplate(ctx context.Context, mailer Mailer, name string, email string, tpl *EmailTemplate, data interface{}) (bool, error) {
+	m, err := ComposeEmailUsingTemplate(name, email, tpl, data)
+	if err != nil {
+		return false, err
+	}
 
-func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
-	s := *server
-	s.TLS = true
-	return a.Auth.Start(&s)
+	err = DeliverEmail(ctx, mailer, m)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func SendEmailUsingTemplate(smtpConfig SMTPConfig, name string, email string, subject string, templateFile string, data interface{}) (bool, error) {
-	tplRecover, err := ioutil.ReadFile(templateFile)
+func SendEmail(ctx context.Context, mailer Mailer, sender SMTPSender, name string, email string, subject string, text string, html string) (bool, error) {
+	m, err := ComposeEmail(sender, name, email, subject, text, html)
 	if err != nil {
 		return false, err
 	}
 
-	t := template.Must(template.New(templateFile).Parse(string(tplRecover)))
-
-	var tpl bytes.Buffer
-	if err := t.Execute(&tpl, data); err != nil {
+	err = DeliverEmail(ctx, mailer, m)
+	if err != nil {
 		return false, err
 	}
-
-	return SendEmail(smtpConfig, name, email, subject, tpl.String())
+	return true, nil
 }
 
-func SendEmail(smtpConfig SMTPConfig, name string, email string, subject string, body string) (bool, error) {
-
-	from := mail.Address{smtpConfig.Sender.Name, smtpConfig.Sender.Email}
-	to := mail.Address{name, email}
+// QueueEmailUsingTemplate renders the template and puts the mail in the outbox as part of tx, so it is only sent if tx commits. See QueueEmail.
+func QueueEmailUsingTemplate(tx neo4j.Transaction, owner string, expiresAt int64, name string, email string, tpl *EmailTemplate, data interface{}) (Email, error) {
+	m, err := ComposeEmailUsingTemplate(name, email, tpl, data)
+	if err != nil {
+		return Email{}, err
+	}
 
-	header := make(map[string]string)
-	header["Return-Path"] = smtpConfig.Sender.ReturnPath
-	header["From"] = from.String()
-	header["To"] = to.String()
-	header["Subject"] = subject
-	header["MIME-Version"] = "1.0"
-	header["Content-Type"] = "text/plain; charset=\"utf-8\""
-	header["Content-Transfer-Encoding"] = "base64"
+	m.ExpiresAt = expiresAt
+	return QueueEmail(tx, owner, m)
+}
 
-	message := ""
-	for k, v := range header {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
+func ComposeEmailUsingTemplate(name string, email string, tpl *EmailTemplate, data interface{}) (Email, error) {
+	text, html, err := tpl.Render(data)
+	if err != nil {
+		return Email{}, err
 	}
-	message += "\r\n" + base64.StdEncoding.EncodeToString([]byte(body))
 
-	host, _, _ := net.SplitHostPort(smtpConfig.Host)
+	return ComposeEmail(tpl.Sender, name, email, tpl.Subject, text, html)
+}
 
-	// Trick go library into thinking we are encrypting password to allow SMTP with authentication but no encryption
-	//auth := unencryptedAuth { smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, host) }
-	// auth := smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, host)
+// ComposeEmail builds the message with headers, ready to be handed to a Mailer. With html it is a multipart/alternative message with both parts, otherwise plain text only.
+func ComposeEmail(sender SMTPSender, name string, email string, subject string, text string, html string) (Email, error) {
+	from := mail.Address{Name: sender.Name, Address: sender.Email}
+	to := mail.Address{Name: name, Address: email}
 
-	/*err := smtp.SendMail(smtpConfig.Host, auth, smtpConfig.Sender.Email, []string{identity.Email}, []byte(message))
-	  if err != nil {
-	    return false, err
-	  }
-	  return true, nil*/
+	var b bytes.Buffer
 
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: smtpConfig.SkipTlsVerify == 1, // Using selfsigned certs
-		ServerName:         host,
+	messageId, err := newMessageId(sender.Email)
+	if err != nil {
+		return Email{}, err
 	}
 
-	// Here is the key, you need to call tls.Dial instead of smtp.Dial
-	// for smtp servers running on 465 that require an ssl connection
-	// from the very beginning (no starttls)
-	/*conn, err := tls.Dial("tcp", smtpConfig.Host, tlsconfig)
-	  if err != nil {
-	    return false, err
-	  }
+	header := [][2]string{
+		{"Return-Path", sender.ReturnPath},
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageId},
+		{"MIME-Version", "1.0"},
+	}
+	for _, h := range header {
+		if h[1] != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+		}
+	}
 
-	  c, err := smtp.NewClient(conn, host)
-	  if err != nil {
-	    return false, err
-	  }
-	*/
+	if html == "" {
+		b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&b, text); err != nil {
+			return Email{}, err
+		}
+		return signEmail(sender, Email{From: from.Address, To: to.Address, Message: b.String()})
+	}
 
-	c, err := smtp.Dial(smtpConfig.Host)
-	if err != nil {
-		return false, err
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=\"%s\"\r\n\r\n", w.Boundary())
+
+	// Clients show the last part they understand, so html goes last.
+	parts := [][2]string{{"text/plain", text}, {"text/html", html}}
+	for _, part := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part[0] + "; charset=\"utf-8\""},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return Email{}, err
+		}
+		if err = writeQuotedPrintable(pw, part[1]); err != nil {
+			return Email{}, err
+		}
 	}
 
-	err = c.StartTLS(tlsconfig)
+	if err := w.Close(); err != nil {
+		return Email{}, err
+	}
+	b.Write(body.Bytes())
 
-	// Auth
-	// if err := c.Auth(auth); err != nil {
-	//   return false, err
-	// }
+	return signEmail(sender, Email{From: from.Address, To: to.Address, Message: b.String()})
+}
 
-	// To && From
-	if err = c.Mail(from.Address); err != nil {
-		return false, err
+// Signing on compose means the outbox holds the signed message, so retries send the same Message-ID and signature.
+func signEmail(sender SMTPSender, m Email) (Email, error) {
+	if sender.Dkim == nil {
+		return m, nil
 	}
 
-	if err = c.Rcpt(to.Address); err != nil {
-		return false, err
+	signed, err := sender.Dkim.Sign([]byte(m.Message))
+	if err != nil {
+		return Email{}, err
 	}
+	m.Message = string(signed)
+	return m, nil
+}
 
-	// Data
-	w, err := c.Data()
-	if err != nil {
-		return false, err
+func newMessageId(email string) (string, error) {
+	domain := "localhost"
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		domain = email[i+1:]
 	}
 
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		return false, err
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
 	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain), nil
+}
 
-	err = w.Close()
-	if err != nil {
-		return false, err
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(s)); err != nil {
+		return err
 	}
+	return qw.Close()
+}
 
-	c.Quit()
-	return true, nil
+func DeliverEmail(ctx context.Context, mailer Mailer, m Email) (err error) {
+	ctx, span := tracing.Start(ctx, "smtp.send")
+	defer func() {
+		metrics.ObserveEmail(err)
+		tracing.End(span, err)
+	}()
+
+	return mailer.Send(ctx, m.From, []string{m.To}, []byte(m.Message))
 }
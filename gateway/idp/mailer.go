@@ -0,0 +1,257 @@
+package idp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SMTP_TLS_STARTTLS = "starttls"
+	SMTP_TLS_IMPLICIT = "tls"
+	SMTP_TLS_NONE     = "none"
+
+	SMTP_AUTH_PLAIN   = "plain"
+	SMTP_AUTH_LOGIN   = "login"
+	SMTP_AUTH_CRAMMD5 = "cram-md5"
+)
+
+// Mailer delivers a complete RFC 5322 message, headers included, from the envelope sender to the envelope recipients.
+type Mailer interface {
+	Send(ctx context.Context, from string, to []string, message []byte) error
+}
+
+// SMTPMailer delivers to an SMTP relay. Tls is one of SMTP_TLS_*, empty means implicit TLS on port 465 and STARTTLS on any other.
+// Auth is one of SMTP_AUTH_*, empty means PLAIN when a username is configured.
+type SMTPMailer struct {
+	Config SMTPConfig
+	Tls    string
+	Auth   string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, from string, to []string, message []byte) error {
+	c, host, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	auth, err := m.auth(host)
+	if err != nil {
+		return err
+	}
+	if auth != nil {
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Ping connects to the relay as Send would, without sending anything.
+func (m *SMTPMailer) Ping(ctx context.Context) error {
+	c, _, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Quit()
+}
+
+// dial connects and secures the connection according to the tls mode.
+func (m *SMTPMailer) dial(ctx context.Context) (*smtp.Client, string, error) {
+	host, port, err := net.SplitHostPort(m.Config.Host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mode := m.Tls
+	if mode == "" {
+		mode = SMTP_TLS_STARTTLS
+		if port == "465" {
+			mode = SMTP_TLS_IMPLICIT
+		}
+	}
+
+	tlsconfig := &tls.Config{
+		InsecureSkipVerify: m.Config.SkipTlsVerify == 1, // Using selfsigned certs
+		ServerName:         host,
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Config.Host)
+	if err != nil {
+		return nil, "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	switch mode {
+	case SMTP_TLS_IMPLICIT:
+		conn = tls.Client(conn, tlsconfig)
+	case SMTP_TLS_STARTTLS, SMTP_TLS_NONE:
+	default:
+		conn.Close()
+		return nil, "", fmt.Errorf("Unknown smtp tls mode %q", mode)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	if mode == SMTP_TLS_STARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, "", errors.New("SMTP server does not support STARTTLS. Hint: Set tls mode none to send unencrypted")
+		}
+		if err = c.StartTLS(tlsconfig); err != nil {
+			c.Close()
+			return nil, "", err
+		}
+	}
+
+	return c, host, nil
+}
+
+func (m *SMTPMailer) auth(host string) (smtp.Auth, error) {
+	if m.Config.Username == "" {
+		return nil, nil
+	}
+
+	switch m.Auth {
+	case "", SMTP_AUTH_PLAIN:
+		// Refuses to send the password over an unencrypted connection, except to localhost.
+		return smtp.PlainAuth("", m.Config.Username, m.Config.Password, host), nil
+	case SMTP_AUTH_LOGIN:
+		return &loginAuth{username: m.Config.Username, password: m.Config.Password, host: host}, nil
+	case SMTP_AUTH_CRAMMD5:
+		return smtp.CRAMMD5Auth(m.Config.Username, m.Config.Password), nil
+	}
+
+	return nil, fmt.Errorf("Unknown smtp auth mechanism %q", m.Auth)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp does not. Like PLAIN it sends the password in clear text, so it is only allowed over TLS or to localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("Unexpected LOGIN challenge %q", fromServer)
+}
+
+// MaildirMailer writes every message to the new directory of a maildir instead of sending it, for development. Any mail client that reads maildirs can show them.
+type MaildirMailer struct {
+	Path string
+}
+
+var maildirSequence uint64
+
+func (m *MaildirMailer) Send(ctx context.Context, from string, to []string, message []byte) error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Path, dir), 0700); err != nil {
+			return err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.P%dQ%d.%s", time.Now().UnixNano(), os.Getpid(), atomic.AddUint64(&maildirSequence, 1), strings.ReplaceAll(hostname, "/", "_"))
+
+	// Delivered to tmp first, so readers never see a partially written message in new.
+	tmp := filepath.Join(m.Path, "tmp", name)
+	if err := ioutil.WriteFile(tmp, message, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Path, "new", name))
+}
+
+type CapturedMessage struct {
+	From    string
+	To      []string
+	Message []byte
+}
+
+// CaptureMailer keeps every message in memory, for tests.
+type CaptureMailer struct {
+	lock     sync.Mutex
+	messages []CapturedMessage
+}
+
+func (m *CaptureMailer) Send(ctx context.Context, from string, to []string, message []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = append(m.messages, CapturedMessage{From: from, To: append([]string(nil), to...), Message: append([]byte(nil), message...)})
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *CaptureMailer) Messages() []CapturedMessage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]CapturedMessage(nil), m.messages...)
+}
+
+func (m *CaptureMailer) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = nil
+}
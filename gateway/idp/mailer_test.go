@@ -0,0 +1,252 @@
+package idp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTemplateFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "idp-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSendEmailUsingTemplateCaptured(t *testing.T) {
+	dir := writeTemplateFiles(t, map[string]string{
+		"otp.md":   "Your code is {{.Code}}",
+		"otp.html": `{{define "content"}}<p class="code">{{.Code}}</p>{{template "footer" .}}{{end}}`,
+		"layout.html": `<html><head><style>p { margin: 0 } .code { font-size: 20px }</style></head>` +
+			`<body>{{template "content" .}}</body></html>`,
+		"partials/footer.html": `{{define "footer"}}<p style="color: grey">Kind regards</p>{{end}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	sender := SMTPSender{Name: "IDP", Email: "idp@example.com"}
+	tpl, err := ParseEmailTemplate(sender, EmailTemplateFiles{
+		Subject: "Code",
+		Text:    filepath.Join(dir, "otp.md"),
+		Html:    filepath.Join(dir, "otp.html"),
+	}, EmailLayout{
+		Html:     filepath.Join(dir, "layout.html"),
+		Partials: filepath.Join(dir, "partials", "*.html"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &CaptureMailer{}
+	sent, err := SendEmailUsingTemplate(context.Background(), mailer, "Alice", "alice@example.com", tpl, struct{ Code string }{"<123456>"})
+	if err != nil || !sent {
+		t.Fatalf("Expected mail sent, got %v %v", sent, err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+	m := messages[0]
+	if m.From != "idp@example.com" || len(m.To) != 1 || m.To[0] != "alice@example.com" {
+		t.Errorf("Unexpected envelope %s -> %v", m.From, m.To)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q %v", mediaType, err)
+	}
+
+	parts := make(map[string]string)
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(p) // Quoted-printable is decoded by the reader
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[strings.Split(p.Header.Get("Content-Type"), ";")[0]] = string(body)
+	}
+
+	if parts["text/plain"] != "Your code is <123456>" {
+		t.Errorf("Unexpected text part %q", parts["text/plain"])
+	}
+	for _, expected := range []string{
+		`<p class="code" style="margin: 0; font-size: 20px">&lt;123456&gt;</p>`,
+		`<p style="margin: 0; color: grey">Kind regards</p>`,
+	} {
+		if !strings.Contains(parts["text/html"], expected) {
+			t.Errorf("Expected %s in html part %s", expected, parts["text/html"])
+		}
+	}
+}
+
+func TestParseEmailTemplateFailsOnBrokenTemplate(t *testing.T) {
+	dir := writeTemplateFiles(t, map[string]string{
+		"otp.md": "Your code is {{.Code}",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := ParseEmailTemplate(SMTPSender{}, EmailTemplateFiles{Subject: "Code", Text: filepath.Join(dir, "otp.md")}, EmailLayout{})
+	if err == nil {
+		t.Error("Expected error on broken text template")
+	}
+}
+
+func TestLocalizedEmailTemplateFallback(t *testing.T) {
+	da := &EmailTemplate{Subject: "Din kode"}
+	daDk := &EmailTemplate{Subject: "Din kode (DK)"}
+	en := &EmailTemplate{Subject: "Your code"}
+	localized := &LocalizedEmailTemplate{DefaultLocale: "en", Locales: map[string]*EmailTemplate{"da": da, "da-dk": daDk, "en": en}}
+
+	tests := []struct {
+		preferred []string
+		expected  *EmailTemplate
+	}{
+		{[]string{"da-DK"}, daDk},
+		{[]string{"da_dk"}, daDk},
+		{[]string{"da-GL"}, da},
+		{[]string{"da-Latn-GL"}, da},
+		{[]string{"", "da"}, da},
+		{[]string{"de-DE", "da"}, da},
+		{[]string{"de-DE"}, en},
+		{nil, en},
+	}
+	for _, test := range tests {
+		if tpl := localized.Locale(test.preferred...); tpl != test.expected {
+			t.Errorf("Locale(%q) = %q, expected %q", test.preferred, tpl.Subject, test.expected.Subject)
+		}
+	}
+}
+
+// fakeSmtpServer accepts one session on localhost, answering LOGIN auth and recording the commands and the data it receives.
+func fakeSmtpServer(t *testing.T) (addr string, session chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session = make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-localhost")
+				reply("250 AUTH LOGIN")
+			case line == "AUTH LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				u, _ := r.ReadString('\n')
+				lines = append(lines, strings.TrimSpace(u))
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				p, _ := r.ReadString('\n')
+				lines = append(lines, strings.TrimSpace(p))
+				reply("235 Authenticated")
+			case line == "DATA":
+				reply("354 Go ahead")
+				for {
+					d, _ := r.ReadString('\n')
+					if d == ".\r\n" || d == "" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(d, "\r\n"))
+				}
+				reply("250 Queued")
+			case line == "QUIT":
+				reply("221 Bye")
+				session <- lines
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+		session <- lines
+	}()
+
+	return l.Addr().String(), session
+}
+
+func TestSMTPMailerLoginAuth(t *testing.T) {
+	addr, session := fakeSmtpServer(t)
+
+	mailer := &SMTPMailer{
+		Config: SMTPConfig{Host: addr, Username: "idp", Password: "secret"},
+		Tls:    SMTP_TLS_NONE,
+		Auth:   SMTP_AUTH_LOGIN,
+	}
+	err := mailer.Send(context.Background(), "idp@example.com", []string{"alice@example.com"}, []byte("Subject: Hi\r\n\r\nHello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Join(<-session, "\n")
+	for _, expected := range []string{
+		"AUTH LOGIN",
+		base64.StdEncoding.EncodeToString([]byte("idp")),
+		base64.StdEncoding.EncodeToString([]byte("secret")),
+		"MAIL FROM:<idp@example.com>",
+		"RCPT TO:<alice@example.com>",
+		"Subject: Hi",
+		"Hello",
+	} {
+		if !strings.Contains(lines, expected) {
+			t.Errorf("Expected %q in session:\n%s", expected, lines)
+		}
+	}
+}
+
+func TestSMTPMailerRequiresStartTls(t *testing.T) {
+	addr, _ := fakeSmtpServer(t)
+
+	mailer := &SMTPMailer{Config: SMTPConfig{Host: addr}}
+	err := mailer.Send(context.Background(), "idp@example.com", []string{"alice@example.com"}, []byte("Hello"))
+	if err == nil {
+		t.Fatal("Expected error as the server does not offer STARTTLS")
+	}
+}
@@ -182,6 +182,7 @@ type Invite struct {
 
 	Email    string
 	Username string
+	Locale   string // Of the emails sent to the invite, handed on to the human created from it
 
 	SentAt int64
 }
@@ -195,11 +196,17 @@ func marshalNodeToInvite(node neo4j.Node) Invite {
 		username = p["username"].(string)
 	}
 
+	var locale string
+	if p["locale"] != nil {
+		locale = p["locale"].(string)
+	}
+
 	return Invite{
 		Identity: marshalNodeToIdentity(node),
 
 		Email:    p["email"].(string),
 		Username: username,
+		Locale:   locale,
 		SentAt:   p["sent_at"].(int64),
 	}
 }
@@ -222,6 +229,33 @@ func marshalNodeToResourceServer(node neo4j.Node) ResourceServer {
 	}
 }
 
+type Scope struct {
+	Id               string
+	IssuedAt         int64
+	ResourceServerId string // The resource server owning the scope
+	Name             string
+	Description      string
+	ConsentText      string // Text shown to the user on the consent screen
+}
+
+func marshalNodeToScope(node neo4j.Node, resourceServerId string) Scope {
+	p := node.Props()
+
+	var consentText string
+	if p["consent_text"] != nil {
+		consentText = p["consent_text"].(string)
+	}
+
+	return Scope{
+		Id:               p["id"].(string),
+		IssuedAt:         p["iat"].(int64),
+		ResourceServerId: resourceServerId,
+		Name:             p["name"].(string),
+		Description:      p["description"].(string),
+		ConsentText:      consentText,
+	}
+}
+
 type Role struct {
 	Identity
 	Name        string
@@ -240,15 +274,20 @@ func marshalNodeToRole(node neo4j.Node) Role {
 
 type Client struct {
 	Identity
-	Secret                  string
-	Name                    string
-	Description             string
-	GrantTypes              []string
-	Audiences               []string
-	ResponseTypes           []string
-	RedirectUris            []string
-	PostLogoutRedirectUris  []string
-	TokenEndpointAuthMethod string
+	Secret                   string
+	SecondarySecret          string // The previous secret, still active in Hydra while rotating until SecondarySecretExpiresAt
+	SecondarySecretExpiresAt int64
+	Name                     string
+	Description              string
+	GrantTypes               []string
+	Audiences                []string
+	ResponseTypes            []string
+	RedirectUris             []string
+	PostLogoutRedirectUris   []string
+	TokenEndpointAuthMethod  string
+	Jwks                     string // JSON encoded JSON Web Key Set, used with private_key_jwt
+	JwksUri                  string
+	RegistrationAccessToken  string // SHA-256 hash of the token given to dynamically registered clients to manage themselves
 }
 
 func marshalNodeToClient(node neo4j.Node) Client {
@@ -262,6 +301,16 @@ func marshalNodeToClient(node neo4j.Node) Client {
 		secret = cs.(string)
 	}
 
+	var secondarySecret string
+	if p["secondary_secret"] != nil {
+		secondarySecret = p["secondary_secret"].(string)
+	}
+
+	var secondarySecretExpiresAt int64
+	if p["secondary_secret_exp"] != nil {
+		secondarySecretExpiresAt = p["secondary_secret_exp"].(int64)
+	}
+
 	var grantTypes []string
 	for _, e := range p["grant_types"].([]interface{}) {
 		grantTypes = append(grantTypes, e.(string))
@@ -299,17 +348,37 @@ func marshalNodeToClient(node neo4j.Node) Client {
 		}
 	}
 
+	var jwks string
+	if p["jwks"] != nil {
+		jwks = p["jwks"].(string)
+	}
+
+	var jwksUri string
+	if p["jwks_uri"] != nil {
+		jwksUri = p["jwks_uri"].(string)
+	}
+
+	var registrationAccessToken string
+	if p["registration_access_token"] != nil {
+		registrationAccessToken = p["registration_access_token"].(string)
+	}
+
 	return Client{
-		Identity:                marshalNodeToIdentity(node), // This is client_id
-		Secret:                  secret,
-		Name:                    p["name"].(string),
-		Description:             p["description"].(string),
-		GrantTypes:              grantTypes,
-		Audiences:               audiences,
-		ResponseTypes:           responseTypes,
-		RedirectUris:            redirectUris,
-		PostLogoutRedirectUris:  postLogoutRedirectUris,
-		TokenEndpointAuthMethod: p["token_endpoint_auth_method"].(string),
+		Identity:                 marshalNodeToIdentity(node), // This is client_id
+		Secret:                   secret,
+		SecondarySecret:          secondarySecret,
+		SecondarySecretExpiresAt: secondarySecretExpiresAt,
+		Name:                     p["name"].(string),
+		Description:              p["description"].(string),
+		GrantTypes:               grantTypes,
+		Audiences:                audiences,
+		ResponseTypes:            responseTypes,
+		RedirectUris:             redirectUris,
+		PostLogoutRedirectUris:   postLogoutRedirectUris,
+		TokenEndpointAuthMethod:  p["token_endpoint_auth_method"].(string),
+		Jwks:                     jwks,
+		JwksUri:                  jwksUri,
+		RegistrationAccessToken:  registrationAccessToken,
 	}
 }
 
@@ -323,6 +392,8 @@ type Human struct {
 
 	Name string
 
+	Locale string // Preferred locale of emails, like da-DK. Empty for the default locale.
+
 	AllowLogin bool
 
 	Password string
@@ -334,6 +405,11 @@ type Human struct {
 func marshalNodeToHuman(node neo4j.Node) Human {
 	p := node.Props()
 
+	var locale string
+	if p["locale"] != nil {
+		locale = p["locale"].(string)
+	}
+
 	return Human{
 		Identity: marshalNodeToIdentity(node),
 
@@ -343,6 +419,8 @@ func marshalNodeToHuman(node neo4j.Node) Human {
 
 		Name: p["name"].(string),
 
+		Locale: locale,
+
 		AllowLogin: p["allow_login"].(bool),
 
 		Password: p["password"].(string),
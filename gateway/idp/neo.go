@@ -1,42 +1,97 @@
 package idp
 
 import (
+	"context"
 	"fmt"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/opensentry/idp/metrics"
+	"github.com/opensentry/idp/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func BeginReadTx(driver neo4j.Driver, configurers ...func(*neo4j.TransactionConfig)) (neo4j.Session, neo4j.Transaction, error) {
+// timedTransaction records the duration of the transaction and ends its span when it is committed or rolled back.
+type timedTransaction struct {
+	neo4j.Transaction
+	mode     string
+	start    time.Time
+	span     trace.Span
+	observed bool
+}
+
+func (t *timedTransaction) observe(outcome string) {
+	if t.observed {
+		return
+	}
+	t.observed = true
+	metrics.Neo4jTransactionDuration.WithLabelValues(t.mode, outcome).Observe(time.Since(t.start).Seconds())
+	t.span.SetAttributes(attribute.String("db.neo4j.outcome", outcome))
+	t.span.End()
+}
+
+func (t *timedTransaction) Commit() error {
+	err := t.Transaction.Commit()
+	if err != nil {
+		t.observe("error")
+		return err
+	}
+	t.observe("commit")
+	return nil
+}
+
+func (t *timedTransaction) Rollback() error {
+	t.observe("rollback")
+	return t.Transaction.Rollback()
+}
+
+func (t *timedTransaction) Close() error {
+	t.observe("rollback") // Closing an open transaction rolls it back
+	return t.Transaction.Close()
+}
+
+func BeginReadTx(ctx context.Context, driver neo4j.Driver, configurers ...func(*neo4j.TransactionConfig)) (neo4j.Session, neo4j.Transaction, error) {
 	session, err := driver.Session(neo4j.AccessModeRead)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	_, span := tracing.Start(ctx, "neo4j.transaction", attribute.String("db.system", "neo4j"), attribute.String("db.neo4j.access_mode", "read"))
+
 	tx, err := session.BeginTransaction(configurers...)
 
 	if err != nil {
+		tracing.End(span, err)
 		session.Close()
+		return nil, nil, err
 	}
 
-	return session, tx, err
+	return session, &timedTransaction{Transaction: tx, mode: "read", start: time.Now(), span: span}, nil
 }
 
-func BeginWriteTx(driver neo4j.Driver, configurers ...func(*neo4j.TransactionConfig)) (neo4j.Session, neo4j.Transaction, error) {
+func BeginWriteTx(ctx context.Context, driver neo4j.Driver, configurers ...func(*neo4j.TransactionConfig)) (neo4j.Session, neo4j.Transaction, error) {
 	session, err := driver.Session(neo4j.AccessModeWrite)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	_, span := tracing.Start(ctx, "neo4j.transaction", attribute.String("db.system", "neo4j"), attribute.String("db.neo4j.access_mode", "write"))
+
 	tx, err := session.BeginTransaction(configurers...)
 
 	if err != nil {
+		tracing.End(span, err)
 		session.Close()
+		return nil, nil, err
 	}
 
-	return session, tx, err
+	return session, &timedTransaction{Transaction: tx, mode: "write", start: time.Now(), span: span}, nil
 }
 
 func logCypher(query string, params map[string]interface{}) {
@@ -53,6 +108,8 @@ func logCypher(query string, params map[string]interface{}) {
 			query = strings.Replace(query, "$"+i, "\""+e.(string)+"\"", -1)
 		case []string:
 			query = strings.Replace(query, "$"+i, "["+strings.Join(e.([]string), ",")+"]", -1)
+		case []map[string]interface{}:
+			query = strings.Replace(query, "$"+i, fmt.Sprintf("%v", e), -1)
 		default:
 			panic(fmt.Sprintf("Unsupported type %T", t))
 		}
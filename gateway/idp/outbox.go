@@ -0,0 +1,407 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/opensentry/idp/metrics"
+	"github.com/opensentry/idp/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	EVENT_STATUS_PENDING   = "pending"
+	EVENT_STATUS_PUBLISHED = "published"
+)
+
+// OutboxEvent is an event in the outbox. Body is the marshalled envelope, which is published as is, so a replay publishes the exact same message.
+type OutboxEvent struct {
+	Id      string // Id of the event, used as Nats-Msg-Id so JetStream drops duplicates
+	Subject string
+	Type    string
+	Time    int64 // Unixtime in nanoseconds, orders the events
+	Body    string
+	Trace   map[string]string // Trace context of the request that caused the event
+
+	Status        string
+	Attempts      int64
+	NextAttemptAt int64
+	PublishedAt   int64
+
+	IssuedAt int64
+}
+
+func marshalNodeToOutboxEvent(node neo4j.Node) OutboxEvent {
+	p := node.Props()
+
+	var trace map[string]string
+	if p["trace"] != nil {
+		json.Unmarshal([]byte(p["trace"].(string)), &trace) // A broken trace context only breaks the trace, not the event
+	}
+
+	return OutboxEvent{
+		Id:      p["id"].(string),
+		Subject: p["subject"].(string),
+		Type:    p["type"].(string),
+		Time:    p["time"].(int64),
+		Body:    p["body"].(string),
+		Trace:   trace,
+
+		Status:        p["status"].(string),
+		Attempts:      p["attempts"].(int64),
+		NextAttemptAt: p["next_attempt_at"].(int64),
+		PublishedAt:   p["published_at"].(int64),
+
+		IssuedAt: p["iat"].(int64),
+	}
+}
+
+// QueueEvents puts the events of the batch in the outbox. They are published by jobs.RelayEvents and delivered to the webhooks subscribed to them by jobs.DeliverWebhooks once tx commits.
+func QueueEvents(tx neo4j.Transaction, events *EventBatch) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if events.Len() == 0 {
+		return nil
+	}
+
+	var newEvents []interface{}
+	for _, e := range events.events {
+		trace, err := json.Marshal(e.Trace)
+		if err != nil {
+			return err
+		}
+		newEvents = append(newEvents, map[string]interface{}{
+			"id":      e.Id,
+			"subject": e.Subject,
+			"type":    e.Type,
+			"time":    e.Time,
+			"body":    e.Body,
+			"trace":   string(trace),
+		})
+	}
+	params["events"] = newEvents
+	params["status"] = EVENT_STATUS_PENDING
+
+	cypher = fmt.Sprintf(`
+    UNWIND $events as n
+    CREATE (e:Event {
+      id:n.id, subject:n.subject, type:n.type, time:n.time, body:n.body, trace:n.trace,
+      iat:datetime().epochSeconds,
+      status:$status, attempts:0, next_attempt_at:datetime().epochSeconds, published_at:0
+    })
+    RETURN count(e)
+  `)
+
+	result, err = tx.Run(cypher, params)
+	delete(params, "events") // Bodies can be large, ids are logged instead
+	params["ids"] = outboxEventIds(events.events)
+	if err != nil {
+		return err
+	}
+
+	var queued int64
+	if result.Next() {
+		queued = result.Record().GetByIndex(0).(int64)
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	if queued != int64(events.Len()) {
+		return errors.New("Unable to queue Events")
+	}
+
+	return queueWebhookDeliveries(tx, events.events)
+}
+
+func outboxEventIds(events []OutboxEvent) string {
+	var ids []string
+	for _, e := range events {
+		ids = append(ids, e.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// ClaimPendingEvents returns up to limit events due for publishing, oldest first, and postpones their next attempt until leaseUntil,
+// so other instances relaying from the same outbox do not pick them while this one is publishing.
+func ClaimPendingEvents(tx neo4j.Transaction, now int64, leaseUntil int64, limit int64) (events []OutboxEvent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["status"] = EVENT_STATUS_PENDING
+	params["now"] = now
+	params["lease"] = leaseUntil
+	params["limit"] = limit
+
+	cypher = fmt.Sprintf(`
+    MATCH (e:Event {status:$status}) WHERE e.next_attempt_at <= $now
+    WITH e ORDER BY e.time, e.id LIMIT $limit
+    SET e.next_attempt_at = $lease
+    RETURN e ORDER BY e.time, e.id
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		eventNode := record.GetByIndex(0)
+
+		if eventNode != nil {
+			events = append(events, marshalNodeToOutboxEvent(eventNode.(neo4j.Node)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// UpdateEventDelivery records the outcome of an attempt. Status is EVENT_STATUS_PENDING to retry at nextAttemptAt or EVENT_STATUS_PUBLISHED.
+func UpdateEventDelivery(tx neo4j.Transaction, eventToUpdate OutboxEvent, status string, nextAttemptAt int64) (event OutboxEvent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if eventToUpdate.Id == "" {
+		return OutboxEvent{}, errors.New("Missing Event.Id")
+	}
+	params["id"] = eventToUpdate.Id
+	params["status"] = status
+	params["next_attempt_at"] = nextAttemptAt
+
+	cypPublished := ""
+	switch status {
+	case EVENT_STATUS_PENDING:
+	case EVENT_STATUS_PUBLISHED:
+		cypPublished = `SET e.published_at = datetime().epochSeconds`
+	default:
+		return OutboxEvent{}, errors.New("Unsupported Event status")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH (e:Event {id:$id})
+    SET e.status = $status, e.attempts = e.attempts + 1, e.next_attempt_at = $next_attempt_at
+    %s
+    RETURN e
+  `, cypPublished)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return OutboxEvent{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		eventNode := record.GetByIndex(0)
+
+		if eventNode != nil {
+			event = marshalNodeToOutboxEvent(eventNode.(neo4j.Node))
+		}
+	} else {
+		return OutboxEvent{}, errors.New("Unable to update Event")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return OutboxEvent{}, err
+	}
+
+	return event, nil
+}
+
+// ReleaseEvents hands claimed events back to the outbox to be published at nextAttemptAt, without counting an attempt.
+func ReleaseEvents(tx neo4j.Transaction, events []OutboxEvent, nextAttemptAt int64) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if len(events) == 0 {
+		return nil
+	}
+	params["ids"] = outboxEventIds(events)
+	params["status"] = EVENT_STATUS_PENDING
+	params["next_attempt_at"] = nextAttemptAt
+
+	cypher = fmt.Sprintf(`
+    MATCH (e:Event {status:$status}) WHERE e.id in split($ids, ",")
+    SET e.next_attempt_at = $next_attempt_at
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if _, err = result.Consume(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FetchOutboxEvents returns the events in the outbox by their id.
+func FetchOutboxEvents(tx neo4j.Transaction, ids []string) (events []OutboxEvent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	params["ids"] = strings.Join(ids, ",")
+
+	cypher = fmt.Sprintf(`
+    MATCH (e:Event) WHERE e.id in split($ids, ",")
+    RETURN e ORDER BY e.time, e.id
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		eventNode := record.GetByIndex(0)
+
+		if eventNode != nil {
+			events = append(events, marshalNodeToOutboxEvent(eventNode.(neo4j.Node)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// FetchPublishedEvents returns up to limit published events ordered by time, starting after the event at time afterTime with id afterId.
+// Pass an empty afterId to include the events at afterTime.
+func FetchPublishedEvents(tx neo4j.Transaction, afterTime int64, afterId string, limit int64) (events []OutboxEvent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["status"] = EVENT_STATUS_PUBLISHED
+	params["time"] = afterTime
+	params["id"] = afterId
+	params["limit"] = limit
+
+	cypher = fmt.Sprintf(`
+    MATCH (e:Event {status:$status}) WHERE e.time > $time OR (e.time = $time AND e.id > $id)
+    RETURN e ORDER BY e.time, e.id LIMIT $limit
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		eventNode := record.GetByIndex(0)
+
+		if eventNode != nil {
+			events = append(events, marshalNodeToOutboxEvent(eventNode.(neo4j.Node)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeletePublishedEvents removes events published before publishedBefore with their webhook deliveries. They can no longer be replayed.
+// Events still pending delivery to a webhook are kept until the delivery is done with.
+func DeletePublishedEvents(tx neo4j.Transaction, publishedBefore int64) (deleted int64, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["published_at"] = publishedBefore
+	params["status"] = EVENT_STATUS_PUBLISHED
+	params["pending"] = WEBHOOK_DELIVERY_STATUS_PENDING
+
+	cypher = fmt.Sprintf(`
+    MATCH (e:Event {status:$status}) WHERE e.published_at < $published_at
+      AND NOT (e)<-[:DELIVERS]-(:WebhookDelivery {status:$pending})
+    OPTIONAL MATCH (e)<-[:DELIVERS]-(d:WebhookDelivery)
+    OPTIONAL MATCH (d)-[:ATTEMPTED]->(a:WebhookAttempt)
+    WITH e, collect(DISTINCT d) + collect(DISTINCT a) as deliveries
+    FOREACH (n IN deliveries | DETACH DELETE n)
+    DETACH DELETE e
+    RETURN count(e)
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return 0, err
+	}
+
+	if result.Next() {
+		deleted = result.Record().GetByIndex(0).(int64)
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// PublishEvent publishes the event to JetStream and waits for the stream to acknowledge it.
+// msgId is the Nats-Msg-Id, so the stream drops an event published twice with it within its duplicate window and the ack says so.
+// The relay uses the event id, a replay one of its own.
+func PublishEvent(ctx context.Context, js nats.JetStreamContext, e OutboxEvent, msgId string) (ack *nats.PubAck, err error) {
+	msg := &nats.Msg{Subject: e.Subject, Data: []byte(e.Body), Header: nats.Header{}}
+	for k, v := range e.Trace {
+		msg.Header.Set(k, v)
+	}
+
+	// The span continues the trace of the request that caused the event, not that of the relay.
+	ctx, span := tracing.Start(tracing.ExtractNats(ctx, msg), "nats.publish", attribute.String("messaging.system", "nats"), attribute.String("messaging.destination", e.Subject), attribute.String("messaging.message_id", e.Id))
+	defer func() { tracing.End(span, err) }()
+
+	msg.Header.Set("Content-Type", EVENT_CONTENT_TYPE)
+	tracing.InjectNats(ctx, msg)
+
+	ack, err = js.PublishMsg(msg, nats.MsgId(msgId))
+	if err != nil {
+		metrics.NatsPublishFailuresTotal.WithLabelValues(e.Subject).Inc()
+		return nil, err
+	}
+	return ack, nil
+}
@@ -0,0 +1,91 @@
+package idp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// fakeTx answers the queries of QueueEvents without a database: Fetch webhooks returns webhooks, every other query the number of rows it was given.
+type fakeTx struct {
+	neo4j.Transaction
+	webhooks []map[string]interface{}
+	queries  []string
+	params   []map[string]interface{}
+}
+
+func (tx *fakeTx) Run(cypher string, params map[string]interface{}) (neo4j.Result, error) {
+	tx.queries = append(tx.queries, cypher)
+	tx.params = append(tx.params, params)
+
+	if strings.Contains(cypher, "Fetch webhooks") {
+		var records []fakeRecord
+		for _, w := range tx.webhooks {
+			records = append(records, fakeRecord{fakeNode(w)})
+		}
+		return &fakeResult{records: records}, nil
+	}
+
+	var rows int64
+	for _, p := range params {
+		if l, ok := p.([]interface{}); ok {
+			rows = int64(len(l))
+		}
+	}
+	return &fakeResult{records: []fakeRecord{{rows}}}, nil
+}
+
+type fakeResult struct {
+	neo4j.Result
+	records []fakeRecord
+	current fakeRecord
+}
+
+func (r *fakeResult) Next() bool {
+	if len(r.records) == 0 {
+		return false
+	}
+	r.current, r.records = r.records[0], r.records[1:]
+	return true
+}
+
+func (r *fakeResult) Err() error           { return nil }
+func (r *fakeResult) Record() neo4j.Record { return r.current }
+
+type fakeRecord []interface{}
+
+func (r fakeRecord) Keys() []string                     { return nil }
+func (r fakeRecord) Values() []interface{}              { return r }
+func (r fakeRecord) Get(key string) (interface{}, bool) { return nil, false }
+func (r fakeRecord) GetByIndex(index int) interface{}   { return r[index] }
+
+type fakeNode map[string]interface{}
+
+func (n fakeNode) Id() int64                     { return 0 }
+func (n fakeNode) Labels() []string              { return nil }
+func (n fakeNode) Props() map[string]interface{} { return n }
+
+func TestQueueEventsWithSubscribedWebhook(t *testing.T) {
+	tx := &fakeTx{webhooks: []map[string]interface{}{
+		{"id": "subscribed", "url": "https://example.com/hook", "secret": "", "events": []interface{}{"idp.client.*"}, "description": "", "iat": int64(0)},
+		{"id": "other", "url": "https://example.com/hook", "secret": "", "events": []interface{}{"idp.human.*"}, "description": "", "iat": int64(0)},
+	}}
+
+	var events EventBatch
+	EmitEventClientCreated(context.Background(), &events, Client{Identity: Identity{Id: "client-id"}})
+
+	if err := QueueEvents(tx, &events); err != nil {
+		t.Fatal(err)
+	}
+
+	last := len(tx.queries) - 1
+	if !strings.Contains(tx.queries[last], "WebhookDelivery") {
+		t.Fatalf("Expected deliveries to be queued, got %s", tx.queries[last])
+	}
+	pairs := tx.params[last]["pairs"]
+	if pairs != events.events[0].Id+"->subscribed" {
+		t.Errorf("Expected a delivery to the subscribed webhook only, got %v", pairs)
+	}
+}
@@ -154,7 +154,8 @@ func DeleteResourceServer(tx neo4j.Transaction, managedBy *Identity, resourceSer
 	// Warning: Do not accidentally delete i!
 	cypher = fmt.Sprintf(`
     MATCH %s(c:ResourceServer:Identity {id:$id})
-    DETACH DELETE c
+    OPTIONAL MATCH (c)-[:OWNS]->(s:Scope)
+    DETACH DELETE s, c
   `, cypManages)
 
 	if result, err = tx.Run(cypher, params); err != nil {
@@ -173,3 +174,70 @@ func DeleteResourceServer(tx neo4j.Transaction, managedBy *Identity, resourceSer
 	resourceServer.Id = resourceServerToDelete.Id
 	return resourceServer, nil
 }
+
+// NOTE: Only fields set on resourceServerToUpdate are changed.
+func UpdateResourceServer(tx neo4j.Transaction, managedBy *Identity, resourceServerToUpdate ResourceServer) (resourceServer ResourceServer, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if resourceServerToUpdate.Id == "" {
+		return ResourceServer{}, errors.New("Missing ResourceServer.Id")
+	}
+	params["id"] = resourceServerToUpdate.Id
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	var cypSet []string
+	if resourceServerToUpdate.Name != "" {
+		params["name"] = resourceServerToUpdate.Name
+		cypSet = append(cypSet, `rs.name=$name`)
+	}
+	if resourceServerToUpdate.Description != "" {
+		params["description"] = resourceServerToUpdate.Description
+		cypSet = append(cypSet, `rs.description=$description`)
+	}
+	if resourceServerToUpdate.Audience != "" {
+		params["aud"] = resourceServerToUpdate.Audience
+		cypSet = append(cypSet, `rs.aud=$aud`)
+	}
+
+	cypUpdate := ""
+	if len(cypSet) > 0 {
+		cypUpdate = `SET ` + strings.Join(cypSet, ", ")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH %s(rs:ResourceServer:Identity {id:$id})
+    %s
+    RETURN rs
+  `, cypManages, cypUpdate)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return ResourceServer{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		resourceServerNode := record.GetByIndex(0)
+
+		if resourceServerNode != nil {
+			resourceServer = marshalNodeToResourceServer(resourceServerNode.(neo4j.Node))
+		}
+	} else {
+		return ResourceServer{}, errors.New("Unable to update ResourceServer")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return ResourceServer{}, err
+	}
+
+	return resourceServer, nil
+}
@@ -113,6 +113,64 @@ func FetchRoles(tx neo4j.Transaction, iFilterRoles []Role, iRequest Identity) (r
 	return rRoles, nil
 }
 
+// NOTE: Only fields set on iRole are changed.
+func UpdateRole(tx neo4j.Transaction, iRole Role, requestor Identity) (rRole Role, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iRole.Id == "" {
+		return Role{}, errors.New("Missing Role.Id")
+	}
+	params["id"] = iRole.Id
+
+	var cypSet []string
+	if iRole.Name != "" {
+		params["name"] = iRole.Name
+		cypSet = append(cypSet, `role.name=$name`)
+	}
+	if iRole.Description != "" {
+		params["description"] = iRole.Description
+		cypSet = append(cypSet, `role.description=$description`)
+	}
+
+	cypUpdate := ""
+	if len(cypSet) > 0 {
+		cypUpdate = `SET ` + strings.Join(cypSet, ", ")
+	}
+
+	cypher = fmt.Sprintf(`
+    // Update role
+
+    MATCH (role:Role:Identity {id:$id})
+    %s
+    RETURN role
+  `, cypUpdate)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Role{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		roleNode := record.GetByIndex(0)
+
+		if roleNode != nil {
+			rRole = marshalNodeToRole(roleNode.(neo4j.Node))
+		}
+	} else {
+		return Role{}, errors.New("Unable to update Role")
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Role{}, err
+	}
+
+	return rRole, nil
+}
+
 func DeleteRole(tx neo4j.Transaction, iRole Role, requestor Identity) (rRole Role, err error) {
 	var cypher string
 	var params = make(map[string]interface{})
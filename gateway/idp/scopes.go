@@ -0,0 +1,300 @@
+package idp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"strings"
+)
+
+func CreateScope(tx neo4j.Transaction, managedBy *Identity, newScope Scope) (scope Scope, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if newScope.ResourceServerId == "" {
+		return Scope{}, errors.New("Missing Scope.ResourceServerId")
+	}
+	params["rs"] = newScope.ResourceServerId
+
+	if newScope.Name == "" {
+		return Scope{}, errors.New("Missing Scope.Name")
+	}
+	params["name"] = newScope.Name
+
+	if newScope.Description == "" {
+		return Scope{}, errors.New("Missing Scope.Description")
+	}
+	params["description"] = newScope.Description
+	params["consent_text"] = newScope.ConsentText
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	// A scope name is unique per resource server
+	cypher = fmt.Sprintf(`
+    MATCH %s(rs:ResourceServer:Identity {id:$rs})
+    WHERE NOT (rs)-[:OWNS]->(:Scope {name:$name})
+
+    CREATE (rs)-[:OWNS]->(s:Scope {
+      id:randomUUID(),
+      iat:datetime().epochSeconds,
+      name:$name,
+      description:$description,
+      consent_text:$consent_text
+    })
+
+    RETURN s, rs.id
+  `, cypManages)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Scope{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		scopeNode := record.GetByIndex(0)
+
+		if scopeNode != nil {
+			scope = marshalNodeToScope(scopeNode.(neo4j.Node), record.GetByIndex(1).(string))
+		}
+	} else {
+		return Scope{}, errors.New("Unable to create Scope")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Scope{}, err
+	}
+
+	return scope, nil
+}
+
+// NOTE: Filters on Id, ResourceServerId and Name of iScopes. Each field filters on the values given for it across all iScopes.
+func FetchScopes(tx neo4j.Transaction, managedBy *Identity, iScopes []Scope) (scopes []Scope, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	var ids, rsIds, names []string
+	for _, s := range iScopes {
+		if s.Id != "" {
+			ids = append(ids, s.Id)
+		}
+		if s.ResourceServerId != "" {
+			rsIds = append(rsIds, s.ResourceServerId)
+		}
+		if s.Name != "" {
+			names = append(names, s.Name)
+		}
+	}
+
+	cypFilterScopes := ""
+	if len(ids) > 0 {
+		cypFilterScopes = cypFilterScopes + ` AND s.id in $ids `
+		params["ids"] = ids
+	}
+	if len(rsIds) > 0 {
+		cypFilterScopes = cypFilterScopes + ` AND rs.id in $rs_ids `
+		params["rs_ids"] = rsIds
+	}
+	if len(names) > 0 {
+		cypFilterScopes = cypFilterScopes + ` AND s.name in $names `
+		params["names"] = names
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH %s(rs:ResourceServer:Identity)-[:OWNS]->(s:Scope) WHERE 1=1 %s
+    RETURN s, rs.id
+  `, cypManages, cypFilterScopes)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		scopeNode := record.GetByIndex(0)
+
+		if scopeNode != nil {
+			scopes = append(scopes, marshalNodeToScope(scopeNode.(neo4j.Node), record.GetByIndex(1).(string)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return scopes, nil
+}
+
+// NOTE: Only fields set on scopeToUpdate are changed. The owning resource server can not be changed.
+func UpdateScope(tx neo4j.Transaction, managedBy *Identity, scopeToUpdate Scope) (scope Scope, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if scopeToUpdate.Id == "" {
+		return Scope{}, errors.New("Missing Scope.Id")
+	}
+	params["id"] = scopeToUpdate.Id
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	var cypSet []string
+	if scopeToUpdate.Description != "" {
+		params["description"] = scopeToUpdate.Description
+		cypSet = append(cypSet, `s.description=$description`)
+	}
+	if scopeToUpdate.ConsentText != "" {
+		params["consent_text"] = scopeToUpdate.ConsentText
+		cypSet = append(cypSet, `s.consent_text=$consent_text`)
+	}
+
+	cypUpdate := ""
+	if len(cypSet) > 0 {
+		cypUpdate = `SET ` + strings.Join(cypSet, ", ")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH %s(rs:ResourceServer:Identity)-[:OWNS]->(s:Scope {id:$id})
+    %s
+    RETURN s, rs.id
+  `, cypManages, cypUpdate)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Scope{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		scopeNode := record.GetByIndex(0)
+
+		if scopeNode != nil {
+			scope = marshalNodeToScope(scopeNode.(neo4j.Node), record.GetByIndex(1).(string))
+		}
+	} else {
+		return Scope{}, errors.New("Unable to update Scope")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Scope{}, err
+	}
+
+	return scope, nil
+}
+
+func DeleteScope(tx neo4j.Transaction, managedBy *Identity, scopeToDelete Scope) (scope Scope, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if scopeToDelete.Id == "" {
+		return Scope{}, errors.New("Missing Scope.Id")
+	}
+	params["id"] = scopeToDelete.Id
+
+	var cypManages string
+	if managedBy != nil {
+		cypManages = `(i:Identity {id:$managed_by})-[:MANAGES]->`
+		params["managed_by"] = managedBy.Id
+	}
+
+	// Warning: Do not accidentally delete rs!
+	cypher = fmt.Sprintf(`
+    MATCH %s(rs:ResourceServer:Identity)-[:OWNS]->(s:Scope {id:$id})
+    DETACH DELETE s
+  `, cypManages)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Scope{}, err
+	}
+
+	result.Next()
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Scope{}, err
+	}
+
+	scope.Id = scopeToDelete.Id
+	return scope, nil
+}
+
+// Makes sure the resource server owns the given scopes. Existing scopes keep their description and consent text, so changes made through the api survive a restart.
+func RegisterScopes(tx neo4j.Transaction, resourceServer ResourceServer, scopesToRegister []Scope) (scopes []Scope, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if resourceServer.Id == "" {
+		return nil, errors.New("Missing ResourceServer.Id")
+	}
+	params["rs"] = resourceServer.Id
+
+	var list []map[string]interface{}
+	for _, s := range scopesToRegister {
+		if s.Name == "" {
+			return nil, errors.New("Missing Scope.Name")
+		}
+		list = append(list, map[string]interface{}{
+			"name":         s.Name,
+			"description":  s.Description,
+			"consent_text": s.ConsentText,
+		})
+	}
+	params["scopes"] = list
+
+	cypher = `
+    MATCH (rs:ResourceServer:Identity {id:$rs})
+    UNWIND $scopes as scope
+    MERGE (rs)-[:OWNS]->(s:Scope {name:scope.name})
+    ON CREATE SET s.id=randomUUID(), s.iat=datetime().epochSeconds, s.description=scope.description, s.consent_text=scope.consent_text
+    RETURN s, rs.id
+  `
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		scopeNode := record.GetByIndex(0)
+
+		if scopeNode != nil {
+			scopes = append(scopes, marshalNodeToScope(scopeNode.(neo4j.Node), record.GetByIndex(1).(string)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return scopes, nil
+}
@@ -0,0 +1,167 @@
+package idp
+
+import (
+	"bytes"
+	"errors"
+	htemplate "html/template"
+	"path/filepath"
+	"strings"
+	ttemplate "text/template"
+)
+
+const (
+	EMAIL_TEMPLATE_AUTHENTICATE = "authenticate"
+	EMAIL_TEMPLATE_RECOVER      = "recover"
+	EMAIL_TEMPLATE_DELETE       = "delete"
+	EMAIL_TEMPLATE_EMAILCONFIRM = "emailconfirm"
+	EMAIL_TEMPLATE_EMAILCHANGE  = "emailchange"
+	EMAIL_TEMPLATE_INVITE       = "invite"
+)
+
+// EmailTemplateName is the name of the email template used for challenges of the type, empty if the type sends no email.
+func (d ChallengeType) EmailTemplateName() string {
+	switch d {
+	case ChallengeAuthenticate:
+		return EMAIL_TEMPLATE_AUTHENTICATE
+	case ChallengeRecover:
+		return EMAIL_TEMPLATE_RECOVER
+	case ChallengeDelete:
+		return EMAIL_TEMPLATE_DELETE
+	case ChallengeEmailConfirm:
+		return EMAIL_TEMPLATE_EMAILCONFIRM
+	case ChallengeEmailChange:
+		return EMAIL_TEMPLATE_EMAILCHANGE
+	}
+	return ""
+}
+
+// EmailTemplateFiles are the files of an email template. Html is optional, without it only a plain text email is sent.
+type EmailTemplateFiles struct {
+	Subject string
+	Text    string
+	Html    string
+}
+
+// EmailLayout is shared by the html part of all templates. The layout must call {{template "content" .}}, which each html file defines.
+// Partials are files defining named templates any html file can call. Both are optional.
+type EmailLayout struct {
+	Html     string
+	Partials string // Glob
+}
+
+// EmailTemplate renders the parts of an email. Parse it with ParseEmailTemplate at startup, so broken templates are found before anyone needs them.
+type EmailTemplate struct {
+	Sender  SMTPSender
+	Subject string
+	Locale  string // Of the translation, when part of a LocalizedEmailTemplate
+
+	text *ttemplate.Template
+	html *htemplate.Template
+}
+
+func ParseEmailTemplate(sender SMTPSender, files EmailTemplateFiles, layout EmailLayout) (*EmailTemplate, error) {
+	if files.Subject == "" {
+		return nil, errors.New("Missing subject")
+	}
+
+	if files.Text == "" {
+		return nil, errors.New("Missing text template")
+	}
+
+	text, err := ttemplate.New(filepath.Base(files.Text)).Option("missingkey=error").ParseFiles(files.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := &EmailTemplate{Sender: sender, Subject: files.Subject, text: text}
+
+	if files.Html == "" {
+		return tpl, nil
+	}
+
+	if layout.Html == "" {
+		tpl.html, err = htemplate.New(filepath.Base(files.Html)).Option("missingkey=error").ParseFiles(files.Html)
+		if err != nil {
+			return nil, err
+		}
+		return tpl, nil
+	}
+
+	html := htemplate.New(filepath.Base(layout.Html)).Option("missingkey=error")
+	if layout.Partials != "" {
+		partials, err := filepath.Glob(layout.Partials)
+		if err != nil {
+			return nil, err
+		}
+		if len(partials) > 0 {
+			if html, err = html.ParseFiles(partials...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if html, err = html.ParseFiles(layout.Html, files.Html); err != nil {
+		return nil, err
+	}
+
+	if html.Lookup("content") == nil {
+		return nil, errors.New("Missing {{define \"content\"}} in " + files.Html)
+	}
+
+	tpl.html = html
+	return tpl, nil
+}
+
+// Render executes the templates with data. Html is empty if the template has no html part, otherwise its styles are inlined.
+func (t *EmailTemplate) Render(data interface{}) (text string, html string, err error) {
+	var tb bytes.Buffer
+	if err = t.text.Execute(&tb, data); err != nil {
+		return "", "", err
+	}
+
+	if t.html == nil {
+		return tb.String(), "", nil
+	}
+
+	var hb bytes.Buffer
+	if err = t.html.Execute(&hb, data); err != nil {
+		return "", "", err
+	}
+
+	html, err = InlineCss(hb.String())
+	if err != nil {
+		return "", "", err
+	}
+
+	return tb.String(), html, nil
+}
+
+// LocalizedEmailTemplate is an email template translated to one or more locales. Locales are keyed by NormalizeLocale and must contain DefaultLocale.
+type LocalizedEmailTemplate struct {
+	DefaultLocale string
+	Locales       map[string]*EmailTemplate
+}
+
+// Locale returns the translation for the first of the preferred locales that has one. Each locale falls back to its more general locales before the next is tried, like da-DK to da,
+// and the default locale is used if none of them match. Empty locales are skipped, so the locale of a human can be passed whether set or not.
+func (t *LocalizedEmailTemplate) Locale(preferred ...string) *EmailTemplate {
+	for _, locale := range preferred {
+		for l := NormalizeLocale(locale); l != ""; {
+			if tpl, exists := t.Locales[l]; exists {
+				return tpl
+			}
+
+			i := strings.LastIndex(l, "-")
+			if i < 0 {
+				break
+			}
+			l = l[:i]
+		}
+	}
+	return t.Locales[NormalizeLocale(t.DefaultLocale)]
+}
+
+// NormalizeLocale lower cases the locale and uses - as separator, so da-DK, da_DK and da-dk are the same locale.
+func NormalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
@@ -0,0 +1,725 @@
+package idp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/opensentry/idp/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	WEBHOOK_DELIVERY_STATUS_PENDING   = "pending"
+	WEBHOOK_DELIVERY_STATUS_DELIVERED = "delivered"
+	WEBHOOK_DELIVERY_STATUS_FAILED    = "failed"
+
+	WEBHOOK_HEADER_SIGNATURE = "X-Idp-Signature"
+	WEBHOOK_HEADER_WEBHOOK   = "X-Idp-Webhook-Id"
+	WEBHOOK_HEADER_DELIVERY  = "X-Idp-Delivery-Id"
+	WEBHOOK_HEADER_EVENT     = "X-Idp-Event-Id"
+
+	// Only this much of a response is read, receivers are expected to answer with a status and not much else.
+	WEBHOOK_MAX_RESPONSE_BYTES = 64 * 1024
+)
+
+type Webhook struct {
+	Id          string
+	Url         string
+	Secret      string   // Key of the signature, encrypted with config crypto.keys.webhooks
+	Events      []string // Subjects of the events delivered, with NATS wildcards. All events if empty
+	Description string
+	IssuedAt    int64
+}
+
+// WebhookDelivery is an event to be delivered to a webhook. History holds the attempts, it is only set by FetchWebhookDeliveries.
+type WebhookDelivery struct {
+	Id      string
+	Webhook Webhook
+	Event   OutboxEvent
+
+	Status        string
+	Attempts      int64 // Since created or last redelivered
+	NextAttemptAt int64
+	DeliveredAt   int64
+
+	IssuedAt int64
+
+	History []WebhookAttempt
+}
+
+type WebhookAttempt struct {
+	Attempt    int64
+	Time       int64
+	StatusCode int64 // 0 if the webhook did not respond
+	Error      string
+	Duration   int64 // Milliseconds
+}
+
+func marshalNodeToWebhook(node neo4j.Node) Webhook {
+	p := node.Props()
+
+	var events []string
+	if p["events"] != nil {
+		for _, e := range p["events"].([]interface{}) {
+			events = append(events, e.(string))
+		}
+	}
+
+	return Webhook{
+		Id:          p["id"].(string),
+		Url:         p["url"].(string),
+		Secret:      p["secret"].(string),
+		Events:      events,
+		Description: p["description"].(string),
+		IssuedAt:    p["iat"].(int64),
+	}
+}
+
+func marshalNodeToWebhookDelivery(deliveryNode neo4j.Node, webhookNode neo4j.Node, eventNode neo4j.Node) WebhookDelivery {
+	p := deliveryNode.Props()
+
+	return WebhookDelivery{
+		Id:      p["id"].(string),
+		Webhook: marshalNodeToWebhook(webhookNode),
+		Event:   marshalNodeToOutboxEvent(eventNode),
+
+		Status:        p["status"].(string),
+		Attempts:      p["attempts"].(int64),
+		NextAttemptAt: p["next_attempt_at"].(int64),
+		DeliveredAt:   p["delivered_at"].(int64),
+
+		IssuedAt: p["iat"].(int64),
+	}
+}
+
+func marshalNodeToWebhookAttempt(node neo4j.Node) WebhookAttempt {
+	p := node.Props()
+
+	return WebhookAttempt{
+		Attempt:    p["attempt"].(int64),
+		Time:       p["time"].(int64),
+		StatusCode: p["status_code"].(int64),
+		Error:      p["error"].(string),
+		Duration:   p["duration"].(int64),
+	}
+}
+
+// Matches tells if events with subject are delivered to the webhook. Like NATS subjects * matches one token and > all tokens that follow.
+func (w Webhook) Matches(subject string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, filter := range w.Events {
+		if subjectMatches(filter, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func subjectMatches(filter string, subject string) bool {
+	f := strings.Split(filter, ".")
+	s := strings.Split(subject, ".")
+
+	for i, token := range f {
+		if token == ">" {
+			return i < len(s) // > matches one or more tokens
+		}
+		if i >= len(s) || (token != "*" && token != s[i]) {
+			return false
+		}
+	}
+	return len(f) == len(s)
+}
+
+func CreateWebhook(tx neo4j.Transaction, newWebhook Webhook) (webhook Webhook, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if newWebhook.Url == "" {
+		return Webhook{}, errors.New("Missing Webhook.Url")
+	}
+	params["url"] = newWebhook.Url
+
+	if newWebhook.Secret == "" {
+		return Webhook{}, errors.New("Missing Webhook.Secret")
+	}
+	params["secret"] = newWebhook.Secret
+
+	params["events"] = []string{}
+	if len(newWebhook.Events) > 0 {
+		params["events"] = newWebhook.Events
+	}
+	params["description"] = newWebhook.Description
+
+	cypher = fmt.Sprintf(`
+    // Create Webhook
+
+    CREATE (w:Webhook {
+      id:randomUUID(),
+      iat:datetime().epochSeconds,
+      url:$url,
+      secret:$secret,
+      events:$events,
+      description:$description
+    })
+
+    RETURN w
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Webhook{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+
+		if webhookNode != nil {
+			webhook = marshalNodeToWebhook(webhookNode.(neo4j.Node))
+		}
+	} else {
+		return Webhook{}, errors.New("Unable to create Webhook")
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+func FetchWebhooks(tx neo4j.Transaction, iFilterWebhooks []Webhook) (webhooks []Webhook, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var where1 string
+	if len(iFilterWebhooks) > 0 {
+		var filterWebhooks []string
+		for _, e := range iFilterWebhooks {
+			filterWebhooks = append(filterWebhooks, e.Id)
+		}
+
+		where1 = "and w.id in split($filterWebhooks, \",\")"
+		params["filterWebhooks"] = strings.Join(filterWebhooks, ",")
+	}
+
+	cypher = fmt.Sprintf(`
+    // Fetch webhooks
+
+    MATCH (w:Webhook)
+    WHERE 1=1 %s
+    RETURN w ORDER BY w.iat, w.id
+  `, where1)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+
+		if webhookNode != nil {
+			webhooks = append(webhooks, marshalNodeToWebhook(webhookNode.(neo4j.Node)))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// NOTE: Only fields set on webhookToUpdate are changed. Events is changed if not nil, an empty slice subscribes to all events.
+func UpdateWebhook(tx neo4j.Transaction, webhookToUpdate Webhook) (webhook Webhook, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if webhookToUpdate.Id == "" {
+		return Webhook{}, errors.New("Missing Webhook.Id")
+	}
+	params["id"] = webhookToUpdate.Id
+
+	var cypSet []string
+	if webhookToUpdate.Url != "" {
+		params["url"] = webhookToUpdate.Url
+		cypSet = append(cypSet, `w.url=$url`)
+	}
+	if webhookToUpdate.Events != nil {
+		params["events"] = webhookToUpdate.Events
+		cypSet = append(cypSet, `w.events=$events`)
+	}
+	if webhookToUpdate.Description != "" {
+		params["description"] = webhookToUpdate.Description
+		cypSet = append(cypSet, `w.description=$description`)
+	}
+
+	cypUpdate := ""
+	if len(cypSet) > 0 {
+		cypUpdate = `SET ` + strings.Join(cypSet, ", ")
+	}
+
+	cypher = fmt.Sprintf(`
+    // Update webhook
+
+    MATCH (w:Webhook {id:$id})
+    %s
+    RETURN w
+  `, cypUpdate)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Webhook{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+
+		if webhookNode != nil {
+			webhook = marshalNodeToWebhook(webhookNode.(neo4j.Node))
+		}
+	} else {
+		return Webhook{}, errors.New("Unable to update Webhook")
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook removes the webhook with its deliveries, pending ones included.
+func DeleteWebhook(tx neo4j.Transaction, webhookToDelete Webhook) (webhook Webhook, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if webhookToDelete.Id == "" {
+		return Webhook{}, errors.New("Missing Webhook.Id")
+	}
+	params["id"] = webhookToDelete.Id
+
+	cypher = fmt.Sprintf(`
+    // Delete webhook
+
+    MATCH (w:Webhook {id:$id})
+    OPTIONAL MATCH (w)<-[:TO]-(d:WebhookDelivery)
+    OPTIONAL MATCH (d)-[:ATTEMPTED]->(a:WebhookAttempt)
+    WITH w, collect(DISTINCT d) + collect(DISTINCT a) as deliveries
+    FOREACH (n IN deliveries | DETACH DELETE n)
+    DETACH DELETE w
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Webhook{}, err
+	}
+
+	// Check if we encountered any error during record streaming
+	if _, err = result.Consume(); err != nil {
+		return Webhook{}, err
+	}
+
+	webhook.Id = webhookToDelete.Id
+	return webhook, nil
+}
+
+// queueWebhookDeliveries creates a pending delivery of each event to every webhook subscribed to it. Called by QueueEvents, so deliveries are created if and only if the events are.
+func queueWebhookDeliveries(tx neo4j.Transaction, events []OutboxEvent) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	webhooks, err := FetchWebhooks(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []interface{}
+	var pairs []string
+	for _, e := range events {
+		for _, w := range webhooks {
+			if w.Matches(e.Subject) {
+				deliveries = append(deliveries, map[string]interface{}{"event": e.Id, "webhook": w.Id})
+				pairs = append(pairs, e.Id+"->"+w.Id)
+			}
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	params["deliveries"] = deliveries
+	params["status"] = WEBHOOK_DELIVERY_STATUS_PENDING
+
+	cypher = fmt.Sprintf(`
+    UNWIND $deliveries as n
+    MATCH (e:Event {id:n.event}), (w:Webhook {id:n.webhook})
+    CREATE (w)<-[:TO]-(d:WebhookDelivery {
+      id:randomUUID(), iat:datetime().epochSeconds,
+      status:$status, attempts:0, next_attempt_at:datetime().epochSeconds, delivered_at:0
+    })-[:DELIVERS]->(e)
+    RETURN count(d)
+  `)
+
+	result, err = tx.Run(cypher, params)
+	delete(params, "deliveries") // Not a type logCypher knows, the event->webhook pairs are logged instead
+	params["pairs"] = strings.Join(pairs, ",")
+	if err != nil {
+		return err
+	}
+
+	var queued int64
+	if result.Next() {
+		queued = result.Record().GetByIndex(0).(int64)
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	if queued != int64(len(deliveries)) {
+		return errors.New("Unable to queue WebhookDeliveries")
+	}
+
+	return nil
+}
+
+// ClaimPendingWebhookDeliveries returns up to limit deliveries due, oldest event first, and postpones their next attempt until leaseUntil,
+// so other instances do not pick them while this one is delivering.
+func ClaimPendingWebhookDeliveries(tx neo4j.Transaction, now int64, leaseUntil int64, limit int64) (deliveries []WebhookDelivery, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["status"] = WEBHOOK_DELIVERY_STATUS_PENDING
+	params["now"] = now
+	params["lease"] = leaseUntil
+	params["limit"] = limit
+
+	cypher = fmt.Sprintf(`
+    MATCH (w:Webhook)<-[:TO]-(d:WebhookDelivery {status:$status})-[:DELIVERS]->(e:Event) WHERE d.next_attempt_at <= $now
+    WITH d, w, e ORDER BY e.time, d.id LIMIT $limit
+    SET d.next_attempt_at = $lease
+    RETURN d, w, e ORDER BY e.time, d.id
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		deliveryNode := record.GetByIndex(0)
+
+		if deliveryNode != nil {
+			deliveries = append(deliveries, marshalNodeToWebhookDelivery(deliveryNode.(neo4j.Node), record.GetByIndex(1).(neo4j.Node), record.GetByIndex(2).(neo4j.Node)))
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records attempt and its outcome. Status is WEBHOOK_DELIVERY_STATUS_PENDING to retry at nextAttemptAt, or one of
+// WEBHOOK_DELIVERY_STATUS_DELIVERED and WEBHOOK_DELIVERY_STATUS_FAILED.
+func UpdateWebhookDelivery(tx neo4j.Transaction, deliveryToUpdate WebhookDelivery, status string, nextAttemptAt int64, attempt WebhookAttempt) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if deliveryToUpdate.Id == "" {
+		return errors.New("Missing WebhookDelivery.Id")
+	}
+	params["id"] = deliveryToUpdate.Id
+	params["status"] = status
+	params["next_attempt_at"] = nextAttemptAt
+	params["time"] = attempt.Time
+	params["status_code"] = attempt.StatusCode
+	params["error"] = attempt.Error
+	params["duration"] = attempt.Duration
+
+	cypDelivered := ""
+	switch status {
+	case WEBHOOK_DELIVERY_STATUS_PENDING, WEBHOOK_DELIVERY_STATUS_FAILED:
+	case WEBHOOK_DELIVERY_STATUS_DELIVERED:
+		cypDelivered = `SET d.delivered_at = datetime().epochSeconds`
+	default:
+		return errors.New("Unsupported WebhookDelivery status")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH (d:WebhookDelivery {id:$id})
+    SET d.status = $status, d.attempts = d.attempts + 1, d.next_attempt_at = $next_attempt_at
+    %s
+    CREATE (d)-[:ATTEMPTED]->(a:WebhookAttempt {attempt:d.attempts, time:$time, status_code:$status_code, error:$error, duration:$duration})
+    RETURN d
+  `, cypDelivered)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	if !result.Next() {
+		return errors.New("Unable to update WebhookDelivery")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FetchWebhookDeliveries returns up to limit deliveries with their attempts, newest event first. Empty ids, webhookId and status match any.
+func FetchWebhookDeliveries(tx neo4j.Transaction, ids []string, webhookId string, status string, limit int64) (deliveries []WebhookDelivery, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var where []string
+	if len(ids) > 0 {
+		where = append(where, `d.id in split($ids, ",")`)
+		params["ids"] = strings.Join(ids, ",")
+	}
+	if webhookId != "" {
+		where = append(where, `w.id = $webhook`)
+		params["webhook"] = webhookId
+	}
+	if status != "" {
+		where = append(where, `d.status = $status`)
+		params["status"] = status
+	}
+	params["limit"] = limit
+
+	cypWhere := ""
+	if len(where) > 0 {
+		cypWhere = `WHERE ` + strings.Join(where, " AND ")
+	}
+
+	cypher = fmt.Sprintf(`
+    MATCH (w:Webhook)<-[:TO]-(d:WebhookDelivery)-[:DELIVERS]->(e:Event)
+    %s
+    WITH d, w, e ORDER BY e.time DESC, d.id LIMIT $limit
+    OPTIONAL MATCH (d)-[:ATTEMPTED]->(a:WebhookAttempt)
+    WITH d, w, e, a ORDER BY a.time, a.attempt
+    RETURN d, w, e, collect(a) ORDER BY e.time DESC, d.id
+  `, cypWhere)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		deliveryNode := record.GetByIndex(0)
+
+		if deliveryNode != nil {
+			delivery := marshalNodeToWebhookDelivery(deliveryNode.(neo4j.Node), record.GetByIndex(1).(neo4j.Node), record.GetByIndex(2).(neo4j.Node))
+			for _, a := range record.GetByIndex(3).([]interface{}) {
+				delivery.History = append(delivery.History, marshalNodeToWebhookAttempt(a.(neo4j.Node)))
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RedeliverWebhookDelivery makes the delivery pending and due now, with a fresh set of attempts. The history of earlier attempts is kept.
+func RedeliverWebhookDelivery(tx neo4j.Transaction, deliveryToRedeliver WebhookDelivery) (delivery WebhookDelivery, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if deliveryToRedeliver.Id == "" {
+		return WebhookDelivery{}, errors.New("Missing WebhookDelivery.Id")
+	}
+	params["id"] = deliveryToRedeliver.Id
+	params["status"] = WEBHOOK_DELIVERY_STATUS_PENDING
+
+	cypher = fmt.Sprintf(`
+    MATCH (w:Webhook)<-[:TO]-(d:WebhookDelivery {id:$id})-[:DELIVERS]->(e:Event)
+    SET d.status = $status, d.attempts = 0, d.next_attempt_at = datetime().epochSeconds
+    RETURN d, w, e
+  `)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		delivery = marshalNodeToWebhookDelivery(record.GetByIndex(0).(neo4j.Node), record.GetByIndex(1).(neo4j.Node), record.GetByIndex(2).(neo4j.Node))
+	} else {
+		return WebhookDelivery{}, errors.New("Unable to redeliver WebhookDelivery")
+	}
+
+	logCypher(cypher, params)
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+// SignWebhook returns the signature header of a request sent at timestamp, t=<timestamp>,v1=<hex of HMAC-SHA256 of "<timestamp>.<body>" keyed by secret>.
+// The timestamp is signed along with the body so receivers can reject old requests replayed by someone else.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// NewWebhookClient returns the client webhooks are delivered with. Redirects are not followed but fail the attempt, so a signed event is only ever sent to the url of the webhook.
+// Unless allowPrivateAddresses is set, connections to private, loopback and link-local addresses are refused. The check is made on the address dialed,
+// so a host name resolving to an internal address is refused as well. No proxy is used then, as the proxy would be the address dialed.
+func NewWebhookClient(timeout time.Duration, allowPrivateAddresses bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivateAddresses {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   refuseInternalAddresses,
+		}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: tracing.NewTransport(transport),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var internalNetworks = []string{
+	"10.0.0.0/8",
+	"100.64.0.0/10", // Shared address space, RFC 6598
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7", // Unique local addresses, RFC 4193
+}
+
+func refuseInternalAddresses(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("Webhook address %s is not an ip", host)
+	}
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("Webhook address %s is not allowed", ip)
+	}
+	for _, cidr := range internalNetworks {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		if ipNet.Contains(ip) {
+			return fmt.Errorf("Webhook address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+// DeliverWebhook posts the event of the delivery to the webhook, signed with secret, which is the decrypted secret of the webhook.
+// Any response but a 2xx is an error. The attempt is returned either way, to be recorded by UpdateWebhookDelivery.
+func DeliverWebhook(ctx context.Context, client *http.Client, d WebhookDelivery, secret string) (attempt WebhookAttempt, err error) {
+	body := []byte(d.Event.Body)
+
+	// The span continues the trace of the request that caused the event, not that of the job.
+	carrier := &nats.Msg{Header: nats.Header{}}
+	for k, v := range d.Event.Trace {
+		carrier.Header.Set(k, v)
+	}
+	ctx, span := tracing.Start(tracing.ExtractNats(ctx, carrier), "webhook.deliver", attribute.String("webhook.id", d.Webhook.Id), attribute.String("webhook.delivery_id", d.Id), attribute.String("event.id", d.Event.Id))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	attempt.Time = start.Unix()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.Webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	req.Header.Set("Content-Type", EVENT_CONTENT_TYPE)
+	req.Header.Set(WEBHOOK_HEADER_WEBHOOK, d.Webhook.Id)
+	req.Header.Set(WEBHOOK_HEADER_DELIVERY, d.Id)
+	req.Header.Set(WEBHOOK_HEADER_EVENT, d.Event.Id)
+	req.Header.Set(WEBHOOK_HEADER_SIGNATURE, SignWebhook(secret, attempt.Time, body))
+
+	res, err := client.Do(req)
+	attempt.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, WEBHOOK_MAX_RESPONSE_BYTES)) // Lets the connection be reused
+
+	attempt.StatusCode = int64(res.StatusCode)
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = fmt.Errorf("Webhook responded %s", res.Status)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+
+	return attempt, nil
+}
@@ -0,0 +1,149 @@
+package idp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opensentry/idp/client"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	tests := []struct {
+		events   []string
+		subject  string
+		expected bool
+	}{
+		{nil, EVENT_HUMAN_CREATED, true},
+		{[]string{EVENT_HUMAN_CREATED}, EVENT_HUMAN_CREATED, true},
+		{[]string{EVENT_HUMAN_CREATED}, EVENT_HUMAN_UPDATED, false},
+		{[]string{"idp.human.*"}, EVENT_HUMAN_CREATED, true},
+		{[]string{"idp.human.*"}, EVENT_HUMAN_PASSWORD_CHANGED, false},
+		{[]string{"idp.human.>"}, EVENT_HUMAN_PASSWORD_CHANGED, true},
+		{[]string{"idp.human.>"}, "idp.human", false},
+		{[]string{"idp.*.created"}, EVENT_CLIENT_CREATED, true},
+		{[]string{"idp.client.>", EVENT_ROLE_CREATED}, EVENT_ROLE_CREATED, true},
+		{[]string{"idp.human.created.v1"}, EVENT_HUMAN_CREATED, false},
+	}
+	for _, test := range tests {
+		if m := (Webhook{Events: test.events}).Matches(test.subject); m != test.expected {
+			t.Errorf("Expected %v matching %s to be %v", test.events, test.subject, test.expected)
+		}
+	}
+}
+
+func testWebhookDelivery(url string) WebhookDelivery {
+	return WebhookDelivery{
+		Id:      "delivery-id",
+		Webhook: Webhook{Id: "webhook-id", Url: url},
+		Event:   OutboxEvent{Id: "event-id", Subject: EVENT_HUMAN_CREATED, Type: EVENT_HUMAN_CREATED + ".v1", Body: `{"id":"event-id"}`},
+	}
+}
+
+func TestDeliverWebhookSigned(t *testing.T) {
+	received := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if string(body) != `{"id":"event-id"}` {
+			t.Errorf("Expected the event as body, got %s", body)
+		}
+		if r.Header.Get("Content-Type") != EVENT_CONTENT_TYPE || r.Header.Get(WEBHOOK_HEADER_EVENT) != "event-id" || r.Header.Get(WEBHOOK_HEADER_DELIVERY) != "delivery-id" || r.Header.Get(WEBHOOK_HEADER_WEBHOOK) != "webhook-id" {
+			t.Errorf("Expected event headers, got %v", r.Header)
+		}
+
+		signature := r.Header.Get(WEBHOOK_HEADER_SIGNATURE)
+		if err := client.VerifyWebhookSignature("secret", signature, body, time.Now(), 5*time.Minute); err != nil {
+			t.Errorf("Expected valid signature, got %s for %s", err, signature)
+		}
+		if err := client.VerifyWebhookSignature("other secret", signature, body, time.Now(), 5*time.Minute); err == nil {
+			t.Error("Expected signature to fail with another secret")
+		}
+		if err := client.VerifyWebhookSignature("secret", signature, body, time.Now().Add(time.Hour), 5*time.Minute); err == nil {
+			t.Error("Expected signature to fail outside tolerance")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	attempt, err := DeliverWebhook(context.Background(), NewWebhookClient(time.Second, true), testWebhookDelivery(srv.URL), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != 1 || attempt.StatusCode != http.StatusNoContent || attempt.Error != "" {
+		t.Errorf("Expected one delivery recorded as 204, got %d with %+v", received, attempt)
+	}
+}
+
+func TestDeliverWebhookFails(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	attempt, err := DeliverWebhook(context.Background(), NewWebhookClient(time.Second, true), testWebhookDelivery(srv.URL), "secret")
+	if err == nil || attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+		t.Errorf("Expected a 500 to fail the attempt, got %+v", attempt)
+	}
+
+	attempt, err = DeliverWebhook(context.Background(), NewWebhookClient(time.Second, true), testWebhookDelivery(srv.URL+"/redirect"), "secret")
+	if err == nil || attempt.StatusCode != http.StatusTemporaryRedirect || redirected {
+		t.Errorf("Expected a redirect to fail the attempt without following it, got %+v", attempt)
+	}
+
+	srv.Close()
+	attempt, err = DeliverWebhook(context.Background(), NewWebhookClient(time.Second, true), testWebhookDelivery(srv.URL), "secret")
+	if err == nil || attempt.StatusCode != 0 || attempt.Error == "" {
+		t.Errorf("Expected an unreachable webhook to fail the attempt, got %+v", attempt)
+	}
+}
+
+func TestDeliverWebhookRefusesInternalAddresses(t *testing.T) {
+	received := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer srv.Close()
+
+	attempt, err := DeliverWebhook(context.Background(), NewWebhookClient(time.Second, false), testWebhookDelivery(srv.URL), "secret")
+	if err == nil || received || attempt.Error == "" {
+		t.Errorf("Expected a loopback webhook to be refused, got %+v", attempt)
+	}
+
+	tests := map[string]bool{
+		"127.0.0.1:443":      false,
+		"[::1]:443":          false,
+		"10.1.2.3:443":       false,
+		"172.16.0.1:443":     false,
+		"192.168.1.1:443":    false,
+		"100.64.0.1:443":     false,
+		"169.254.169.254:80": false,
+		"[fe80::1]:443":      false,
+		"[fd00::1]:443":      false,
+		"0.0.0.0:443":        false,
+		"203.0.113.10:443":   true,
+		"[2001:db8::1]:443":  true,
+		"172.32.0.1:443":     true,
+	}
+	for address, allowed := range tests {
+		if err := refuseInternalAddresses("tcp", address, nil); (err == nil) != allowed {
+			t.Errorf("Expected %s to be allowed %v, got %v", address, allowed, err)
+		}
+	}
+}
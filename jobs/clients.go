@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"time"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+)
+
+// Ends the grace period of rotated client secrets once it has passed: the new secret is pushed to Hydra and the secondary secret is removed.
+// Runs every interval until ctx is done.
+func CleanupExpiredClientSecrets(ctx context.Context, env *app.Environment, log *logrus.Entry, interval time.Duration) {
+	log = log.WithFields(logrus.Fields{
+		"func": "CleanupExpiredClientSecrets",
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().Unix()
+
+		clients, err := fetchExpiredClientSecrets(env, now)
+		if err != nil {
+			log.Debug(err.Error())
+			continue
+		}
+
+		for _, c := range clients {
+			if ctx.Err() != nil {
+				break // Left for the next run
+			}
+
+			swapped, err := swapClientSecret(ctx, env, c, now)
+			if err != nil {
+				// Hydra keeps the secondary secret, retried next run
+				log.WithFields(logrus.Fields{"id": c.Id, "error": err.Error()}).Debug("Failed to push rotated client secret to Hydra")
+				continue
+			}
+			if swapped {
+				log.WithFields(logrus.Fields{"id": c.Id}).Debug("Rotated client secret pushed to Hydra, secondary secret removed")
+			}
+		}
+	}
+}
+
+func fetchExpiredClientSecrets(env *app.Environment, expiresBefore int64) (clients []idp.Client, err error) {
+	session, tx, err := idp.BeginReadTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	clients, err = idp.FetchExpiredClientSecrets(tx, expiresBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return clients, nil
+}
+
+// Removes the secondary secret and pushes the secret to Hydra in one transaction, so the secondary secret is only removed once Hydra has the new one.
+// Returns false if the client was rotated again or cleaned up by another instance in the meantime.
+func swapClientSecret(ctx context.Context, env *app.Environment, c idp.Client, expiresBefore int64) (swapped bool, err error) {
+	keys := config.GetStringSlice("crypto.keys.clients")
+	if len(keys) <= 0 {
+		return false, errMissingClientCryptoKey
+	}
+	cryptoKey := keys[0]
+
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	updatedClient, err := idp.DeleteExpiredClientSecret(tx, c, expiresBefore)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if updatedClient.Id == "" {
+		tx.Rollback()
+		return false, nil
+	}
+
+	secret, err := idp.Decrypt(updatedClient.Secret, cryptoKey)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+	hydraClient, err := idp.ReadHydraClient(ctx, url, updatedClient.Id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	hydraClient.Secret = secret
+	if _, err = idp.UpdateHydraClient(ctx, url, updatedClient.Id, hydraClient); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// If the commit fails Hydra already has the new secret, and pushing it again next run is harmless.
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"time"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+)
+
+// EmailRetryPolicy controls delivery from the outbox. Failed attempts are retried after BaseDelay, doubling up to MaxDelay, until MaxAttempts is reached and the mail is dead lettered.
+type EmailRetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int64
+	BatchSize   int64
+	Lease       time.Duration // How long a claimed mail is hidden from other instances while being sent
+	Retention   time.Duration // How long sent and dead lettered mails are kept after they expire
+}
+
+// Backoff returns the delay before the next attempt, after attempts failed attempts. Up to a fifth is added as jitter, so mails failing together do not retry together.
+func (p EmailRetryPolicy) Backoff(attempts int64) time.Duration {
+	return backoff(p.BaseDelay, p.MaxDelay, attempts)
+}
+
+func backoff(baseDelay time.Duration, maxDelay time.Duration, attempts int64) time.Duration {
+	delay := baseDelay
+	for i := int64(1); i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	}
+	return delay
+}
+
+// Delivers the mails queued in the outbox by idp.QueueEmail. Runs every interval until ctx is done.
+func DeliverEmails(ctx context.Context, env *app.Environment, log *logrus.Entry, interval time.Duration, policy EmailRetryPolicy) {
+	log = log.WithFields(logrus.Fields{
+		"func": "DeliverEmails",
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while there is a full batch, so a backlog is not limited to one batch per interval.
+		for {
+			claimed, err := deliverEmails(ctx, env, log, policy)
+			if err != nil {
+				log.Debug(err.Error())
+				break
+			}
+			if claimed < policy.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		deleted, err := deleteExpiredEmails(env, time.Now().Add(-policy.Retention).Unix())
+		if err != nil {
+			log.Debug(err.Error())
+			continue
+		}
+		if deleted > 0 {
+			log.WithFields(logrus.Fields{"deleted": deleted}).Debug("Expired emails removed from outbox")
+		}
+	}
+}
+
+func deliverEmails(ctx context.Context, env *app.Environment, log *logrus.Entry, policy EmailRetryPolicy) (claimed int64, err error) {
+	now := time.Now()
+
+	emails, err := claimPendingEmails(env, now.Unix(), now.Add(policy.Lease).Unix(), policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range emails {
+		if ctx.Err() != nil {
+			break // Left to be retried when the lease runs out
+		}
+
+		log := log.WithFields(logrus.Fields{"id": m.Id, "attempt": m.Attempts + 1})
+
+		status := idp.EMAIL_STATUS_SENT
+		var nextAttemptAt int64
+
+		if m.ExpiresAt <= time.Now().Unix() {
+			status = idp.EMAIL_STATUS_DEAD
+			log.Debug("Email expired before it could be delivered")
+		} else if err := idp.DeliverEmail(ctx, env.Mailer, m); err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Email delivery failed")
+
+			status = idp.EMAIL_STATUS_PENDING
+			nextAttemptAt = time.Now().Add(policy.Backoff(m.Attempts + 1)).Unix()
+			if m.Attempts+1 >= policy.MaxAttempts || nextAttemptAt >= m.ExpiresAt {
+				status = idp.EMAIL_STATUS_DEAD
+			}
+		}
+
+		if status == idp.EMAIL_STATUS_DEAD {
+			metrics.EmailsDeadLetteredTotal.Inc()
+		}
+
+		// A mail sent but not recorded is sent again once its lease runs out, as delivery is at least once.
+		if err := updateEmailDelivery(env, m, status, nextAttemptAt); err != nil {
+			log.Debug(err.Error())
+		}
+	}
+
+	return int64(len(emails)), nil
+}
+
+func claimPendingEmails(env *app.Environment, now int64, leaseUntil int64, limit int64) (emails []idp.Email, err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	emails, err = idp.ClaimPendingEmails(tx, now, leaseUntil, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+func updateEmailDelivery(env *app.Environment, m idp.Email, status string, nextAttemptAt int64) (err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	_, err = idp.UpdateEmailDelivery(tx, m, status, nextAttemptAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteExpiredEmails(env *app.Environment, expiresBefore int64) (deleted int64, err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	deleted, err = idp.DeleteExpiredEmails(tx, expiresBefore)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
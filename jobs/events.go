@@ -0,0 +1,287 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/gateway/idp"
+)
+
+var errEventNotFound = errors.New("Event not found in outbox")
+
+// EventRelayPolicy controls publishing from the event outbox. Events are never given up on, a failed publish is retried after BaseDelay, doubling up to MaxDelay.
+type EventRelayPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	BatchSize int64
+	Lease     time.Duration // How long a claimed event is hidden from other instances while being published
+	Retention time.Duration // How long published events are kept for replay
+}
+
+// Backoff returns the delay before the next attempt, after attempts failed attempts.
+func (p EventRelayPolicy) Backoff(attempts int64) time.Duration {
+	return backoff(p.BaseDelay, p.MaxDelay, attempts)
+}
+
+// Publishes the events queued in the outbox by idp.QueueEvents to JetStream. Runs every interval until ctx is done.
+func RelayEvents(ctx context.Context, env *app.Environment, log *logrus.Entry, interval time.Duration, policy EventRelayPolicy) {
+	log = log.WithFields(logrus.Fields{
+		"func": "RelayEvents",
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while there is a full batch, so a backlog is not limited to one batch per interval.
+		for {
+			claimed, err := relayEvents(ctx, env, log, policy)
+			if err != nil {
+				log.Debug(err.Error())
+				break
+			}
+			if claimed < policy.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		deleted, err := deletePublishedEvents(env, time.Now().Add(-policy.Retention).Unix())
+		if err != nil {
+			log.Debug(err.Error())
+			continue
+		}
+		if deleted > 0 {
+			log.WithFields(logrus.Fields{"deleted": deleted}).Debug("Published events removed from outbox")
+		}
+	}
+}
+
+func relayEvents(ctx context.Context, env *app.Environment, log *logrus.Entry, policy EventRelayPolicy) (claimed int64, err error) {
+	now := time.Now()
+
+	events, err := claimPendingEvents(env, now.Unix(), now.Add(policy.Lease).Unix(), policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range events {
+		if ctx.Err() != nil {
+			break // Left to be published when the lease runs out
+		}
+
+		log := log.WithFields(logrus.Fields{"id": e.Id, "type": e.Type, "attempt": e.Attempts + 1})
+
+		ack, err := idp.PublishEvent(ctx, env.JetStream, e, e.Id)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err.Error()}).Debug("Event publish failed")
+
+			// The rest of the batch waits for this one, so events are not published out of order while the stream is unavailable.
+			nextAttemptAt := time.Now().Add(policy.Backoff(e.Attempts + 1)).Unix()
+			if err := updateEventDelivery(env, e, idp.EVENT_STATUS_PENDING, nextAttemptAt); err != nil {
+				log.Debug(err.Error())
+			}
+			if err := releaseEvents(env, events[i+1:], nextAttemptAt); err != nil {
+				log.Debug(err.Error())
+			}
+			return int64(len(events)), err
+		}
+
+		if ack.Duplicate {
+			log.Debug("Event already in stream")
+		}
+
+		// An event published but not recorded is published again once its lease runs out, JetStream drops it if still within the duplicate window.
+		if err := updateEventDelivery(env, e, idp.EVENT_STATUS_PUBLISHED, 0); err != nil {
+			log.Debug(err.Error())
+		}
+	}
+
+	return int64(len(events)), nil
+}
+
+// ReplayEvents publishes the events in the outbox again, starting at from, which is an RFC 3339 time or the id of an event.
+// Only published events are replayed, pending events are left to RelayEvents. Stops at the first event that can not be published.
+// The Nats-Msg-Id of a replay is the event id suffixed with an id of the replay, so the stream does not drop events it got from the relay
+// within its duplicate window. Events the stream drops anyway are not counted as replayed.
+func ReplayEvents(ctx context.Context, env *app.Environment, log *logrus.Entry, from string, batchSize int64) (replayed int64, err error) {
+	replayId := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	log = log.WithFields(logrus.Fields{
+		"func":   "ReplayEvents",
+		"from":   from,
+		"replay": replayId,
+	})
+
+	var afterTime int64
+	var afterId string // Empty to include the events at afterTime
+	var duplicates int64
+
+	t, err := time.Parse(time.RFC3339Nano, from)
+	if err == nil {
+		afterTime = t.UnixNano()
+	} else {
+		events, err := fetchOutboxEvents(env, []string{from})
+		if err != nil {
+			return 0, err
+		}
+		if len(events) <= 0 {
+			return 0, errEventNotFound
+		}
+		afterTime = events[0].Time
+	}
+
+	for ctx.Err() == nil {
+		events, err := fetchPublishedEvents(env, afterTime, afterId, batchSize)
+		if err != nil {
+			return replayed, err
+		}
+
+		for _, e := range events {
+			ack, err := idp.PublishEvent(ctx, env.JetStream, e, e.Id+"-replay-"+replayId)
+			if err != nil {
+				log.WithFields(logrus.Fields{"id": e.Id, "replayed": replayed, "duplicates": duplicates}).Info("Replay stopped")
+				return replayed, err
+			}
+			if ack.Duplicate {
+				log.WithFields(logrus.Fields{"id": e.Id}).Info("Event dropped by stream as duplicate")
+				duplicates++
+			} else {
+				replayed++
+			}
+			afterTime, afterId = e.Time, e.Id
+		}
+
+		if int64(len(events)) < batchSize {
+			break
+		}
+	}
+
+	log.WithFields(logrus.Fields{"replayed": replayed, "duplicates": duplicates}).Info("Replay done")
+	return replayed, ctx.Err()
+}
+
+func claimPendingEvents(env *app.Environment, now int64, leaseUntil int64, limit int64) (events []idp.OutboxEvent, err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	events, err = idp.ClaimPendingEvents(tx, now, leaseUntil, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func updateEventDelivery(env *app.Environment, e idp.OutboxEvent, status string, nextAttemptAt int64) (err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	_, err = idp.UpdateEventDelivery(tx, e, status, nextAttemptAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func releaseEvents(env *app.Environment, events []idp.OutboxEvent, nextAttemptAt int64) (err error) {
+	if len(events) == 0 {
+		return nil
+	}
+
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	err = idp.ReleaseEvents(tx, events, nextAttemptAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deletePublishedEvents(env *app.Environment, publishedBefore int64) (deleted int64, err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	deleted, err = idp.DeletePublishedEvents(tx, publishedBefore)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func fetchOutboxEvents(env *app.Environment, ids []string) (events []idp.OutboxEvent, err error) {
+	session, tx, err := idp.BeginReadTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	events, err = idp.FetchOutboxEvents(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return events, nil
+}
+
+func fetchPublishedEvents(env *app.Environment, afterTime int64, afterId string, limit int64) (events []idp.OutboxEvent, err error) {
+	session, tx, err := idp.BeginReadTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	events, err = idp.FetchPublishedEvents(tx, afterTime, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return events, nil
+}
@@ -0,0 +1,336 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+
+	hydra "github.com/charmixer/hydra/client"
+)
+
+var errMissingClientCryptoKey = errors.New("Missing config crypto.keys.clients")
+
+type ClientDifferenceType string
+
+const (
+	ClientMissingInHydra  ClientDifferenceType = "missing"  // Client exists in idp but not in hydra
+	ClientOrphanedInHydra ClientDifferenceType = "orphaned" // Client exists in hydra but not in idp
+	ClientMismatch        ClientDifferenceType = "mismatch" // Client exists in both, but hydra differs from idp
+)
+
+type ClientDifference struct {
+	Id       string
+	Type     ClientDifferenceType
+	Fields   []string // Names of the mismatched fields
+	Repaired bool
+	Skipped  string // Why the difference was only reported
+	Error    error
+}
+
+type ReconcileOptions struct {
+	DryRun        bool          // Only report the differences
+	DeleteOrphans bool          // Delete clients in hydra unknown to idp, otherwise orphans are only reported
+	MinAge        time.Duration // Clients created or changed more recently are only reported, they may be in the middle of being written to both
+}
+
+// Runs ReconcileClients every interval until ctx is done.
+func ReconcileClientsPeriodically(ctx context.Context, env *app.Environment, log *logrus.Entry, interval time.Duration, options ReconcileOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ReconcileClients(ctx, env, log, options)
+		}
+	}
+}
+
+// Compares the clients in idp with the clients in hydra and, unless options.DryRun is set, repairs hydra so it matches idp.
+// Idp is the source of truth: missing clients are created and mismatched clients are updated in hydra. Orphaned clients are only deleted with options.DeleteOrphans.
+// Clients listed in config client.reconcile.ignore and the idp's own oauth2 client are never touched.
+func ReconcileClients(ctx context.Context, env *app.Environment, log *logrus.Entry, options ReconcileOptions) (differences []ClientDifference, err error) {
+	log = log.WithFields(logrus.Fields{
+		"func":           "ReconcileClients",
+		"dry_run":        options.DryRun,
+		"delete_orphans": options.DeleteOrphans,
+	})
+
+	keys := config.GetStringSlice("crypto.keys.clients")
+	if len(keys) <= 0 {
+		log.WithFields(logrus.Fields{"key": "crypto.keys.clients"}).Debug("Missing config")
+		return nil, errMissingClientCryptoKey
+	}
+	cryptoKey := keys[0]
+
+	ignore := map[string]bool{config.GetString("oauth2.client.id"): true}
+	for _, id := range config.GetStringSlice("client.reconcile.ignore") {
+		ignore[id] = true
+	}
+
+	// Hydra is listed before idp is read. Clients are written to idp first, so a client created in between is seen in idp and not mistaken for an orphan.
+	url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+	hydraClients, err := idp.ListHydraClients(ctx, url)
+	if err != nil {
+		log.Debug(err.Error())
+		return nil, err
+	}
+
+	idpClients, err := fetchClients(env, nil)
+	if err != nil {
+		log.Debug(err.Error())
+		return nil, err
+	}
+
+	differences = diffClients(idpClients, hydraClients, ignore)
+
+	idpClientMap := make(map[string]idp.Client)
+	for _, c := range idpClients {
+		idpClientMap[c.Id] = c
+	}
+	hydraClientMap := make(map[string]idp.HydraClient)
+	for _, h := range hydraClients {
+		hydraClientMap[h.Id] = h
+	}
+
+	now := time.Now()
+	for i, d := range differences {
+		d.Skipped = skipRepair(d, idpClientMap[d.Id], hydraClientMap[d.Id], options, now)
+
+		if d.Skipped == "" {
+			d = repairClient(ctx, env, url, d, ignore, cryptoKey)
+		}
+		differences[i] = d
+
+		fields := logrus.Fields{"id": d.Id, "type": d.Type, "fields": d.Fields, "repaired": d.Repaired}
+		if d.Skipped != "" {
+			fields["skipped"] = d.Skipped
+		}
+		if d.Error != nil {
+			fields["error"] = d.Error.Error()
+		}
+		log.WithFields(fields).Info("Client differs between idp and hydra")
+	}
+
+	log.WithFields(logrus.Fields{"differences": len(differences)}).Info("Client reconciliation done")
+	return differences, nil
+}
+
+// Returns why a difference must only be reported, empty if it may be repaired.
+func skipRepair(d ClientDifference, c idp.Client, h idp.HydraClient, options ReconcileOptions, now time.Time) string {
+	if options.DryRun {
+		return "dry run"
+	}
+
+	var changedAt time.Time
+	switch d.Type {
+	case ClientMissingInHydra:
+		changedAt = time.Unix(c.IssuedAt, 0)
+	case ClientOrphanedInHydra:
+		if !options.DeleteOrphans {
+			return "orphan deletion not enabled"
+		}
+		changedAt = h.CreatedAt()
+	case ClientMismatch:
+		changedAt = h.UpdatedAt()
+	}
+
+	// Hydra not telling when it changed the client is not taken as old enough.
+	if changedAt.IsZero() && d.Type != ClientMissingInHydra {
+		return "unknown age"
+	}
+	if now.Sub(changedAt) < options.MinAge {
+		return "too recent"
+	}
+	return ""
+}
+
+// Reads the client again from idp, and from hydra unless missing there, and repairs it only if it still differs the same way.
+func repairClient(ctx context.Context, env *app.Environment, url string, d ClientDifference, ignore map[string]bool, cryptoKey string) ClientDifference {
+	idpClients, err := fetchClients(env, []idp.Client{{Identity: idp.Identity{Id: d.Id}}})
+	if err != nil {
+		d.Error = err
+		return d
+	}
+
+	var hydraClients []idp.HydraClient
+	if d.Type != ClientMissingInHydra {
+		h, err := idp.ReadHydraClient(ctx, url, d.Id)
+		if err != nil {
+			d.Error = err
+			return d
+		}
+		hydraClients = append(hydraClients, h)
+	}
+
+	var current *ClientDifference
+	for _, r := range diffClients(idpClients, hydraClients, ignore) {
+		if r.Id == d.Id && r.Type == d.Type {
+			current = &r
+			break
+		}
+	}
+	if current == nil {
+		d.Skipped = "changed while reconciling"
+		return d
+	}
+	d.Fields = current.Fields
+
+	switch d.Type {
+	case ClientMissingInHydra:
+		d.Error = createMissingHydraClient(ctx, url, idpClients[0], cryptoKey)
+	case ClientOrphanedInHydra:
+		d.Error = idp.DeleteHydraClient(ctx, url, d.Id)
+	case ClientMismatch:
+		_, d.Error = idp.UpdateHydraClient(ctx, url, d.Id, overlayClient(hydraClients[0], idpClients[0]))
+	}
+	d.Repaired = d.Error == nil
+	return d
+}
+
+// Fetches the given clients from idp, all clients if none are given.
+func fetchClients(env *app.Environment, iClients []idp.Client) (clients []idp.Client, err error) {
+	session, tx, err := idp.BeginReadTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	clients, err = idp.FetchClients(tx, nil, iClients)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Commit()
+	return clients, nil
+}
+
+func diffClients(idpClients []idp.Client, hydraClients []idp.HydraClient, ignore map[string]bool) (differences []ClientDifference) {
+	hydraClientMap := make(map[string]idp.HydraClient)
+	for _, h := range hydraClients {
+		hydraClientMap[h.Id] = h
+	}
+
+	idpClientMap := make(map[string]idp.Client)
+	for _, c := range idpClients {
+		idpClientMap[c.Id] = c
+		if ignore[c.Id] {
+			continue
+		}
+
+		h, exists := hydraClientMap[c.Id]
+		if !exists {
+			differences = append(differences, ClientDifference{Id: c.Id, Type: ClientMissingInHydra})
+			continue
+		}
+
+		fields := mismatchedFields(c, h)
+		if len(fields) > 0 {
+			differences = append(differences, ClientDifference{Id: c.Id, Type: ClientMismatch, Fields: fields})
+		}
+	}
+
+	for _, h := range hydraClients {
+		if ignore[h.Id] {
+			continue
+		}
+		if _, exists := idpClientMap[h.Id]; !exists {
+			differences = append(differences, ClientDifference{Id: h.Id, Type: ClientOrphanedInHydra})
+		}
+	}
+
+	return differences
+}
+
+func mismatchedFields(c idp.Client, h idp.HydraClient) (fields []string) {
+	if !equalStrings(c.RedirectUris, h.RedirectUris) {
+		fields = append(fields, "redirect_uris")
+	}
+	if !equalStrings(c.PostLogoutRedirectUris, h.PostLogoutRedirectUris) {
+		fields = append(fields, "post_logout_redirect_uris")
+	}
+	if !equalStrings(c.GrantTypes, h.GrantTypes) {
+		fields = append(fields, "grant_types")
+	}
+	if !equalStrings(c.ResponseTypes, h.ResponseTypes) {
+		fields = append(fields, "response_types")
+	}
+	if !equalStrings(c.Audiences, h.Audience) {
+		fields = append(fields, "audience")
+	}
+	// Hydra defaults an empty method to client_secret_basic
+	if c.TokenEndpointAuthMethod != "" && c.TokenEndpointAuthMethod != h.TokenEndpointAuthMethod {
+		fields = append(fields, "token_endpoint_auth_method")
+	}
+	if c.JwksUri != h.JwksUri {
+		fields = append(fields, "jwks_uri")
+	}
+	return fields
+}
+
+// Order does not matter and nil equals empty.
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	if len(a) == 0 {
+		return true
+	}
+
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+	return reflect.DeepEqual(sa, sb)
+}
+
+// Hydra replaces the whole client on update, so the idp fields are laid over the client read from hydra to keep scope and the like.
+func overlayClient(h idp.HydraClient, c idp.Client) idp.HydraClient {
+	h.Name = c.Name
+	h.RedirectUris = c.RedirectUris
+	h.PostLogoutRedirectUris = c.PostLogoutRedirectUris
+	h.GrantTypes = c.GrantTypes
+	h.ResponseTypes = c.ResponseTypes
+	h.Audience = c.Audiences
+	h.JwksUri = c.JwksUri
+	if c.TokenEndpointAuthMethod != "" {
+		h.TokenEndpointAuthMethod = c.TokenEndpointAuthMethod
+	}
+	if c.Jwks != "" {
+		h.Jwks = json.RawMessage(c.Jwks)
+	}
+	return h
+}
+
+func createMissingHydraClient(ctx context.Context, url string, c idp.Client, cryptoKey string) error {
+	// Within the grace period of a rotation Hydra holds the secondary secret, see CleanupExpiredClientSecrets
+	encryptedSecret := c.Secret
+	if c.SecondarySecret != "" {
+		encryptedSecret = c.SecondarySecret
+	}
+
+	var secret string
+	if encryptedSecret != "" {
+		var err error
+		secret, err = idp.Decrypt(encryptedSecret, cryptoKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	h := overlayClient(idp.HydraClient{Client: hydra.Client{Id: c.Id, Secret: secret}}, c)
+	_, err := idp.CreateHydraClient(ctx, url, h)
+	return err
+}
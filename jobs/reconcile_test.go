@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opensentry/idp/gateway/idp"
+)
+
+func testHydraClient(t *testing.T, createdAt time.Time) (h idp.HydraClient) {
+	data, _ := json.Marshal(map[string]interface{}{"client_id": "id", "created_at": createdAt, "updated_at": createdAt})
+	if err := json.Unmarshal(data, &h); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestSkipRepair(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	options := ReconcileOptions{MinAge: 5 * time.Minute}
+	deleteOrphans := ReconcileOptions{MinAge: 5 * time.Minute, DeleteOrphans: true}
+
+	tests := []struct {
+		name     string
+		d        ClientDifference
+		c        idp.Client
+		h        idp.HydraClient
+		options  ReconcileOptions
+		expected string
+	}{
+		{"dry run", ClientDifference{Type: ClientMissingInHydra}, idp.Client{}, idp.HydraClient{}, ReconcileOptions{DryRun: true}, "dry run"},
+		{"old missing", ClientDifference{Type: ClientMissingInHydra}, idp.Client{Identity: idp.Identity{IssuedAt: old.Unix()}}, idp.HydraClient{}, options, ""},
+		{"new missing", ClientDifference{Type: ClientMissingInHydra}, idp.Client{Identity: idp.Identity{IssuedAt: now.Unix()}}, idp.HydraClient{}, options, "too recent"},
+		{"orphan by default", ClientDifference{Type: ClientOrphanedInHydra}, idp.Client{}, testHydraClient(t, old), options, "orphan deletion not enabled"},
+		{"old orphan", ClientDifference{Type: ClientOrphanedInHydra}, idp.Client{}, testHydraClient(t, old), deleteOrphans, ""},
+		{"new orphan", ClientDifference{Type: ClientOrphanedInHydra}, idp.Client{}, testHydraClient(t, now), deleteOrphans, "too recent"},
+		{"orphan of unknown age", ClientDifference{Type: ClientOrphanedInHydra}, idp.Client{}, idp.HydraClient{}, deleteOrphans, "unknown age"},
+		{"old mismatch", ClientDifference{Type: ClientMismatch}, idp.Client{}, testHydraClient(t, old), options, ""},
+		{"new mismatch", ClientDifference{Type: ClientMismatch}, idp.Client{}, testHydraClient(t, now), options, "too recent"},
+	}
+	for _, test := range tests {
+		if skipped := skipRepair(test.d, test.c, test.h, test.options, now); skipped != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, skipped)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+
+	"github.com/opensentry/idp/app"
+	"github.com/opensentry/idp/config"
+	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/metrics"
+)
+
+var errMissingWebhookCryptoKey = errors.New("Missing config crypto.keys.webhooks")
+
+// WebhookRetryPolicy controls delivery to webhooks. Failed attempts are retried after BaseDelay, doubling up to MaxDelay, until MaxAttempts is reached and the delivery fails.
+type WebhookRetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int64
+	BatchSize   int64
+	Lease       time.Duration // How long a claimed delivery is hidden from other instances while being delivered
+	Timeout     time.Duration // How long to wait for a webhook to respond
+}
+
+// Backoff returns the delay before the next attempt, after attempts failed attempts.
+func (p WebhookRetryPolicy) Backoff(attempts int64) time.Duration {
+	return backoff(p.BaseDelay, p.MaxDelay, attempts)
+}
+
+// Delivers the events queued for webhooks by idp.QueueEvents. Runs every interval until ctx is done.
+// Deliveries are removed along with their event by RelayEvents, once done with. Private addresses are refused unless allowPrivateAddresses is set, see idp.NewWebhookClient.
+func DeliverWebhooks(ctx context.Context, env *app.Environment, log *logrus.Entry, interval time.Duration, policy WebhookRetryPolicy, allowPrivateAddresses bool) {
+	log = log.WithFields(logrus.Fields{
+		"func": "DeliverWebhooks",
+	})
+
+	client := idp.NewWebhookClient(policy.Timeout, allowPrivateAddresses)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while there is a full batch, so a backlog is not limited to one batch per interval.
+		for {
+			claimed, err := deliverWebhooks(ctx, env, log, client, policy)
+			if err != nil {
+				log.Debug(err.Error())
+				break
+			}
+			if claimed < policy.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+func deliverWebhooks(ctx context.Context, env *app.Environment, log *logrus.Entry, client *http.Client, policy WebhookRetryPolicy) (claimed int64, err error) {
+	keys := config.GetStringSlice("crypto.keys.webhooks")
+	if len(keys) <= 0 {
+		return 0, errMissingWebhookCryptoKey
+	}
+
+	now := time.Now()
+
+	deliveries, err := claimPendingWebhookDeliveries(env, now.Unix(), now.Add(policy.Lease).Unix(), policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			break // Left to be retried when the lease runs out
+		}
+
+		log := log.WithFields(logrus.Fields{"id": d.Id, "webhook": d.Webhook.Id, "event": d.Event.Id, "attempt": d.Attempts + 1})
+
+		var status string
+		var nextAttemptAt int64
+		var attempt idp.WebhookAttempt
+
+		secret, err := idp.DecryptWithKeys(d.Webhook.Secret, keys)
+		if err != nil {
+			// Recorded as a failed attempt, so it shows in the deliveries of the webhook.
+			attempt = idp.WebhookAttempt{Time: time.Now().Unix(), Error: "Failed to decrypt secret"}
+			status, nextAttemptAt = webhookRetry(policy, d)
+			log.Debug(err.Error())
+		} else {
+			status, nextAttemptAt, attempt = deliverWebhook(ctx, client, policy, d, secret)
+			if attempt.Error != "" {
+				log.WithFields(logrus.Fields{"error": attempt.Error}).Debug("Webhook delivery failed")
+			}
+		}
+
+		switch status {
+		case idp.WEBHOOK_DELIVERY_STATUS_PENDING:
+			metrics.WebhookDeliveriesTotal.WithLabelValues("retry").Inc()
+		default:
+			metrics.WebhookDeliveriesTotal.WithLabelValues(status).Inc()
+		}
+
+		// A delivery made but not recorded is made again once its lease runs out, as delivery is at least once.
+		if err := updateWebhookDelivery(env, d, status, nextAttemptAt, attempt); err != nil {
+			log.Debug(err.Error())
+		}
+	}
+
+	return int64(len(deliveries)), nil
+}
+
+// deliverWebhook makes one attempt and returns the status and next attempt of the delivery by the outcome of it.
+func deliverWebhook(ctx context.Context, client *http.Client, policy WebhookRetryPolicy, d idp.WebhookDelivery, secret string) (status string, nextAttemptAt int64, attempt idp.WebhookAttempt) {
+	attempt, err := idp.DeliverWebhook(ctx, client, d, secret)
+	if err != nil {
+		status, nextAttemptAt = webhookRetry(policy, d)
+		return status, nextAttemptAt, attempt
+	}
+	return idp.WEBHOOK_DELIVERY_STATUS_DELIVERED, 0, attempt
+}
+
+func webhookRetry(policy WebhookRetryPolicy, d idp.WebhookDelivery) (status string, nextAttemptAt int64) {
+	if d.Attempts+1 >= policy.MaxAttempts {
+		return idp.WEBHOOK_DELIVERY_STATUS_FAILED, 0
+	}
+	return idp.WEBHOOK_DELIVERY_STATUS_PENDING, time.Now().Add(policy.Backoff(d.Attempts + 1)).Unix()
+}
+
+func claimPendingWebhookDeliveries(env *app.Environment, now int64, leaseUntil int64, limit int64) (deliveries []idp.WebhookDelivery, err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	deliveries, err = idp.ClaimPendingWebhookDeliveries(tx, now, leaseUntil, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func updateWebhookDelivery(env *app.Environment, d idp.WebhookDelivery, status string, nextAttemptAt int64, attempt idp.WebhookAttempt) (err error) {
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	err = idp.UpdateWebhookDelivery(tx, d, status, nextAttemptAt, attempt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opensentry/idp/gateway/idp"
+)
+
+func TestDeliverWebhookRetries(t *testing.T) {
+	policy := WebhookRetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAttempts: 3, Timeout: time.Second}
+	client := idp.NewWebhookClient(policy.Timeout, true)
+
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := idp.WebhookDelivery{Id: "delivery-id", Webhook: idp.Webhook{Id: "webhook-id", Url: srv.URL}, Event: idp.OutboxEvent{Id: "event-id", Body: "{}"}}
+
+	expected := []string{idp.WEBHOOK_DELIVERY_STATUS_PENDING, idp.WEBHOOK_DELIVERY_STATUS_PENDING, idp.WEBHOOK_DELIVERY_STATUS_DELIVERED}
+	for _, e := range expected {
+		before := time.Now()
+		status, nextAttemptAt, attempt := deliverWebhook(context.Background(), client, policy, d, "secret")
+		if status != e {
+			t.Fatalf("Expected attempt %d to be %s, got %s with %+v", d.Attempts+1, e, status, attempt)
+		}
+		if status == idp.WEBHOOK_DELIVERY_STATUS_PENDING && nextAttemptAt < before.Add(policy.BaseDelay<<uint(d.Attempts)).Unix() {
+			t.Errorf("Expected attempt %d to back off, got next attempt at %d", d.Attempts+1, nextAttemptAt)
+		}
+		d.Attempts++
+	}
+}
+
+func TestDeliverWebhookGivesUp(t *testing.T) {
+	policy := WebhookRetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAttempts: 3, Timeout: time.Second}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	d := idp.WebhookDelivery{Id: "delivery-id", Webhook: idp.Webhook{Url: srv.URL}, Attempts: policy.MaxAttempts - 1}
+
+	status, nextAttemptAt, attempt := deliverWebhook(context.Background(), idp.NewWebhookClient(policy.Timeout, true), policy, d, "secret")
+	if status != idp.WEBHOOK_DELIVERY_STATUS_FAILED || nextAttemptAt != 0 || attempt.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the last attempt to fail the delivery, got %s at %d with %+v", status, nextAttemptAt, attempt)
+	}
+}
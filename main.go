@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	oidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/dgrijalva/jwt-go"
@@ -12,24 +13,40 @@ import (
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/clientcredentials"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	nats "github.com/nats-io/nats.go"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	"github.com/opensentry/idp/app"
 	"github.com/opensentry/idp/config"
 	"github.com/opensentry/idp/endpoints/challenges"
 	"github.com/opensentry/idp/endpoints/clients"
+	"github.com/opensentry/idp/endpoints/emails"
+	"github.com/opensentry/idp/endpoints/health"
 	"github.com/opensentry/idp/endpoints/humans"
 	"github.com/opensentry/idp/endpoints/identities"
 	"github.com/opensentry/idp/endpoints/invites"
 	"github.com/opensentry/idp/endpoints/resourceservers"
 	"github.com/opensentry/idp/endpoints/roles"
+	"github.com/opensentry/idp/endpoints/scopes"
+	"github.com/opensentry/idp/endpoints/webhooks"
 	"github.com/opensentry/idp/gateway/idp"
+	"github.com/opensentry/idp/jobs"
+	"github.com/opensentry/idp/metrics"
 	"github.com/opensentry/idp/migration"
+	"github.com/opensentry/idp/openapi"
+	"github.com/opensentry/idp/tracing"
+	"github.com/opensentry/idp/utils"
 
 	E "github.com/opensentry/idp/client/errors"
 )
@@ -44,7 +61,7 @@ var (
 
 	appFields logrus.Fields
 
-	templateMap map[idp.ChallengeType]app.EmailTemplate = make(map[idp.ChallengeType]app.EmailTemplate)
+	emailTemplates map[string]*idp.LocalizedEmailTemplate = make(map[string]*idp.LocalizedEmailTemplate)
 )
 
 func init() {
@@ -83,12 +100,13 @@ func init() {
 		"log.format": logFormat,
 	}
 
-	setupTemplateMap()
+	setupEmailTemplates()
 
 	E.InitRestErrors()
 }
 
-func setupTemplateMap() {
+// setupEmailTemplates parses all email templates, so a broken template stops the idp from starting rather than failing a login.
+func setupEmailTemplates() {
 	senderName := config.GetString("provider.name")
 	if senderName == "" {
 		log.Panic("Missing config provider.name")
@@ -102,38 +120,140 @@ func setupTemplateMap() {
 	}
 	sender := idp.SMTPSender{Name: senderName, Email: senderEmail}
 
+	if selector := config.GetString("mail.dkim.selector"); selector != "" {
+		domain := config.GetString("mail.dkim.domain")
+		if domain == "" {
+			domain = senderEmail[strings.LastIndex(senderEmail, "@")+1:]
+		}
+
+		dkim, err := idp.NewDkimSigner(domain, selector, config.GetString("mail.dkim.private_key"), config.GetString("mail.dkim.canonicalization"))
+		if err != nil {
+			log.Panic("Invalid config mail.dkim: " + err.Error())
+			return
+		}
+		sender.Dkim = dkim
+	}
+
 	baseKey := "templates"
 
-	challenges := map[idp.ChallengeType]string{
-		idp.ChallengeAuthenticate: "authenticate",
-		idp.ChallengeRecover:      "recover",
-		idp.ChallengeDelete:       "delete",
-		idp.ChallengeEmailConfirm: "emailconfirm",
-		idp.ChallengeEmailChange:  "emailchange",
+	layout := idp.EmailLayout{
+		Html:     config.GetString(baseKey + ".layout.html"),
+		Partials: config.GetString(baseKey + ".layout.partials"),
 	}
 
-	for ct, challengeKey := range challenges {
+	defaultLocale := idp.NormalizeLocale(config.GetString(baseKey + ".default_locale"))
+	if defaultLocale == "" {
+		log.Panic("Missing config " + baseKey + ".default_locale")
+		return
+	}
 
-		key := baseKey + "." + challengeKey + ".email.templatefile"
-		var templateFile string = config.GetString(key)
-		if templateFile == "" {
-			log.Panic("Missing config " + key)
-			return
+	names := []string{
+		idp.EMAIL_TEMPLATE_AUTHENTICATE,
+		idp.EMAIL_TEMPLATE_RECOVER,
+		idp.EMAIL_TEMPLATE_DELETE,
+		idp.EMAIL_TEMPLATE_EMAILCONFIRM,
+		idp.EMAIL_TEMPLATE_EMAILCHANGE,
+		idp.EMAIL_TEMPLATE_INVITE,
+	}
+
+	for _, name := range names {
+		key := baseKey + "." + name + ".email"
+
+		files := idp.EmailTemplateFiles{
+			Subject: config.GetString(key + ".subject"),
+			Text:    config.GetString(key + ".text"),
+			Html:    config.GetString(key + ".html"),
+		}
+
+		// Deprecated keys of earlier versions
+		if files.Text == "" {
+			files.Text = config.GetString(key + ".templatefile")
+		}
+		if name == idp.EMAIL_TEMPLATE_INVITE {
+			if files.Text == "" {
+				files.Text = config.GetString("invite.template.email.file")
+			}
+			if files.Subject == "" {
+				files.Subject = config.GetString("invite.template.email.subject")
+			}
 		}
 
-		key = baseKey + "." + challengeKey + ".email.subject"
-		var subject string = config.GetString(key)
-		if subject == "" {
-			log.Panic("Missing config " + key)
+		// The files above are the default locale, translations are under locales.<locale> with the same keys
+		localeFiles := make(map[string]idp.EmailTemplateFiles)
+		err := config.UnmarshalKey(key+".locales", &localeFiles)
+		if err != nil {
+			log.Panic("Invalid config " + key + ".locales: " + err.Error())
 			return
 		}
+		if _, exists := localeFiles[defaultLocale]; !exists {
+			localeFiles[defaultLocale] = files
+		}
 
-		templateMap[ct] = app.EmailTemplate{
-			Sender:  sender,
-			File:    templateFile,
-			Subject: subject,
+		localized := &idp.LocalizedEmailTemplate{DefaultLocale: defaultLocale, Locales: make(map[string]*idp.EmailTemplate)}
+		for locale, files := range localeFiles {
+			tpl, err := idp.ParseEmailTemplate(sender, files, layout)
+			if err != nil {
+				log.Panic("Invalid email template " + key + " for locale " + locale + ": " + err.Error())
+				return
+			}
+			tpl.Locale = idp.NormalizeLocale(locale)
+			localized.Locales[tpl.Locale] = tpl
 		}
+
+		emailTemplates[name] = localized
+	}
+}
+
+// newMailer sets up the transport configured in mail.transport, which is smtp unless developing.
+func newMailer() (idp.Mailer, error) {
+	switch config.GetString("mail.transport") {
+	case "smtp":
+		return &idp.SMTPMailer{
+			Config: idp.SMTPConfig{
+				Host:          config.GetString("mail.smtp.host"),
+				Username:      config.GetString("mail.smtp.user"),
+				Password:      config.GetString("mail.smtp.password"),
+				SkipTlsVerify: config.GetInt("mail.smtp.skip_tls_verify"),
+			},
+			Tls:  config.GetString("mail.smtp.tls"),
+			Auth: config.GetString("mail.smtp.auth"),
+		}, nil
+	case "maildir":
+		path := config.GetString("mail.maildir.path")
+		if path == "" {
+			return nil, errors.New("Missing config mail.maildir.path")
+		}
+		return &idp.MaildirMailer{Path: path}, nil
+	case "capture":
+		return &idp.CaptureMailer{}, nil
+	}
+	return nil, errors.New("Unknown config mail.transport " + config.GetString("mail.transport"))
+}
+
+// newJetStream returns the JetStream context events are published to, creating the stream in nats.jetstream.stream if it does not exist.
+func newJetStream(nc *nats.Conn) (nats.JetStreamContext, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	name := config.GetString("nats.jetstream.stream")
+	_, err = js.StreamInfo(name)
+	if err == nil {
+		return js, nil // Managed by whoever created it, like its subjects and retention
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:       name,
+		Subjects:   config.GetStringSlice("nats.jetstream.subjects"),
+		Storage:    nats.FileStorage,
+		MaxAge:     time.Duration(config.GetInt("nats.jetstream.max_age")) * time.Second,
+		Duplicates: time.Duration(config.GetInt("nats.jetstream.duplicate_window")) * time.Second,
+	})
+	if err != nil {
+		return nil, err
 	}
+	return js, nil
 }
 
 func createBanList(file string) (map[string]bool, error) {
@@ -164,6 +284,10 @@ func main() {
 
 	optMigrate := getopt.BoolLong("migrate", 0, "Run migration")
 	optServe := getopt.BoolLong("serve", 0, "Serve application")
+	optReconcileClients := getopt.BoolLong("reconcile-clients", 0, "Reconcile clients between idp and hydra")
+	optDryRun := getopt.BoolLong("dry-run", 0, "Only report what reconcile-clients would repair")
+	optDeleteOrphans := getopt.BoolLong("delete-orphans", 0, "Let reconcile-clients delete clients in hydra unknown to idp")
+	optReplayEvents := getopt.StringLong("replay-events", 0, "", "Re-publish the events in the outbox starting at an RFC 3339 time or event id", "from")
 	optHelp := getopt.BoolLong("help", 0, "Help")
 	getopt.Parse()
 
@@ -195,6 +319,23 @@ func main() {
 		return
 	}
 
+	shutdownTracing, err := tracing.Init(appName)
+	if err != nil {
+		log.WithFields(appFields).Panic(err.Error())
+		return
+	}
+	defer shutdownTracing(context.Background())
+
+	// Calls to the hydra admin api (idp.*HydraClient, idp.ReadHydraLogin, idp.IntrospectHydraToken) go through http.DefaultClient and the hydra and aap clients
+	// of app.NewHydraClient and app.NewAapClient build on its transport, so measuring it covers the upstream calls. They are only traced
+	// as part of a request if made with its context, the idp.*HydraClient functions take it as argument.
+	// NOTE: Client management functions of github.com/charmixer/hydra/client use their own http.Client, use the idp.*HydraClient functions instead.
+	// hydra.IntrospectToken uses http.Post without a context, use idp.IntrospectHydraToken instead.
+	http.DefaultClient.Transport = tracing.NewTransport(metrics.NewUpstreamTransport(http.DefaultClient.Transport, map[string][]string{
+		"hydra": []string{config.GetString("hydra.public.url"), config.GetString("hydra.private.url")},
+		"aap":   []string{config.GetString("aap.public.url")},
+	}))
+
 	provider, err := oidc.NewProvider(context.Background(), config.GetString("hydra.public.url")+"/")
 	if err != nil {
 		log.WithFields(appFields).Panic(err.Error())
@@ -260,6 +401,35 @@ func main() {
 	}
 	defer natsConnection.Close()
 
+	jetStream, err := newJetStream(natsConnection)
+	if err != nil {
+		log.WithFields(appFields).Panic(err.Error())
+		return
+	}
+
+	mailer, err := newMailer()
+	if err != nil {
+		log.WithFields(appFields).Panic(err.Error())
+		return
+	}
+
+	// Verdicts are reused until they expire or AAP publishes any change, and are used past expiry while AAP can not be reached.
+	verdictCache := app.NewVerdictCache(time.Duration(config.GetInt("aap.judge.cache.ttl"))*time.Second, time.Duration(config.GetInt("aap.judge.cache.stale_grace"))*time.Second)
+	metrics.RegisterVerdictCache(
+		func() uint64 { return verdictCache.Stats().Hits },
+		func() uint64 { return verdictCache.Stats().Misses },
+		func() uint64 { return verdictCache.Stats().StaleHits },
+	)
+	err = verdictCache.SubscribeInvalidations(natsConnection, config.GetStringSlice("aap.judge.cache.invalidate.subjects"))
+	if err != nil {
+		log.WithFields(appFields).Panic(err.Error())
+		return
+	}
+
+	if issuer := config.GetString("idp.public.issuer"); issuer != "" {
+		idp.EventSource = issuer
+	}
+
 	// Setup app state variables. Can be used in handler functions by doing closures see exchangeAuthorizationCodeCallback
 	env := &app.Environment{
 		Constants: &app.EnvironmentConstants{
@@ -280,12 +450,41 @@ func main() {
 		Provider:        provider,
 		HydraConfig:     hydraConfig,
 		AapConfig:       aapConfig,
+		VerdictCache:    verdictCache,
 		Driver:          driver,
 		BannedUsernames: bannedUsernames,
 		IssuerSignKey:   signKey,
 		IssuerVerifyKey: verifyKey,
 		Nats:            natsConnection,
-		TemplateMap:     &templateMap,
+		JetStream:       jetStream,
+		Mailer:          mailer,
+		EmailTemplates:  emailTemplates,
+	}
+
+	// reconcile then exit application
+	if *optReconcileClients {
+		_, err := jobs.ReconcileClients(context.Background(), env, log.WithFields(appFields), jobs.ReconcileOptions{
+			DryRun:        *optDryRun,
+			DeleteOrphans: *optDeleteOrphans,
+			MinAge:        time.Duration(config.GetInt("client.reconcile.min_age")) * time.Second,
+		})
+		if err != nil {
+			log.WithFields(appFields).Panic(err.Error())
+			return
+		}
+		os.Exit(0)
+		return
+	}
+
+	// replay then exit application
+	if *optReplayEvents != "" {
+		_, err := jobs.ReplayEvents(context.Background(), env, log.WithFields(appFields), *optReplayEvents, int64(config.GetInt("events.outbox.batch_size")))
+		if err != nil {
+			log.WithFields(appFields).Panic(err.Error())
+			return
+		}
+		os.Exit(0)
+		return
 	}
 
 	if *optServe {
@@ -313,12 +512,92 @@ func requestAfterAuth() gin.HandlerFunc {
 
 func serve(env *app.Environment) {
 
+	// Jobs are stopped on shutdown and waited for, so a run is never cut off in the middle of a transaction.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobsWg sync.WaitGroup
+
+	jobsWg.Add(1)
+	go func() {
+		defer jobsWg.Done()
+		jobs.CleanupExpiredClientSecrets(jobsCtx, env, log.WithFields(appFields), time.Duration(config.GetInt("client.secret.rotation.cleanup_interval"))*time.Second)
+	}()
+
+	jobsWg.Add(1)
+	go func() {
+		defer jobsWg.Done()
+		jobs.DeliverEmails(jobsCtx, env, log.WithFields(appFields), time.Duration(config.GetInt("mail.outbox.interval"))*time.Second, jobs.EmailRetryPolicy{
+			BaseDelay:   time.Duration(config.GetInt("mail.outbox.retry.base_delay")) * time.Second,
+			MaxDelay:    time.Duration(config.GetInt("mail.outbox.retry.max_delay")) * time.Second,
+			MaxAttempts: int64(config.GetInt("mail.outbox.retry.max_attempts")),
+			BatchSize:   int64(config.GetInt("mail.outbox.batch_size")),
+			Lease:       time.Duration(config.GetInt("mail.outbox.lease")) * time.Second,
+			Retention:   time.Duration(config.GetInt("mail.outbox.retention")) * time.Second,
+		})
+	}()
+
+	jobsWg.Add(1)
+	go func() {
+		defer jobsWg.Done()
+		jobs.RelayEvents(jobsCtx, env, log.WithFields(appFields), time.Duration(config.GetInt("events.outbox.interval"))*time.Second, jobs.EventRelayPolicy{
+			BaseDelay: time.Duration(config.GetInt("events.outbox.retry.base_delay")) * time.Second,
+			MaxDelay:  time.Duration(config.GetInt("events.outbox.retry.max_delay")) * time.Second,
+			BatchSize: int64(config.GetInt("events.outbox.batch_size")),
+			Lease:     time.Duration(config.GetInt("events.outbox.lease")) * time.Second,
+			Retention: time.Duration(config.GetInt("events.outbox.retention")) * time.Second,
+		})
+	}()
+
+	jobsWg.Add(1)
+	go func() {
+		defer jobsWg.Done()
+		jobs.DeliverWebhooks(jobsCtx, env, log.WithFields(appFields), time.Duration(config.GetInt("webhooks.interval"))*time.Second, jobs.WebhookRetryPolicy{
+			BaseDelay:   time.Duration(config.GetInt("webhooks.retry.base_delay")) * time.Second,
+			MaxDelay:    time.Duration(config.GetInt("webhooks.retry.max_delay")) * time.Second,
+			MaxAttempts: int64(config.GetInt("webhooks.retry.max_attempts")),
+			BatchSize:   int64(config.GetInt("webhooks.batch_size")),
+			Lease:       time.Duration(config.GetInt("webhooks.lease")) * time.Second,
+			Timeout:     time.Duration(config.GetInt("webhooks.timeout")) * time.Second,
+		}, config.GetBool("webhooks.allow_private_addresses"))
+	}()
+
+	// Disabled per default, the reconcile-clients command can be run by hand instead.
+	reconcileInterval := config.GetInt("client.reconcile.interval")
+	if reconcileInterval > 0 {
+		jobsWg.Add(1)
+		go func() {
+			defer jobsWg.Done()
+			jobs.ReconcileClientsPeriodically(jobsCtx, env, log.WithFields(appFields), time.Duration(reconcileInterval)*time.Second, jobs.ReconcileOptions{
+				DryRun:        config.GetBool("client.reconcile.dry_run"),
+				DeleteOrphans: config.GetBool("client.reconcile.delete_orphans"),
+				MinAge:        time.Duration(config.GetInt("client.reconcile.min_age")) * time.Second,
+			})
+		}()
+	}
+
 	r := gin.New() // Clean gin to take control with logging.
 	r.Use(gin.Recovery())
+	r.Use(otelgin.Middleware(appName))
 	r.Use(app.ProcessMethodOverride(r))
 	r.Use(app.RequestId())
+	r.Use(app.RequestMetrics())
 	r.Use(app.RequestLogger(env.Constants.LogKey, env.Constants.RequestIdKey, log, appFields))
 
+	// Limits are per route in config ratelimit.routes. Forwarded for headers are only trusted from config ratelimit.trusted_proxies.
+	// Limited per client ip ahead of all routes, so also the ones registered before AuthenticationRequired, e.g. dynamic client registration.
+	var rateLimits []app.RateLimit
+	err := config.UnmarshalKey("ratelimit.routes", &rateLimits)
+	if err != nil {
+		log.WithFields(appFields).Panic(err.Error())
+		return
+	}
+	trustedProxies, err := utils.ParseCidrs(config.GetStringSlice("ratelimit.trusted_proxies"))
+	if err != nil {
+		log.WithFields(appFields).Panic(err.Error())
+		return
+	}
+	rateLimiter := app.NewRateLimiter(rateLimits, trustedProxies)
+	r.Use(app.RateLimitRequired(env.Constants.LogKey, rateLimiter))
+
 	// ## QTNA - Questions that need answering before granting access to a protected resource
 	// 1. Is the user or client authenticated? Answered by the process of obtaining an access token.
 	// 2. Is the access token expired?
@@ -326,17 +605,60 @@ func serve(env *app.Environment) {
 	// 4. Is the user or client giving the grants in the access token authorized to operate the scopes granted?
 	// 5. Is the access token revoked?
 
-	// All requests need to be authenticated.
-	r.Use(app.AuthenticationRequired(env.Constants.LogKey, env.Constants.AccessTokenKey))
+	// Probed by orchestrators without authentication.
+	// NOTE: Must be registered before AuthenticationRequired is put into use.
+	r.GET("/health/alive", health.GetAlive(env))
+	r.GET("/health/ready", health.GetReady(env))
+
+	// Generated from openapi.Operations and the client types, a test keeps it in line with the routes below.
+	// NOTE: Must be registered before AuthenticationRequired is put into use.
+	r.GET("/openapi.json", openapi.Handler(openapi.Generate(openapi.Info{
+		Title:       appName,
+		Version:     "1",
+		Description: "All bulky endpoints can be called with POST and the X-HTTP-Method-Override header set to the method of the operation, as clients may not be able to send a body with GET and DELETE.",
+	}, nil, openapi.Operations)))
+
+	// Scraped by prometheus without authentication, the metrics hold no identities or tokens.
+	// NOTE: Must be registered before AuthenticationRequired is put into use.
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Dynamic client registration (RFC 7591, RFC 7592) authenticates with initial and registration access tokens instead of hydra access tokens.
+	// NOTE: Must be registered before AuthenticationRequired is put into use.
+	r.POST("/connect/register", clients.PostClientRegistration(env))
+	r.GET("/connect/register/:client_id", clients.GetClientRegistration(env))
+	r.PUT("/connect/register/:client_id", clients.PutClientRegistration(env))
+	r.DELETE("/connect/register/:client_id", clients.DeleteClientRegistration(env))
 
+	// All requests need to be authenticated.
+	// JWT access tokens are verified like id tokens by the oidc verifier of the provider, against the JWKS in the discovery document of hydra,
+	// which holds the keys hydra signs access tokens with. Signature, iss, aud, exp and nbf are checked.
+	audience := config.GetString("oauth2.access_token.audience")
+	if audience == "" {
+		log.WithFields(appFields).Panic("Missing config oauth2.access_token.audience")
+		return
+	}
 	hydraIntrospectUrl := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.introspect")
+	introspectionCacheTtl := config.GetInt("oauth2.introspection.cache.ttl")
 
-	aconf := app.AuthorizationConfig{
+	authconf := app.AuthenticationConfig{
 		LogKey:             env.Constants.LogKey,
 		AccessTokenKey:     env.Constants.AccessTokenKey,
+		JwtVerifier:        env.Provider.Verifier(&oidc.Config{ClientID: audience}),
 		HydraConfig:        env.HydraConfig,
 		HydraIntrospectUrl: hydraIntrospectUrl,
-		AapConfig:          env.AapConfig,
+		IntrospectionCache: app.NewIntrospectionCache(time.Duration(introspectionCacheTtl) * time.Second),
+	}
+	r.Use(app.AuthenticationRequired(authconf))
+
+	// Authenticated requests are limited per client_id as well, in buckets apart from the ones per client ip.
+	r.Use(app.ClientRateLimitRequired(env.Constants.LogKey, rateLimiter))
+
+	aconf := app.AuthorizationConfig{
+		LogKey:         env.Constants.LogKey,
+		AccessTokenKey: env.Constants.AccessTokenKey,
+		HydraConfig:    env.HydraConfig,
+		AapConfig:      env.AapConfig,
+		VerdictCache:   env.VerdictCache,
 	}
 
 	// TODO: Maybe instaed of letting the enpoint do scope requirements on confirmation_type, that should be part of the set up here aswell, but intertwined with the input data somehow?
@@ -372,14 +694,23 @@ func serve(env *app.Environment) {
 
 	r.GET("/clients", app.AuthorizationRequired(aconf, "idp:read:clients"), clients.GetClients(env))
 	r.POST("/clients", app.AuthorizationRequired(aconf, "idp:create:clients"), clients.PostClients(env))
+	r.PUT("/clients", app.AuthorizationRequired(aconf, "idp:update:clients"), clients.PutClients(env))
+	r.PUT("/clients/secret", app.AuthorizationRequired(aconf, "idp:update:clients:secret"), clients.PutClientsSecret(env))
 	r.DELETE("/clients", app.AuthorizationRequired(aconf, "idp:delete:clients"), clients.DeleteClients(env))
 
 	r.GET("/resourceservers", app.AuthorizationRequired(aconf, "idp:read:resourceservers"), resourceservers.GetResourceServers(env))
 	r.POST("/resourceservers", app.AuthorizationRequired(aconf, "idp:create:resourceservers"), resourceservers.PostResourceServers(env))
+	r.PUT("/resourceservers", app.AuthorizationRequired(aconf, "idp:update:resourceservers"), resourceservers.PutResourceServers(env))
 	r.DELETE("/resourceservers", app.AuthorizationRequired(aconf, "idp:delete:resourceservers"), resourceservers.DeleteResourceServers(env))
 
+	r.GET("/scopes", app.AuthorizationRequired(aconf, "idp:read:scopes"), scopes.GetScopes(env))
+	r.POST("/scopes", app.AuthorizationRequired(aconf, "idp:create:scopes"), scopes.PostScopes(env))
+	r.PUT("/scopes", app.AuthorizationRequired(aconf, "idp:update:scopes"), scopes.PutScopes(env))
+	r.DELETE("/scopes", app.AuthorizationRequired(aconf, "idp:delete:scopes"), scopes.DeleteScopes(env))
+
 	r.GET("/roles", app.AuthorizationRequired(aconf, "idp:read:roles"), roles.GetRoles(env))
 	r.POST("/roles", app.AuthorizationRequired(aconf, "idp:create:roles"), roles.PostRoles(env))
+	r.PUT("/roles", app.AuthorizationRequired(aconf, "idp:update:roles"), roles.PutRoles(env))
 	r.DELETE("/roles", app.AuthorizationRequired(aconf, "idp:delete:roles"), roles.DeleteRoles(env))
 
 	r.GET("/invites", app.AuthorizationRequired(aconf, "idp:read:invites"), invites.GetInvites(env))
@@ -387,5 +718,119 @@ func serve(env *app.Environment) {
 	r.POST("/invites/send", app.AuthorizationRequired(aconf, "idp:create:invites:send"), invites.PostInvitesSend(env))
 	r.POST("/invites/claim", app.AuthorizationRequired(aconf, "idp:create:invites:claim"), invites.PostInvitesClaim(env))
 
-	r.RunTLS(":"+config.GetString("serve.public.port"), config.GetString("serve.tls.cert.path"), config.GetString("serve.tls.key.path"))
+	r.POST("/emails/preview", app.AuthorizationRequired(aconf, "idp:create:emails:preview"), emails.PostEmailsPreview(env))
+	r.POST("/emails/send", app.AuthorizationRequired(aconf, "idp:create:emails:send"), emails.PostEmailsSend(env))
+
+	r.GET("/webhooks", app.AuthorizationRequired(aconf, "idp:read:webhooks"), webhooks.GetWebhooks(env))
+	r.POST("/webhooks", app.AuthorizationRequired(aconf, "idp:create:webhooks"), webhooks.PostWebhooks(env))
+	r.PUT("/webhooks", app.AuthorizationRequired(aconf, "idp:update:webhooks"), webhooks.PutWebhooks(env))
+	r.DELETE("/webhooks", app.AuthorizationRequired(aconf, "idp:delete:webhooks"), webhooks.DeleteWebhooks(env))
+	r.GET("/webhooks/deliveries", app.AuthorizationRequired(aconf, "idp:read:webhooks:deliveries"), webhooks.GetWebhooksDeliveries(env))
+	r.POST("/webhooks/deliveries/redeliver", app.AuthorizationRequired(aconf, "idp:create:webhooks:deliveries:redeliver"), webhooks.PostWebhooksDeliveriesRedeliver(env))
+
+	// Publish the scopes of the routes mounted above, so the registry always matches what this version of the idp serves.
+	registerOwnScopes(env)
+
+	srv := &http.Server{
+		Addr:    ":" + config.GetString("serve.public.port"),
+		Handler: r,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServeTLS(config.GetString("serve.tls.cert.path"), config.GetString("serve.tls.key.path"))
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		if err != nil && err != http.ErrServerClosed {
+			log.WithFields(appFields).Error(err.Error())
+		}
+	case sig := <-quit:
+		log.WithFields(appFields).WithFields(logrus.Fields{"signal": sig.String()}).Info("Shutting down")
+	}
+
+	shutdown(env, srv, stopJobs, &jobsWg)
+}
+
+// shutdown stops accepting requests and lets the ones in flight finish their bulky transactions, bounded by config serve.shutdown.timeout seconds.
+// Pending events are flushed to NATS before main closes the Neo4j driver, which must be last as both requests and jobs use it.
+func shutdown(env *app.Environment, srv *http.Server, stopJobs context.CancelFunc, jobsWg *sync.WaitGroup) {
+	timeout := time.Duration(config.GetInt("serve.shutdown.timeout")) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.WithFields(appFields).WithFields(logrus.Fields{"error": err.Error()}).Info("Requests still in flight at shutdown timeout were cut off")
+	}
+
+	stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobsWg.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		log.WithFields(appFields).Info("Jobs still running at shutdown timeout were cut off")
+	}
+
+	// Drain flushes pending publishes and lets subscriptions finish the messages already received, then closes the connection.
+	err = env.Nats.Drain()
+	if err != nil {
+		log.WithFields(appFields).WithFields(logrus.Fields{"error": err.Error()}).Info("Unable to drain NATS connection")
+	}
+	for env.Nats.IsDraining() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func registerOwnScopes(env *app.Environment) {
+	log := log.WithFields(appFields).WithFields(logrus.Fields{"func": "registerOwnScopes"})
+
+	var ownScopes []idp.Scope
+	for _, scope := range app.MountedScopes() {
+		description, consentText := app.DescribeScope(scope)
+		ownScopes = append(ownScopes, idp.Scope{Name: scope, Description: description, ConsentText: consentText})
+	}
+
+	session, tx, err := idp.BeginWriteTx(context.Background(), env.Driver)
+	if err != nil {
+		log.Debug(err.Error())
+		return
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	// The idp is a resource server itself, identified by config id.
+	registered, err := idp.RegisterScopes(tx, idp.ResourceServer{Identity: idp.Identity{Id: config.GetString("id")}}, ownScopes)
+	if err != nil {
+		log.Debug(err.Error())
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Debug(err.Error())
+		return
+	}
+
+	if len(registered) == 0 {
+		log.WithFields(logrus.Fields{"id": config.GetString("id")}).Info("Scopes not registered. Hint: Is the idp missing as a resource server?")
+		return
+	}
+	log.WithFields(logrus.Fields{"scopes": len(registered)}).Debug("Scopes registered")
 }
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	bulky "github.com/charmixer/bulky/client"
+)
+
+const namespace = "idp"
+
+var (
+	HttpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests handled, by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	BulkyResponsesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bulky_responses_total",
+		Help:      "Outcome of each request inside a bulk request, by route and status.",
+	}, []string{"route", "status"})
+
+	Neo4jTransactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "neo4j_transaction_duration_seconds",
+		Help:      "Duration of Neo4j transactions from begin to commit or rollback, by access mode and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"mode", "outcome"})
+
+	UpstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of outbound HTTP calls, by upstream (hydra, aap, other) and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "status"})
+
+	UpstreamRequestErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_errors_total",
+		Help:      "Outbound HTTP calls that failed with a transport error or a 5xx status, by upstream.",
+	}, []string{"upstream"})
+
+	EmailsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Emails handed to the mail transport, by outcome (sent, failed).",
+	}, []string{"outcome"})
+
+	EmailsDeadLetteredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_dead_lettered_total",
+		Help:      "Emails given up on after too many failed attempts or because they expired in the outbox.",
+	})
+
+	NatsPublishFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_publish_failures_total",
+		Help:      "Events that could not be published to NATS, by subject.",
+	}, []string{"subject"})
+
+	WebhookDeliveriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Attempts to deliver events to webhooks, by outcome (delivered, retry, failed).",
+	}, []string{"outcome"})
+
+	RateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected with 429 by the rate limiter, by method and route.",
+	}, []string{"method", "route"})
+
+	AuthenticationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authentications_total",
+		Help:      "Authentication attempts, by acr and outcome (granted, denied).",
+	}, []string{"acr", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HttpRequestsTotal,
+		HttpRequestDuration,
+		BulkyResponsesTotal,
+		Neo4jTransactionDuration,
+		UpstreamRequestDuration,
+		UpstreamRequestErrorsTotal,
+		EmailsTotal,
+		EmailsDeadLetteredTotal,
+		NatsPublishFailuresTotal,
+		WebhookDeliveriesTotal,
+		RateLimitedTotal,
+		AuthenticationsTotal,
+	)
+}
+
+// RegisterVerdictCache exports the counters of the AAP verdict cache, the hit ratio is hits / (hits + misses).
+func RegisterVerdictCache(hits func() uint64, misses func() uint64, staleHits func() uint64) {
+	prometheus.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "aap_verdict_cache_hits_total",
+			Help:      "AAP verdicts answered from the cache.",
+		}, func() float64 { return float64(hits()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "aap_verdict_cache_misses_total",
+			Help:      "AAP verdicts not found in the cache.",
+		}, func() float64 { return float64(misses()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "aap_verdict_cache_stale_hits_total",
+			Help:      "Expired AAP verdicts used because AAP could not be reached.",
+		}, func() float64 { return float64(staleHits()) }),
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func ObserveBulkyResponses(route string, responses []interface{}) {
+	for _, r := range responses {
+		if response, ok := r.(*bulky.Response); ok {
+			BulkyResponsesTotal.WithLabelValues(route, strconv.Itoa(response.Status)).Inc()
+		}
+	}
+}
+
+// Password authentication is accepted without an acr, it is labelled "password".
+func ObserveAuthentication(acr string, granted bool) {
+	if acr == "" {
+		acr = "password"
+	}
+	outcome := "denied"
+	if granted {
+		outcome = "granted"
+	}
+	AuthenticationsTotal.WithLabelValues(acr, outcome).Inc()
+}
+
+func ObserveEmail(err error) {
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+	}
+	EmailsTotal.WithLabelValues(outcome).Inc()
+}
+
+// UpstreamTransport measures every outbound call made through it. Calls are labelled by the upstream whose base url matches the host of the request.
+type UpstreamTransport struct {
+	Base      http.RoundTripper
+	Upstreams map[string]string // host -> upstream label
+}
+
+// NewUpstreamTransport labels calls to the hosts of the given base urls, e.g. {"hydra": "https://hydra:4445", "aap": "https://aap"}.
+func NewUpstreamTransport(base http.RoundTripper, upstreamUrls map[string][]string) *UpstreamTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	upstreams := make(map[string]string)
+	for upstream, urls := range upstreamUrls {
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil || parsed.Host == "" {
+				continue
+			}
+			upstreams[parsed.Host] = upstream
+		}
+	}
+
+	return &UpstreamTransport{Base: base, Upstreams: upstreams}
+}
+
+func (t *UpstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstream, found := t.Upstreams[req.URL.Host]
+	if !found {
+		upstream = "other"
+	}
+
+	start := time.Now()
+	res, err := t.Base.RoundTrip(req)
+	duration := time.Since(start).Seconds()
+
+	if err != nil {
+		UpstreamRequestDuration.WithLabelValues(upstream, "error").Observe(duration)
+		UpstreamRequestErrorsTotal.WithLabelValues(upstream).Inc()
+		return res, err
+	}
+
+	UpstreamRequestDuration.WithLabelValues(upstream, strconv.Itoa(res.StatusCode)).Observe(duration)
+	if res.StatusCode >= 500 {
+		UpstreamRequestErrorsTotal.WithLabelValues(upstream).Inc()
+	}
+	return res, nil
+}
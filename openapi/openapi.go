@@ -0,0 +1,235 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	bulky "github.com/charmixer/bulky/errors"
+)
+
+const VERSION = "3.0.3"
+
+// Operation describes a route of the idp. The OpenAPI document is generated from these and the types they refer to.
+type Operation struct {
+	Method      string
+	Path        string // As mounted in gin, :name segments are path parameters
+	Scope       string // Scope required by AuthorizationRequired, empty for routes outside the authenticated router
+	Summary     string
+	Bulky       bool        // Request and response are lists wrapped in the bulky envelope
+	Request     interface{} // Type of the request body, or of each request when Bulky. Nil if there is no body
+	Response    interface{} // Type of the response body, or of ok in each response when Bulky
+	ContentType string      // Content type of the response, defaults to application/json
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	Url string `json:"url"`
+}
+
+type Document struct {
+	OpenApi    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Servers    []Server                        `json:"servers,omitempty"`
+	Paths      map[string]map[string]*PathItem `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+type PathItem struct {
+	Summary     string                `json:"summary,omitempty"`
+	OperationId string                `json:"operationId"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+	Scopes      []string              `json:"x-required-scopes,omitempty"` // The scopes AAP must grant, which OpenAPI has no way of expressing for bearer tokens
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Generate builds the OpenAPI document for the operations. Error codes are taken from the bulky error map, so call it after the rest errors have been initialized.
+func Generate(info Info, servers []Server, operations []Operation) Document {
+	s := make(schemas)
+	s["BulkyError"] = bulkyErrorSchema()
+
+	doc := Document{
+		OpenApi: VERSION,
+		Info:    info,
+		Servers: servers,
+		Paths:   make(map[string]map[string]*PathItem),
+		Components: Components{
+			Schemas: s,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"bearer": &SecurityScheme{
+					Type:         "http",
+					Scheme:       "bearer",
+					BearerFormat: "JWT or opaque",
+					Description:  "Access token issued by Hydra. The scopes in x-required-scopes of the operation are judged by AAP.",
+				},
+			},
+		},
+	}
+
+	for _, op := range operations {
+		path, params := convertPath(op.Path)
+
+		item := &PathItem{
+			Summary:     op.Summary,
+			OperationId: operationId(op),
+			Parameters:  params,
+			Responses:   make(map[string]Response),
+			Security:    []map[string][]string{},
+		}
+
+		if op.Scope != "" {
+			item.Security = []map[string][]string{{"bearer": []string{}}}
+			item.Scopes = []string{op.Scope}
+			item.Responses["401"] = Response{Description: "Access token missing or invalid"}
+			item.Responses["403"] = Response{Description: "Access token not granted the required scope"}
+			item.Responses["429"] = Response{Description: "Rate limited, retry after the seconds in the Retry-After header"}
+		}
+
+		if op.Request != nil {
+			schema := s.of(reflect.TypeOf(op.Request))
+			if op.Bulky {
+				schema = &Schema{Type: "array", Items: schema}
+			}
+			item.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: schema}}}
+		}
+
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+
+		ok := Response{Description: "OK"}
+		if op.Response != nil {
+			schema := s.of(reflect.TypeOf(op.Response))
+			if op.Bulky {
+				ok.Description = "One response per request, in the order given. The status of each tells its outcome."
+				schema = bulkyResponsesSchema(schema)
+			}
+			ok.Content = map[string]MediaType{contentType: {Schema: schema}}
+		} else if op.ContentType != "" {
+			ok.Content = map[string]MediaType{contentType: {Schema: &Schema{Type: "string"}}}
+		}
+		item.Responses["200"] = ok
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*PathItem)
+		}
+		doc.Paths[path][strings.ToLower(op.Method)] = item
+	}
+
+	return doc
+}
+
+// Handler serves the document as generated at startup.
+func Handler(doc Document) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// convertPath turns gin :name segments into OpenAPI {name} segments.
+func convertPath(ginPath string) (path string, params []Parameter) {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			name := segment[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationId is the method and path in camel case, e.g. putHumansPassword or getConnectRegisterClientId.
+func operationId(op Operation) string {
+	id := strings.ToLower(op.Method)
+	for _, segment := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == ':' || r == '_' || r == '.' }) {
+		id += strings.ToUpper(segment[:1]) + segment[1:]
+	}
+	return id
+}
+
+func bulkyResponsesSchema(ok *Schema) *Schema {
+	return &Schema{
+		Type: "array",
+		Items: &Schema{
+			Type:     "object",
+			Required: []string{"index", "status"},
+			Properties: map[string]*Schema{
+				"index":  &Schema{Type: "integer", Format: "int32", Description: "Index of the request this is the response to"},
+				"status": &Schema{Type: "integer", Format: "int32", Description: "HTTP status of this request"},
+				"errors": &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/BulkyError"}},
+				"ok":     ok,
+			},
+		},
+	}
+}
+
+func bulkyErrorSchema() *Schema {
+	var codes []int
+	for code := range bulky.MAP {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	var enum []interface{}
+	var description []string
+	for _, code := range codes {
+		enum = append(enum, code)
+		description = append(description, fmt.Sprintf("%d: %s", code, bulky.MAP[code]["dev"]))
+	}
+
+	return &Schema{
+		Type:     "object",
+		Required: []string{"code", "error"},
+		Properties: map[string]*Schema{
+			"code":  &Schema{Type: "integer", Format: "int32", Enum: enum, Description: strings.Join(description, "\n")},
+			"error": &Schema{Type: "string"},
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package openapi
+
+import (
+	"github.com/opensentry/idp/client"
+)
+
+// Operations of the idp. Must match the routes mounted in serve(), which is enforced by a test parsing main.go.
+var Operations = []Operation{
+	{Method: "GET", Path: "/health/alive", Summary: "Liveness probe", Response: client.HealthResponse{}},
+	{Method: "GET", Path: "/health/ready", Summary: "Readiness probe, checks all dependencies", Response: client.HealthResponse{}},
+	{Method: "GET", Path: "/metrics", Summary: "Prometheus metrics", ContentType: "text/plain"},
+	{Method: "GET", Path: "/openapi.json", Summary: "This document"},
+
+	{Method: "POST", Path: "/connect/register", Summary: "Register a client (RFC 7591)", Request: client.ClientRegistrationRequest{}, Response: client.ClientRegistrationResponse{}},
+	{Method: "GET", Path: "/connect/register/:client_id", Summary: "Read a registered client (RFC 7592)", Response: client.ClientRegistrationResponse{}},
+	{Method: "PUT", Path: "/connect/register/:client_id", Summary: "Update a registered client (RFC 7592)", Request: client.ClientRegistrationRequest{}, Response: client.ClientRegistrationResponse{}},
+	{Method: "DELETE", Path: "/connect/register/:client_id", Summary: "Delete a registered client (RFC 7592)"},
+
+	{Method: "GET", Path: "/challenges", Scope: "idp:read:challenges", Summary: "Read challenges", Bulky: true, Request: client.ReadChallengesRequest{}, Response: client.ReadChallengesResponse{}},
+	{Method: "POST", Path: "/challenges", Scope: "idp:create:challenges", Summary: "Create challenges", Bulky: true, Request: client.CreateChallengesRequest{}, Response: client.CreateChallengesResponse{}},
+	{Method: "PUT", Path: "/challenges/verify", Scope: "idp:update:challenges:verify", Summary: "Verify challenges", Bulky: true, Request: client.UpdateChallengesVerifyRequest{}, Response: client.UpdateChallengesVerifyResponse{}},
+
+	{Method: "GET", Path: "/identities", Scope: "idp:read:identities", Summary: "Read identities", Bulky: true, Request: client.ReadIdentitiesRequest{}, Response: client.ReadIdentitiesResponse{}},
+
+	{Method: "GET", Path: "/humans", Scope: "idp:read:humans", Summary: "Read humans", Bulky: true, Request: client.ReadHumansRequest{}, Response: client.ReadHumansResponse{}},
+	{Method: "POST", Path: "/humans", Scope: "idp:create:humans", Summary: "Create humans", Bulky: true, Request: client.CreateHumansRequest{}, Response: client.CreateHumansResponse{}},
+	{Method: "PUT", Path: "/humans", Scope: "idp:update:humans", Summary: "Update humans", Bulky: true, Request: client.UpdateHumansRequest{}, Response: client.UpdateHumansResponse{}},
+	{Method: "DELETE", Path: "/humans", Scope: "idp:delete:humans", Summary: "Delete humans, by sending a delete verification challenge", Bulky: true, Request: client.DeleteHumansRequest{}, Response: client.DeleteHumansResponse{}},
+	{Method: "POST", Path: "/humans/authenticate", Scope: "idp:create:humans:authenticate", Summary: "Authenticate humans", Bulky: true, Request: client.CreateHumansAuthenticateRequest{}, Response: client.CreateHumansAuthenticateResponse{}},
+	{Method: "PUT", Path: "/humans/password", Scope: "idp:update:humans:password", Summary: "Change passwords", Bulky: true, Request: client.UpdateHumansPasswordRequest{}, Response: client.UpdateHumansPasswordResponse{}},
+	{Method: "PUT", Path: "/humans/totp", Scope: "idp:update:humans:totp", Summary: "Enable or disable TOTP", Bulky: true, Request: client.UpdateHumansTotpRequest{}, Response: client.UpdateHumansTotpResponse{}},
+	{Method: "PUT", Path: "/humans/email", Scope: "idp:update:humans:email", Summary: "Update emails", Bulky: true, Request: client.UpdateHumansEmailRequest{}, Response: client.UpdateHumansEmailResponse{}},
+	{Method: "GET", Path: "/humans/logout", Scope: "idp:read:humans:logout", Summary: "Read logout requests", Bulky: true, Request: client.ReadHumansLogoutRequest{}, Response: client.ReadHumansLogoutResponse{}},
+	{Method: "POST", Path: "/humans/logout", Scope: "idp:create:humans:logout", Summary: "Create logout requests", Bulky: true, Request: client.CreateHumansLogoutRequest{}, Response: client.CreateHumansLogoutResponse{}},
+	{Method: "PUT", Path: "/humans/logout", Scope: "idp:update:humans:logout", Summary: "Accept logout requests", Bulky: true, Request: client.UpdateHumansLogoutAcceptRequest{}, Response: client.UpdateHumansLogoutAcceptResponse{}},
+	{Method: "PUT", Path: "/humans/deleteverification", Scope: "idp:update:humans:deleteverification", Summary: "Confirm deletion of humans", Bulky: true, Request: client.UpdateHumansDeleteVerifyRequest{}, Response: client.UpdateHumansDeleteVerifyResponse{}},
+	{Method: "POST", Path: "/humans/recover", Scope: "idp:create:humans:recover", Summary: "Start recovery of humans", Bulky: true, Request: client.CreateHumansRecoverRequest{}, Response: client.CreateHumansRecoverResponse{}},
+	{Method: "PUT", Path: "/humans/recoververification", Scope: "idp:update:humans:recoververification", Summary: "Confirm recovery of humans", Bulky: true, Request: client.UpdateHumansRecoverVerifyRequest{}, Response: client.UpdateHumansRecoverVerifyResponse{}},
+	{Method: "POST", Path: "/humans/emailchange", Scope: "idp:create:humans:emailchange", Summary: "Start email changes", Bulky: true, Request: client.CreateHumansEmailChangeRequest{}, Response: client.CreateHumansEmailChangeResponse{}},
+	{Method: "PUT", Path: "/humans/emailchange", Scope: "idp:update:humans:emailchange", Summary: "Confirm email changes", Bulky: true, Request: client.UpdateHumansEmailConfirmRequest{}, Response: client.UpdateHumansEmailConfirmResponse{}},
+
+	{Method: "GET", Path: "/clients", Scope: "idp:read:clients", Summary: "Read clients", Bulky: true, Request: client.ReadClientsRequest{}, Response: client.ReadClientsResponse{}},
+	{Method: "POST", Path: "/clients", Scope: "idp:create:clients", Summary: "Create clients", Bulky: true, Request: client.CreateClientsRequest{}, Response: client.CreateClientsResponse{}},
+	{Method: "PUT", Path: "/clients", Scope: "idp:update:clients", Summary: "Update clients", Bulky: true, Request: client.UpdateClientsRequest{}, Response: client.UpdateClientsResponse{}},
+	{Method: "PUT", Path: "/clients/secret", Scope: "idp:update:clients:secret", Summary: "Rotate client secrets", Bulky: true, Request: client.UpdateClientsSecretRequest{}, Response: client.UpdateClientsSecretResponse{}},
+	{Method: "DELETE", Path: "/clients", Scope: "idp:delete:clients", Summary: "Delete clients", Bulky: true, Request: client.DeleteClientsRequest{}, Response: client.DeleteClientsResponse{}},
+
+	{Method: "GET", Path: "/resourceservers", Scope: "idp:read:resourceservers", Summary: "Read resource servers", Bulky: true, Request: client.ReadResourceServersRequest{}, Response: client.ReadResourceServersResponse{}},
+	{Method: "POST", Path: "/resourceservers", Scope: "idp:create:resourceservers", Summary: "Create resource servers", Bulky: true, Request: client.CreateResourceServersRequest{}, Response: client.CreateResourceServersResponse{}},
+	{Method: "PUT", Path: "/resourceservers", Scope: "idp:update:resourceservers", Summary: "Update resource servers", Bulky: true, Request: client.UpdateResourceServersRequest{}, Response: client.UpdateResourceServersResponse{}},
+	{Method: "DELETE", Path: "/resourceservers", Scope: "idp:delete:resourceservers", Summary: "Delete resource servers", Bulky: true, Request: client.DeleteResourceServersRequest{}, Response: client.DeleteResourceServersResponse{}},
+
+	{Method: "GET", Path: "/scopes", Scope: "idp:read:scopes", Summary: "Read scopes", Bulky: true, Request: client.ReadScopesRequest{}, Response: client.ReadScopesResponse{}},
+	{Method: "POST", Path: "/scopes", Scope: "idp:create:scopes", Summary: "Create scopes", Bulky: true, Request: client.CreateScopesRequest{}, Response: client.CreateScopesResponse{}},
+	{Method: "PUT", Path: "/scopes", Scope: "idp:update:scopes", Summary: "Update scopes", Bulky: true, Request: client.UpdateScopesRequest{}, Response: client.UpdateScopesResponse{}},
+	{Method: "DELETE", Path: "/scopes", Scope: "idp:delete:scopes", Summary: "Delete scopes", Bulky: true, Request: client.DeleteScopesRequest{}, Response: client.DeleteScopesResponse{}},
+
+	{Method: "GET", Path: "/roles", Scope: "idp:read:roles", Summary: "Read roles", Bulky: true, Request: client.ReadRolesRequest{}, Response: client.ReadRolesResponse{}},
+	{Method: "POST", Path: "/roles", Scope: "idp:create:roles", Summary: "Create roles", Bulky: true, Request: client.CreateRolesRequest{}, Response: client.CreateRolesResponse{}},
+	{Method: "PUT", Path: "/roles", Scope: "idp:update:roles", Summary: "Update roles", Bulky: true, Request: client.UpdateRolesRequest{}, Response: client.UpdateRolesResponse{}},
+	{Method: "DELETE", Path: "/roles", Scope: "idp:delete:roles", Summary: "Delete roles", Bulky: true, Request: client.DeleteRolesRequest{}, Response: client.DeleteRolesResponse{}},
+
+	{Method: "GET", Path: "/invites", Scope: "idp:read:invites", Summary: "Read invites", Bulky: true, Request: client.ReadInvitesRequest{}, Response: client.ReadInvitesResponse{}},
+	{Method: "POST", Path: "/invites", Scope: "idp:create:invites", Summary: "Create invites", Bulky: true, Request: client.CreateInvitesRequest{}, Response: client.CreateInvitesResponse{}},
+	{Method: "POST", Path: "/invites/send", Scope: "idp:create:invites:send", Summary: "Send invites by email", Bulky: true, Request: client.CreateInvitesSendRequest{}, Response: client.CreateInvitesSendResponse{}},
+	{Method: "POST", Path: "/invites/claim", Scope: "idp:create:invites:claim", Summary: "Claim invites", Bulky: true, Request: client.CreateInvitesClaimRequest{}, Response: client.CreateInvitesClaimResponse{}},
+
+	{Method: "POST", Path: "/emails/preview", Scope: "idp:create:emails:preview", Summary: "Render email templates with sample or given data", Bulky: true, Request: client.CreateEmailsPreviewRequest{}, Response: client.CreateEmailsPreviewResponse{}},
+	{Method: "POST", Path: "/emails/send", Scope: "idp:create:emails:send", Summary: "Send email templates to an address for testing", Bulky: true, Request: client.CreateEmailsSendRequest{}, Response: client.CreateEmailsSendResponse{}},
+
+	{Method: "GET", Path: "/webhooks", Scope: "idp:read:webhooks", Summary: "Read webhooks", Bulky: true, Request: client.ReadWebhooksRequest{}, Response: client.ReadWebhooksResponse{}},
+	{Method: "POST", Path: "/webhooks", Scope: "idp:create:webhooks", Summary: "Subscribe urls to events", Bulky: true, Request: client.CreateWebhooksRequest{}, Response: client.CreateWebhooksResponse{}},
+	{Method: "PUT", Path: "/webhooks", Scope: "idp:update:webhooks", Summary: "Update webhooks", Bulky: true, Request: client.UpdateWebhooksRequest{}, Response: client.UpdateWebhooksResponse{}},
+	{Method: "DELETE", Path: "/webhooks", Scope: "idp:delete:webhooks", Summary: "Delete webhooks", Bulky: true, Request: client.DeleteWebhooksRequest{}, Response: client.DeleteWebhooksResponse{}},
+	{Method: "GET", Path: "/webhooks/deliveries", Scope: "idp:read:webhooks:deliveries", Summary: "Read deliveries of webhooks with their attempts", Bulky: true, Request: client.ReadWebhooksDeliveriesRequest{}, Response: client.ReadWebhooksDeliveriesResponse{}},
+	{Method: "POST", Path: "/webhooks/deliveries/redeliver", Scope: "idp:create:webhooks:deliveries:redeliver", Summary: "Deliver events to webhooks again", Bulky: true, Request: client.CreateWebhooksDeliveriesRedeliverRequest{}, Response: client.CreateWebhooksDeliveriesRedeliverResponse{}},
+}
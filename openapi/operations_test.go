@@ -0,0 +1,150 @@
+package openapi
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+type mountedRoute struct {
+	Method string
+	Path   string
+	Scope  string
+}
+
+// mountedRoutes finds the routes mounted in serve(), r.GET("/path", app.AuthorizationRequired(aconf, "scope"), handler), by parsing main.go.
+func mountedRoutes(t *testing.T) map[string]mountedRoute {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "../main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := make(map[string]mountedRoute)
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		recv, ok := sel.X.(*ast.Ident)
+		if !ok || recv.Name != "r" {
+			return true
+		}
+		switch sel.Sel.Name {
+		case "GET", "POST", "PUT", "DELETE", "PATCH":
+		default:
+			return true
+		}
+
+		route := mountedRoute{Method: sel.Sel.Name, Path: stringLiteral(t, fset, call.Args[0])}
+		for _, arg := range call.Args[1:] {
+			if argCall, ok := arg.(*ast.CallExpr); ok {
+				if fun, ok := argCall.Fun.(*ast.SelectorExpr); ok && fun.Sel.Name == "AuthorizationRequired" {
+					route.Scope = stringLiteral(t, fset, argCall.Args[len(argCall.Args)-1])
+				}
+			}
+		}
+
+		key := route.Method + " " + route.Path
+		if _, found := routes[key]; found {
+			t.Errorf("%s mounted twice", key)
+		}
+		routes[key] = route
+		return true
+	})
+
+	return routes
+}
+
+func stringLiteral(t *testing.T, fset *token.FileSet, expr ast.Expr) string {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		t.Fatalf("%s: Expected a string literal. Hint: Routes and scopes must be literals for the spec to be checked", fset.Position(expr.Pos()))
+	}
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestOperationsMatchMountedRoutes(t *testing.T) {
+	routes := mountedRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("No routes found in main.go")
+	}
+
+	operations := make(map[string]Operation)
+	for _, op := range Operations {
+		key := op.Method + " " + op.Path
+		if _, found := operations[key]; found {
+			t.Errorf("%s described twice in Operations", key)
+		}
+		operations[key] = op
+	}
+
+	for key, route := range routes {
+		op, found := operations[key]
+		if !found {
+			t.Errorf("%s is mounted but not described in Operations", key)
+			continue
+		}
+		if op.Scope != route.Scope {
+			t.Errorf("%s requires scope %q but Operations says %q", key, route.Scope, op.Scope)
+		}
+	}
+
+	for key := range operations {
+		if _, found := routes[key]; !found {
+			t.Errorf("%s is described in Operations but not mounted", key)
+		}
+	}
+}
+
+func TestOperationsDescribeBulkyTypes(t *testing.T) {
+	for _, op := range Operations {
+		if op.Bulky && (op.Request == nil || op.Response == nil) {
+			t.Errorf("%s %s is bulky but misses its request or response type", op.Method, op.Path)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	doc := Generate(Info{Title: "idp", Version: "1"}, nil, Operations)
+
+	item := doc.Paths["/humans"]["post"]
+	if item == nil {
+		t.Fatal("POST /humans missing from document")
+	}
+	if len(item.Scopes) != 1 || item.Scopes[0] != "idp:create:humans" {
+		t.Errorf("POST /humans has scopes %v", item.Scopes)
+	}
+	if item.RequestBody == nil || item.RequestBody.Content["application/json"].Schema.Type != "array" {
+		t.Error("POST /humans request body is not a bulky list")
+	}
+
+	if doc.Paths["/connect/register/{client_id}"]["get"] == nil {
+		t.Error("Path parameters not converted")
+	}
+
+	human := doc.Components.Schemas["Human"]
+	if human == nil {
+		t.Fatal("Human schema missing")
+	}
+	if human.Properties["email"].Format != "email" {
+		t.Errorf("Human.email has format %q", human.Properties["email"].Format)
+	}
+
+	_, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
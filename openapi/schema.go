@@ -0,0 +1,192 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Validate             string             `json:"x-validate,omitempty"` // The validate tag as written on the field, for the rules that have no OpenAPI equivalent
+}
+
+// schemas collects the named struct types met while reflecting, they end up in components.schemas and are referred to by name.
+type schemas map[string]*Schema
+
+func (s schemas) of(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, found := s[t.Name()]; !found {
+			s[t.Name()] = &Schema{} // Placeholder so recursive types terminate
+			s[t.Name()] = s.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	return &Schema{}
+}
+
+func (s schemas) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.fields(t, schema)
+	return schema
+}
+
+func (s schemas) fields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _ := parseJsonTag(field.Tag.Get("json"))
+		if name == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+
+		// Embedded structs without a json name are flattened, as encoding/json does.
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(ft, schema)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := s.of(field.Type)
+		validate := field.Tag.Get("validate")
+		if applyValidateTag(property, validate) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+func parseJsonTag(tag string) (name string, omitempty bool) {
+	parts := strings.Split(tag, ",")
+	for _, p := range parts[1:] {
+		if p == "omitempty" {
+			omitempty = true
+		}
+	}
+	return parts[0], omitempty
+}
+
+// applyValidateTag translates the validator rules that have an OpenAPI equivalent and reports whether the field is required.
+// Rules for the elements of a slice, after dive, are left to x-validate.
+func applyValidateTag(schema *Schema, validate string) (required bool) {
+	if validate == "" {
+		return false
+	}
+
+	// A $ref can not have siblings in OpenAPI 3.0, so only the requirement is taken from the tag.
+	if schema.Ref == "" {
+		schema.Validate = validate
+	}
+
+	for _, rule := range strings.Split(validate, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "dive" {
+			break
+		}
+
+		key, value := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, value = rule[:i], rule[i+1:]
+		}
+
+		switch key {
+		case "required":
+			required = true
+		case "uuid", "uuid4":
+			setFormat(schema, "uuid")
+		case "email":
+			setFormat(schema, "email")
+		case "url", "uri":
+			setFormat(schema, "uri")
+		case "oneof":
+			if schema.Ref == "" {
+				for _, v := range strings.Fields(value) {
+					schema.Enum = append(schema.Enum, v)
+				}
+			}
+		case "min", "max", "gte", "lte":
+			setBound(schema, key, value)
+		}
+	}
+
+	return required
+}
+
+func setFormat(schema *Schema, format string) {
+	if schema.Type == "string" {
+		schema.Format = format
+	}
+}
+
+func setBound(schema *Schema, key string, value string) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+
+	lower := key == "min" || key == "gte"
+	switch schema.Type {
+	case "string":
+		length := int(n)
+		if lower {
+			schema.MinLength = &length
+		} else {
+			schema.MaxLength = &length
+		}
+	case "integer", "number":
+		if lower {
+			schema.Minimum = &n
+		} else {
+			schema.Maximum = &n
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	nats "github.com/nats-io/nats.go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/opensentry/idp/config"
+)
+
+const instrumentationName = "github.com/opensentry/idp"
+
+// Init sets up the global tracer provider from config `tracing.exporter`, which is one of otlp, stdout or empty to disable tracing.
+// The returned shutdown flushes spans not yet exported and must be called before exit.
+func Init(serviceName string) (shutdown func(context.Context) error, err error) {
+	// Trace context is propagated even with tracing disabled, so traces started by callers reach Hydra and AAP unbroken.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch config.GetString("tracing.exporter") {
+	case "":
+		return func(context.Context) error { return nil }, nil
+
+	case "stdout":
+		exporter, err = stdout.NewExporter(stdout.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+
+	case "otlp":
+		opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(config.GetString("tracing.otlp.endpoint"))}
+		if config.GetBool("tracing.otlp.insecure") {
+			opts = append(opts, otlpgrpc.WithInsecure())
+		}
+		exporter, err = otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(opts...))
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New("Unsupported tracing.exporter " + config.GetString("tracing.exporter") + ". Hint: Use otlp or stdout")
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	return tracerProvider.Shutdown, nil
+}
+
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// NewTransport wraps base with a client span for every outbound call, and injects the trace context of the request into its headers.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// contextTransport gives requests that were built without a context the context of the incoming request, so calls made by the hydra and aap clients become children of the handler span.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context() == context.Background() {
+		req = req.WithContext(t.ctx)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// HttpClient returns a client credentials client whose calls, token requests included, are traced as part of ctx.
+func HttpClient(ctx context.Context, cc *clientcredentials.Config) *http.Client {
+	base := http.DefaultClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: &contextTransport{ctx: ctx, base: base}})
+	return cc.Client(ctx)
+}
+
+// natsHeaderCarrier adapts nats headers to the propagation api. Unlike http headers nats headers are case sensitive, so keys are used as given.
+type natsHeaderCarrier nats.Header
+
+func (c natsHeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c natsHeaderCarrier) Set(key string, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c natsHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectNats writes the trace context of ctx into the headers of msg.
+func InjectNats(ctx context.Context, msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, natsHeaderCarrier(msg.Header))
+}
+
+// ExtractNats returns ctx with the trace context found in the headers of msg, if any.
+func ExtractNats(ctx context.Context, msg *nats.Msg) context.Context {
+	if msg.Header == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, natsHeaderCarrier(msg.Header))
+}
@@ -56,6 +56,39 @@ func GetForwardedForIpData(r *http.Request) (IpData, error) {
 	return ret, nil
 }
 
+// ParseCidrs parses a list of CIDR ranges, e.g. the trusted proxies from config.
+func ParseCidrs(cidrs []string) (ranges []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// GetClientIpData returns the address of the client. The forwarded for headers are only used when the request comes from one of the trusted proxies, as anyone else can set them to anything.
+func GetClientIpData(r *http.Request, trustedProxies []*net.IPNet) (IpData, error) {
+	ipData, err := GetRequestIpData(r)
+	if err != nil {
+		return IpData{}, err
+	}
+
+	remote := net.ParseIP(ipData.Ip)
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(remote) {
+			forwardedForIpData, err := GetForwardedForIpData(r)
+			if err == nil && forwardedForIpData.Ip != "" {
+				return forwardedForIpData, nil
+			}
+			break
+		}
+	}
+
+	return ipData, nil
+}
+
 type ipRange struct {
 	start net.IP
 	end   net.IP